@@ -5,8 +5,10 @@ import (
 	"encoding/binary"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
+// 不带有序列号的记录，merge 重写的有效数据以及旧版本写入的非事务记录使用该序列号
 const nonTransactionSeqNo uint64 = 0
 
 var txnFinKey = []byte("txn-fin")
@@ -16,7 +18,13 @@ type WriteBatch struct {
 	options       WriteBatchOptions
 	mu            *sync.Mutex
 	db            *DB
-	pendingWrites map[string]*data.LogRecord // 暂存用户写入的数据
+	pendingWrites map[pendingKey]*data.LogRecord // 暂存用户写入的数据
+}
+
+// 暂存数据的 key，不同 bucket 中相同的 key 需要分别暂存
+type pendingKey struct {
+	bucketId uint32
+	key      string
 }
 
 // NewWriteBatch 初始化 WriteBatch
@@ -25,49 +33,69 @@ func (db *DB) NewWriteBatch(opts WriteBatchOptions) *WriteBatch {
 		options:       opts,
 		mu:            new(sync.Mutex),
 		db:            db,
-		pendingWrites: make(map[string]*data.LogRecord),
+		pendingWrites: make(map[pendingKey]*data.LogRecord),
 	}
 }
 
 // Put 批量写数据
 func (wb *WriteBatch) Put(key []byte, value []byte) error {
+	return wb.BucketPut(wb.db.defaultBucket, key, value)
+}
+
+// Delete 删除数据
+func (wb *WriteBatch) Delete(key []byte) error {
+	return wb.BucketDelete(wb.db.defaultBucket, key)
+}
+
+// BucketPut 批量写数据到指定的 bucket 中，同一个批次可以写入多个 bucket
+func (wb *WriteBatch) BucketPut(b *Bucket, key []byte, value []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if b.db != wb.db {
+		return ErrBucketNotInDB
+	}
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
 	// 暂存 LogRecord
-	logRecord := &data.LogRecord{Key: key, Value: value}
-	wb.pendingWrites[string(key)] = logRecord
+	logRecord := &data.LogRecord{Key: key, Value: value, BucketId: b.id}
+	wb.pendingWrites[pendingKey{bucketId: b.id, key: string(key)}] = logRecord
 	return nil
 }
 
-// Delete 删除数据
-func (wb *WriteBatch) Delete(key []byte) error {
+// BucketDelete 删除指定 bucket 中的数据
+func (wb *WriteBatch) BucketDelete(b *Bucket, key []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if b.db != wb.db {
+		return ErrBucketNotInDB
+	}
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
 	// 数据不存在则直接返回
-	logRecordPos := wb.db.index.Get(key)
+	pk := pendingKey{bucketId: b.id, key: string(key)}
+	logRecordPos := b.index.Get(key)
 	if logRecordPos == nil {
-		if wb.pendingWrites[string(key)] != nil {
-			delete(wb.pendingWrites, string(key))
+		if wb.pendingWrites[pk] != nil {
+			delete(wb.pendingWrites, pk)
 		}
 		return nil
 	}
 
 	// 暂存 LogRecord
-	logRecord := &data.LogRecord{Key: key, Type: data.LogRecordDeleted}
-	wb.pendingWrites[string(key)] = logRecord
+	logRecord := &data.LogRecord{Key: key, Type: data.LogRecordDeleted, BucketId: b.id}
+	wb.pendingWrites[pk] = logRecord
 	return nil
 }
 
 // Commit 提交事务，将暂存的数据写到数据文件，并更新内存索引
 func (wb *WriteBatch) Commit() error {
+	defer wb.db.metrics.batchCommitLatency.observeSince(time.Now())
+	atomic.AddUint64(&wb.db.metrics.batchCommits, 1)
+
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
@@ -88,17 +116,20 @@ func (wb *WriteBatch) Commit() error {
 
 	// 开始写数据到数据文件当中
 	// 创建集合保存临时索引信息，如果写入成功，那么基于该map更新至索引
-	positions := make(map[string]*data.LogRecordPos)
-	for _, record := range wb.pendingWrites {
+	positions := make(map[pendingKey]*data.LogRecordPos)
+	written := make([]pendingKey, 0, len(wb.pendingWrites))
+	for pk, record := range wb.pendingWrites {
 		logRecordPos, err := wb.db.appendLogRecord(&data.LogRecord{
-			Key:   logRecordKeyWithSeq(record.Key, seqNo),
-			Value: record.Value,
-			Type:  record.Type,
+			Key:      logRecordKeyWithSeq(record.Key, seqNo),
+			Value:    record.Value,
+			Type:     record.Type,
+			BucketId: record.BucketId,
 		})
 		if err != nil {
 			return err
 		}
-		positions[string(record.Key)] = logRecordPos
+		positions[pk] = logRecordPos
+		written = append(written, pk)
 	}
 
 	// 写一条标识事务完成的数据
@@ -112,24 +143,27 @@ func (wb *WriteBatch) Commit() error {
 
 	// 根据配置决定是否持久化
 	if wb.options.SyncWrites && wb.db.activeFile != nil {
-		if err := wb.db.activeFile.Sync(); err != nil {
+		if err := wb.db.syncActiveFile(); err != nil {
 			return err
 		}
 	}
 
-	// 更新内存索引
-	for _, record := range wb.pendingWrites {
-		pos := positions[string(record.Key)]
+	// 按照写入数据文件的顺序更新内存索引，订阅方收到变更的顺序和日志中一致
+	for _, pk := range written {
+		record, pos := wb.pendingWrites[pk], positions[pk]
+		idx := wb.db.buckets[record.BucketId].index
 		if record.Type == data.LogRecordNormal {
-			wb.db.index.Put(record.Key, pos)
+			idx.Put(record.Key, pos)
+			wb.db.notifyWatchers(record.BucketId, ChangePut, record.Key, record.Value, seqNo)
 		}
 		if record.Type == data.LogRecordDeleted {
-			wb.db.index.Delete(record.Key)
+			idx.Delete(record.Key)
+			wb.db.notifyWatchers(record.BucketId, ChangeDelete, record.Key, nil, seqNo)
 		}
 	}
 
 	// 清空暂存数据
-	wb.pendingWrites = make(map[string]*data.LogRecord)
+	wb.pendingWrites = make(map[pendingKey]*data.LogRecord)
 
 	return nil
 }
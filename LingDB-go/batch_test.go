@@ -3,13 +3,16 @@ package LingDB_go
 import (
 	"LingDB/LingDB-go/utils"
 	"github.com/stretchr/testify/assert"
+	"os"
 	"testing"
 )
 
 func TestDB_WriteBatch0(t *testing.T) {
 	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-batch-0")
+	opts.DirPath = dir
 	db, err := Open(opts)
-	//defer destroyDB(db)
+	defer destroyDB(db)
 	assert.Nil(t, err)
 	assert.NotNil(t, db)
 
@@ -30,6 +33,8 @@ func TestDB_WriteBatch0(t *testing.T) {
 
 func TestDB_WriteBatch1(t *testing.T) {
 	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-batch-1")
+	opts.DirPath = dir
 	db, err := Open(opts)
 	defer destroyDB(db)
 	assert.Nil(t, err)
@@ -66,6 +71,8 @@ func TestDB_WriteBatch1(t *testing.T) {
 
 func TestDB_WriteBatch2(t *testing.T) {
 	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-batch-2")
+	opts.DirPath = dir
 	db, err := Open(opts)
 	defer destroyDB(db)
 	assert.Nil(t, err)
@@ -98,8 +105,8 @@ func TestDB_WriteBatch2(t *testing.T) {
 	_, err = db2.Get(utils.GetTestKey(1))
 	assert.Equal(t, ErrKeyNotFound, err)
 
-	// 校验序列号
-	assert.Equal(t, uint64(2), db.seqNo)
+	// 校验序列号，非事务的写入同样占用序列号
+	assert.Equal(t, uint64(3), db.seqNo)
 	err = db2.Close()
 	assert.Nil(t, err)
 }
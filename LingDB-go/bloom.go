@@ -0,0 +1,143 @@
+package LingDB_go
+
+import (
+	"LingDB/LingDB-go/data"
+	"LingDB/LingDB-go/fio"
+	"os"
+)
+
+const bloomFilterKey = "bloom.filter"
+
+// 是否开启了布隆过滤器，索引完整保存在内存中时查询索引比检查布隆过滤器更快，不构造布隆过滤器
+func (db *DB) bloomEnabled() bool {
+	return db.options.BloomFilterBitsPerKey > 0 && !db.indexInMemory()
+}
+
+// 索引是否完整保存在内存中
+func (db *DB) indexInMemory() bool {
+	switch db.options.IndexType {
+	case BTREE, ART:
+		return true
+	}
+	return false
+}
+
+// 判断 key 是否可能存在于数据库中，返回 false 时 key 一定不存在，无需访问索引和磁盘
+// 没有布隆过滤器的数据文件都视为可能存在
+func (db *DB) mayContainKey(key []byte) bool {
+	if !db.bloomEnabled() {
+		return true
+	}
+	if _, ok := db.activeKeyHashes[data.BloomHash(key)]; ok {
+		return true
+	}
+	for fid := range db.olderFiles {
+		bf, ok := db.bloomFilters[fid]
+		if !ok || bf.MayContain(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// 记录写入到某个数据文件中的 key，活跃文件的 key 会在封存时构造布隆过滤器
+// 启动时缺失布隆过滤器的旧数据文件也会在加载索引时收集 key 并重新构造
+func (db *DB) collectBloomKey(fileId uint32, key []byte) {
+	if !db.bloomEnabled() {
+		return
+	}
+	if db.activeFile != nil && fileId == db.activeFile.FileId {
+		db.activeKeyHashes[data.BloomHash(key)] = struct{}{}
+		return
+	}
+	if hashes, ok := db.bloomRebuilds[fileId]; ok {
+		db.bloomRebuilds[fileId] = append(hashes, data.BloomHash(key))
+	}
+}
+
+// 活跃文件封存时，根据收集的 key 构造布隆过滤器并持久化到文件中
+func (db *DB) sealBloomFilter(fileId uint32) error {
+	hashes := make([]uint64, 0, len(db.activeKeyHashes))
+	for h := range db.activeKeyHashes {
+		hashes = append(hashes, h)
+	}
+	bf := data.NewBloomFilter(hashes, db.options.BloomFilterBitsPerKey)
+	if err := writeBloomFile(db.options.DirPath, db.cipher, db.fs, fileId, bf); err != nil {
+		return err
+	}
+	db.bloomFilters[fileId] = bf
+	db.activeKeyHashes = make(map[uint64]struct{})
+	return nil
+}
+
+// 启动时加载旧数据文件的布隆过滤器，文件不存在或者已经损坏的，在加载索引时重新构造
+func (db *DB) loadBloomFilters() error {
+	if !db.bloomEnabled() {
+		return nil
+	}
+	for fid := range db.olderFiles {
+		bf, err := readBloomFile(db.options.DirPath, db.cipher, db.fs, fid)
+		if err != nil {
+			db.bloomRebuilds[fid] = nil
+			continue
+		}
+		db.bloomFilters[fid] = bf
+	}
+	return nil
+}
+
+// 索引加载完毕后，为缺失布隆过滤器的旧数据文件构造并持久化布隆过滤器
+func (db *DB) rebuildBloomFilters() error {
+	for fid, hashes := range db.bloomRebuilds {
+		bf := data.NewBloomFilter(hashes, db.options.BloomFilterBitsPerKey)
+		if err := writeBloomFile(db.options.DirPath, db.cipher, db.fs, fid, bf); err != nil {
+			return err
+		}
+		db.bloomFilters[fid] = bf
+		delete(db.bloomRebuilds, fid)
+	}
+	return nil
+}
+
+// 将布隆过滤器写入到文件中，文件以追加的方式写入，因此需要先删除已经存在的文件
+func writeBloomFile(dirPath string, c *data.Cipher, fs fio.FileSystem, fileId uint32, bf *data.BloomFilter) error {
+	fileName := data.GetBloomFileName(dirPath, fileId)
+	if err := fs.Remove(fileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	bloomFile, err := data.OpenBloomFile(dirPath, fileId, c, fs)
+	if err != nil {
+		return err
+	}
+	defer bloomFile.Close()
+
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   []byte(bloomFilterKey),
+		Value: data.EncodeBloomFilter(bf),
+	})
+	if err := bloomFile.Write(encRecord); err != nil {
+		return err
+	}
+	return bloomFile.Sync()
+}
+
+// 从文件中读取布隆过滤器
+func readBloomFile(dirPath string, c *data.Cipher, fs fio.FileSystem, fileId uint32) (*data.BloomFilter, error) {
+	fileName := data.GetBloomFileName(dirPath, fileId)
+	if _, err := fs.Stat(fileName); err != nil {
+		return nil, err
+	}
+
+	bloomFile, err := data.OpenBloomFile(dirPath, fileId, c, fs)
+	if err != nil {
+		return nil, err
+	}
+	defer bloomFile.Close()
+
+	record, _, err := bloomFile.ReadLogRecord(0)
+	if err != nil {
+		return nil, err
+	}
+	return data.DecodeBloomFilter(record.Value)
+}
@@ -0,0 +1,36 @@
+package LingDB_go
+
+import (
+	"LingDB/LingDB-go/data"
+	"LingDB/LingDB-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_BloomFilter_IndexInMemory(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-bloom")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.BloomFilterBitsPerKey = 10
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 索引完整保存在内存中时不构造布隆过滤器，也不收集写入的 key
+	assert.True(t, db.indexInMemory())
+	assert.False(t, db.bloomEnabled())
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.True(t, len(db.olderFiles) > 0)
+	assert.Equal(t, 0, len(db.bloomFilters))
+	assert.Equal(t, 0, len(db.activeKeyHashes))
+	for fid := range db.olderFiles {
+		_, err := os.Stat(data.GetBloomFileName(dir, fid))
+		assert.True(t, os.IsNotExist(err))
+	}
+	_, err = db.Get([]byte("unknown key"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
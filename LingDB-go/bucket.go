@@ -0,0 +1,222 @@
+package LingDB_go
+
+import (
+	"LingDB/LingDB-go/data"
+	"LingDB/LingDB-go/fio"
+	"LingDB/LingDB-go/index"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const defaultBucketId uint32 = 0
+
+// Bucket 数据库中的命名空间，每个 bucket 拥有独立的内存索引，不同 bucket 中相同的 key 互不影响
+// 所有 bucket 共享数据文件，记录中保存了所属 bucket 的 id，merge 和重启时据此更新到对应的索引中
+type Bucket struct {
+	db    *DB
+	id    uint32        // bucket id，默认 bucket 为 0
+	name  string        // bucket 名称，默认 bucket 为空
+	index index.Indexer // bucket 的内存索引
+}
+
+// Bucket 获取指定名称的 bucket，不存在时创建，名称为空时返回默认 bucket
+func (db *DB) Bucket(name string) (*Bucket, error) {
+	if name == "" {
+		return db.defaultBucket, nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if id, ok := db.bucketIds[name]; ok {
+		return db.buckets[id], nil
+	}
+	// 副本中的 bucket 只能从主库同步
+	if db.isReplica() {
+		return nil, ErrReadOnlyReplica
+	}
+
+	// 分配新的 bucket id，并在写入任何数据之前持久化 bucket 名称和 id 的对应关系
+	var id uint32
+	for bucketId := range db.buckets {
+		if bucketId > id {
+			id = bucketId
+		}
+	}
+	id++
+	if err := appendBucketMeta(db.options.DirPath, db.cipher, db.fs, name, id); err != nil {
+		return nil, err
+	}
+	b := db.bucketById(id)
+	b.name = name
+	db.bucketIds[name] = id
+	db.appendSignal.notify()
+	return b, nil
+}
+
+// BucketNames 获取所有 bucket 的名称，不包括默认 bucket
+func (db *DB) BucketNames() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	names := make([]string, 0, len(db.bucketIds))
+	for name := range db.bucketIds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Name bucket 的名称
+func (b *Bucket) Name() string {
+	return b.name
+}
+
+// Put 写入数据到 bucket 中
+func (b *Bucket) Put(key []byte, value []byte) error {
+	return b.db.put(b, key, value)
+}
+
+// Get 获取 bucket 中的数据
+func (b *Bucket) Get(key []byte) ([]byte, error) {
+	return b.db.get(b, key)
+}
+
+// MultiGet 批量获取 bucket 中的数据，返回的结果与传入的 key 一一对应
+func (b *Bucket) MultiGet(keys [][]byte) ([][]byte, []error) {
+	return b.db.multiGet(b, keys)
+}
+
+// Delete 删除 bucket 中的数据
+func (b *Bucket) Delete(key []byte) error {
+	return b.db.delete(b, key)
+}
+
+// NewIterator 初始化遍历 bucket 的迭代器
+func (b *Bucket) NewIterator(opts IteratorOptions) *Iterator {
+	return b.db.newIterator(context.Background(), b, opts)
+}
+
+// NewIteratorContext 初始化遍历 bucket 的迭代器，ctx 被取消后迭代器失效
+func (b *Bucket) NewIteratorContext(ctx context.Context, opts IteratorOptions) *Iterator {
+	return b.db.newIterator(ctx, b, opts)
+}
+
+// ListKeys 获取 bucket 中所有的 key
+func (b *Bucket) ListKeys() [][]byte {
+	keys, _ := b.db.listKeys(context.Background(), b)
+	return keys
+}
+
+// Fold 遍历 bucket 中所有的数据，函数返回 false 时终止遍历
+func (b *Bucket) Fold(fn func(key []byte, value []byte) bool) error {
+	return b.db.fold(context.Background(), b, fn)
+}
+
+// FoldContext 同 Fold，ctx 被取消时停止遍历并返回 ctx.Err()
+func (b *Bucket) FoldContext(ctx context.Context, fn func(key []byte, value []byte) bool) error {
+	return b.db.fold(ctx, b, fn)
+}
+
+// 根据 id 获取 bucket，不存在时创建一个没有名称的 bucket
+// 启动时记录中的 bucket 可能没有对应的名称，仍然需要保留其数据
+func (db *DB) bucketById(id uint32) *Bucket {
+	b, ok := db.buckets[id]
+	if !ok {
+		b = &Bucket{db: db, id: id, index: index.NewIndexer(index.IndexType(db.options.IndexType))}
+		db.buckets[id] = b
+	}
+	return b
+}
+
+// 获取所有 bucket 的索引，merge 时使用，调用方需要持有锁
+func (db *DB) bucketIndexes() map[uint32]index.Indexer {
+	indexes := make(map[uint32]index.Indexer, len(db.buckets))
+	for id, b := range db.buckets {
+		indexes[id] = b.index
+	}
+	return indexes
+}
+
+// 复制所有 bucket 的索引，快照需要保留开始时每个 key 的位置，不受之后写入的影响
+func (db *DB) cloneBucketIndexes() map[uint32]index.Indexer {
+	indexes := make(map[uint32]index.Indexer, len(db.buckets))
+	for id, b := range db.buckets {
+		indexes[id] = b.index.Clone()
+	}
+	return indexes
+}
+
+// 将 bucket 名称和 id 的对应关系追加到 dirPath 目录的 bucket 文件中
+func appendBucketMeta(dirPath string, c *data.Cipher, fs fio.FileSystem, name string, id uint32) error {
+	metaFile, err := data.OpenBucketMetaFile(dirPath, c, fs)
+	if err != nil {
+		return err
+	}
+	defer metaFile.Close()
+
+	buf := make([]byte, binary.MaxVarintLen32)
+	n := binary.PutUvarint(buf, uint64(id))
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   []byte(name),
+		Value: buf[:n],
+	})
+	if err := metaFile.Write(encRecord); err != nil {
+		return err
+	}
+	return metaFile.Sync()
+}
+
+// 从 bucket 文件中加载所有的 bucket
+// 创建 bucket 时崩溃可能在文件末尾留下写了一半的记录，该 bucket 还没有返回给用户，直接截断即可
+// 文件中间的记录损坏时之后的 bucket 无法恢复，返回错误
+func (db *DB) loadBuckets() error {
+	fileName := filepath.Join(db.options.DirPath, data.BucketMetaFileName)
+	if _, err := db.fs.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+
+	metaFile, err := data.OpenBucketMetaFile(db.options.DirPath, db.cipher, db.fs)
+	if err != nil {
+		return err
+	}
+	defer metaFile.Close()
+
+	var offset int64 = 0
+	for {
+		logRecord, size, err := metaFile.ReadLogRecord(offset)
+		if isTornWrite(err) {
+			tail, tailErr := metaFile.TornTail(offset)
+			if tailErr != nil {
+				return tailErr
+			}
+			if !tail {
+				return err
+			}
+		} else if err != nil && err != io.EOF {
+			return err
+		}
+		if err != nil {
+			fileSize, sizeErr := metaFile.Size()
+			if sizeErr != nil {
+				return sizeErr
+			}
+			if fileSize <= offset {
+				return nil
+			}
+			db.logger.Warn("truncate torn write in bucket file", "offset", offset, "err", err)
+			return metaFile.Truncate(offset)
+		}
+
+		id, n := binary.Uvarint(logRecord.Value)
+		if n <= 0 {
+			return ErrDataDirectoryCorrupted
+		}
+		b := db.bucketById(uint32(id))
+		b.name = string(logRecord.Key)
+		db.bucketIds[b.name] = b.id
+		offset += size
+	}
+}
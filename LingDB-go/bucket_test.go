@@ -0,0 +1,198 @@
+package LingDB_go
+
+import (
+	"LingDB/LingDB-go/data"
+	"LingDB/LingDB-go/utils"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_Bucket(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-bucket")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	users, err := db.Bucket("users")
+	assert.Nil(t, err)
+	orders, err := db.Bucket("orders")
+	assert.Nil(t, err)
+	users2, err := db.Bucket("users")
+	assert.Nil(t, err)
+	assert.Equal(t, users, users2)
+	defaultBucket, err := db.Bucket("")
+	assert.Nil(t, err)
+	assert.Equal(t, db.defaultBucket, defaultBucket)
+	assert.Equal(t, []string{"orders", "users"}, db.BucketNames())
+
+	// 相同的 key 在不同的 bucket 中互不影响
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("default")))
+		assert.Nil(t, users.Put(utils.GetTestKey(i), []byte("users")))
+		if i%2 == 0 {
+			assert.Nil(t, orders.Put(utils.GetTestKey(i), []byte("orders")))
+		}
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, users.Delete(utils.GetTestKey(i)))
+	}
+
+	// 跨 bucket 的批量写
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.BucketPut(users, []byte("batch-key"), []byte("users-batch")))
+	assert.Nil(t, wb.BucketPut(orders, []byte("batch-key"), []byte("orders-batch")))
+	assert.Nil(t, wb.BucketDelete(orders, utils.GetTestKey(0)))
+	assert.Nil(t, wb.Put([]byte("batch-key"), []byte("default-batch")))
+	assert.Nil(t, wb.Commit())
+
+	check := func(db *DB) {
+		users, err := db.Bucket("users")
+		assert.Nil(t, err)
+		orders, err := db.Bucket("orders")
+		assert.Nil(t, err)
+
+		assert.Equal(t, 501, len(db.ListKeys()))
+		assert.Equal(t, 401, len(users.ListKeys()))
+		assert.Equal(t, 250, len(orders.ListKeys()))
+
+		val, err := db.Get(utils.GetTestKey(0))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("default"), val)
+		_, err = users.Get(utils.GetTestKey(0))
+		assert.Equal(t, ErrKeyNotFound, err)
+		_, err = orders.Get(utils.GetTestKey(0))
+		assert.Equal(t, ErrKeyNotFound, err)
+		val, err = users.Get(utils.GetTestKey(100))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("users"), val)
+		_, err = orders.Get(utils.GetTestKey(1))
+		assert.Equal(t, ErrKeyNotFound, err)
+
+		for name, b := range map[string]*Bucket{"default": db.defaultBucket, "users": users, "orders": orders} {
+			val, err := b.Get([]byte("batch-key"))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte(name+"-batch"), val)
+		}
+
+		var count int
+		err = orders.Fold(func(key []byte, value []byte) bool {
+			if string(key) != "batch-key" {
+				assert.Equal(t, []byte("orders"), value)
+			}
+			count++
+			return true
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, 250, count)
+
+		iter := users.NewIterator(IteratorOptions{Prefix: []byte("batch")})
+		defer iter.Close()
+		assert.True(t, iter.Valid())
+		assert.Equal(t, []byte("batch-key"), iter.Key())
+		iter.Next()
+		assert.False(t, iter.Valid())
+	}
+	check(db)
+
+	// 重启后 bucket 以及其中的数据都能恢复
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	db = db2
+	assert.Equal(t, []string{"orders", "users"}, db2.BucketNames())
+	check(db2)
+
+	// 从索引快照中恢复
+	assert.Nil(t, db2.Checkpoint())
+	assert.Nil(t, db2.Close())
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	db = db3
+	check(db3)
+
+	// merge 之后数据仍然写入到对应的 bucket 中
+	assert.Nil(t, db3.Merge())
+	assert.Nil(t, db3.Close())
+	db4, err := Open(opts)
+	assert.Nil(t, err)
+	db = db4
+	check(db4)
+
+	// 新的 bucket 不会复用已有的 id
+	logs, err := db4.Bucket("logs")
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), logs.id)
+	assert.Equal(t, 0, len(logs.ListKeys()))
+}
+
+func TestDB_Bucket_OtherDB(t *testing.T) {
+	opts := DefaultOptions
+	dir1, _ := os.MkdirTemp("", "bitcask-go-bucket-1")
+	opts.DirPath = dir1
+	db1, err := Open(opts)
+	defer destroyDB(db1)
+	assert.Nil(t, err)
+
+	dir2, _ := os.MkdirTemp("", "bitcask-go-bucket-2")
+	opts.DirPath = dir2
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+
+	b, err := db2.Bucket("b")
+	assert.Nil(t, err)
+	wb := db1.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Equal(t, ErrBucketNotInDB, wb.BucketPut(b, []byte("key"), []byte("value")))
+	assert.Equal(t, ErrBucketNotInDB, wb.BucketDelete(b, []byte("key")))
+}
+
+func TestDB_Bucket_CorruptedMeta(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-bucket-corrupted")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	for _, name := range []string{"a", "b", "c"} {
+		_, err := db.Bucket(name)
+		assert.Nil(t, err)
+	}
+	c, _ := db.Bucket("c")
+	assert.Nil(t, db.Close())
+
+	fileName := filepath.Join(dir, data.BucketMetaFileName)
+	content, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	buf := make([]byte, binary.MaxVarintLen32)
+	n := binary.PutUvarint(buf, uint64(c.id))
+	lastRecord, _ := data.EncodeLogRecord(&data.LogRecord{Key: []byte("c"), Value: buf[:n]})
+
+	// 中间的记录损坏时无法打开，文件不会被截断
+	corrupted := append([]byte(nil), content...)
+	corrupted[len(corrupted)-len(lastRecord)-1] ^= 0xff
+	assert.Nil(t, os.WriteFile(fileName, corrupted, 0644))
+	_, err = Open(opts)
+	assert.Equal(t, data.ErrInvalidCRC, err)
+	onDisk, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, corrupted, onDisk)
+
+	// 末尾的记录损坏时截断，之前的 bucket 仍然存在
+	corrupted = append([]byte(nil), content...)
+	corrupted[len(corrupted)-1] ^= 0xff
+	assert.Nil(t, os.WriteFile(fileName, corrupted, 0644))
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	db = db2
+	assert.Equal(t, []string{"a", "b"}, db2.BucketNames())
+	onDisk, err = os.ReadFile(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, content[:len(content)-len(lastRecord)], onDisk)
+}
@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"LingDB/LingDB-go/data"
+	"container/list"
+	"sync"
+)
+
+// 每个缓存项除了 key 和 value 之外额外占用的估算字节数
+const entryOverhead = 64
+
+// LRU 有容量上限的读缓存，按照 LogRecordPos 缓存磁盘上读取到的记录
+// 由于 bitcask 追加写的特性，同一个位置上的数据不会被修改，只有在数据文件被删除时才需要失效
+type LRU struct {
+	mu       *sync.Mutex
+	capacity int64                      // 缓存容量上限（字节）
+	size     int64                      // 当前已经使用的容量（字节）
+	ll       *list.List                 // 按访问时间排序的链表，表头为最近访问的数据
+	items    map[cacheKey]*list.Element // 位置 -> 链表节点
+	hits     uint64                     // 命中次数
+	misses   uint64                     // 未命中次数
+}
+
+// Stats 缓存的统计信息
+type Stats struct {
+	Hits   uint64 // 命中次数
+	Misses uint64 // 未命中次数
+	Count  int    // 缓存的记录数量
+	Size   int64  // 已经使用的容量（字节）
+}
+
+type cacheKey struct {
+	fid    uint32
+	offset int64
+}
+
+type entry struct {
+	key    cacheKey
+	record *data.LogRecord
+	size   int64
+}
+
+// NewLRU 初始化 LRU 缓存，capacity 为缓存容量上限（字节）
+func NewLRU(capacity int64) *LRU {
+	return &LRU{
+		mu:       new(sync.Mutex),
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[cacheKey]*list.Element),
+	}
+}
+
+// Get 根据位置获取缓存的记录，返回的记录是缓存数据的拷贝
+func (c *LRU) Get(pos *data.LogRecordPos) (*data.LogRecord, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[cacheKey{fid: pos.Fid, offset: pos.Offset}]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.ll.MoveToFront(elem)
+	return copyRecord(elem.Value.(*entry).record), true
+}
+
+// Put 缓存位置对应的记录，超出容量时淘汰最久未访问的数据
+func (c *LRU) Put(pos *data.LogRecordPos, record *data.LogRecord) {
+	size := int64(len(record.Key)+len(record.Value)) + entryOverhead
+	// 单条记录超过了整个缓存的容量，不缓存
+	if size > c.capacity {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := cacheKey{fid: pos.Fid, offset: pos.Offset}
+	if elem, ok := c.items[key]; ok {
+		c.ll.MoveToFront(elem)
+		return
+	}
+	elem := c.ll.PushFront(&entry{key: key, record: copyRecord(record), size: size})
+	c.items[key] = elem
+	c.size += size
+
+	for c.size > c.capacity {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// RemoveFile 失效某个数据文件中的所有缓存，数据文件被删除时调用
+func (c *LRU) RemoveFile(fid uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, elem := range c.items {
+		if key.fid == fid {
+			c.removeElement(elem)
+		}
+	}
+}
+
+// Stats 获取缓存的统计信息
+func (c *LRU) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{
+		Hits:   c.hits,
+		Misses: c.misses,
+		Count:  c.ll.Len(),
+		Size:   c.size,
+	}
+}
+
+func (c *LRU) removeElement(elem *list.Element) {
+	e := c.ll.Remove(elem).(*entry)
+	delete(c.items, e.key)
+	c.size -= e.size
+}
+
+// 拷贝记录的 Key、Value、Type、BucketId 和 AutoCommit，避免调用方修改缓存中的数据
+// Key 和 Value 都计入缓存占用的容量，其余字段包含在 entryOverhead 中
+func copyRecord(record *data.LogRecord) *data.LogRecord {
+	key := make([]byte, len(record.Key))
+	copy(key, record.Key)
+	value := make([]byte, len(record.Value))
+	copy(value, record.Value)
+	return &data.LogRecord{Key: key, Value: value, Type: record.Type, BucketId: record.BucketId, AutoCommit: record.AutoCommit}
+}
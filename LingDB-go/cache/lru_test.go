@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"LingDB/LingDB-go/data"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLRU_Get_Put(t *testing.T) {
+	c := NewLRU(1024)
+
+	pos := &data.LogRecordPos{Fid: 1, Offset: 10}
+	_, ok := c.Get(pos)
+	assert.False(t, ok)
+
+	c.Put(pos, &data.LogRecord{Key: []byte("a"), Value: []byte("va")})
+	record, ok := c.Get(pos)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("va"), record.Value)
+
+	// 修改返回的数据不会影响缓存
+	record.Value[0] = 'x'
+	record, ok = c.Get(&data.LogRecordPos{Fid: 1, Offset: 10})
+	assert.True(t, ok)
+	assert.Equal(t, []byte("va"), record.Value)
+
+	stats := c.Stats()
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, 1, stats.Count)
+}
+
+func TestLRU_Evict(t *testing.T) {
+	c := NewLRU(3 * (entryOverhead + 8))
+
+	for i := 0; i < 3; i++ {
+		c.Put(&data.LogRecordPos{Fid: 1, Offset: int64(i)}, &data.LogRecord{Value: make([]byte, 8)})
+	}
+	// 访问第一条，使其成为最近访问的数据
+	_, ok := c.Get(&data.LogRecordPos{Fid: 1, Offset: 0})
+	assert.True(t, ok)
+
+	c.Put(&data.LogRecordPos{Fid: 1, Offset: 3}, &data.LogRecord{Value: make([]byte, 8)})
+	assert.Equal(t, 3, c.Stats().Count)
+	_, ok = c.Get(&data.LogRecordPos{Fid: 1, Offset: 1})
+	assert.False(t, ok)
+	_, ok = c.Get(&data.LogRecordPos{Fid: 1, Offset: 0})
+	assert.True(t, ok)
+
+	// 超过容量的数据不缓存
+	c.Put(&data.LogRecordPos{Fid: 2, Offset: 0}, &data.LogRecord{Value: make([]byte, 1024)})
+	_, ok = c.Get(&data.LogRecordPos{Fid: 2, Offset: 0})
+	assert.False(t, ok)
+}
+
+func TestLRU_RemoveFile(t *testing.T) {
+	c := NewLRU(1024)
+	c.Put(&data.LogRecordPos{Fid: 1, Offset: 0}, &data.LogRecord{Value: []byte("a")})
+	c.Put(&data.LogRecordPos{Fid: 2, Offset: 0}, &data.LogRecord{Value: []byte("b")})
+
+	c.RemoveFile(1)
+	_, ok := c.Get(&data.LogRecordPos{Fid: 1, Offset: 0})
+	assert.False(t, ok)
+	_, ok = c.Get(&data.LogRecordPos{Fid: 2, Offset: 0})
+	assert.True(t, ok)
+	assert.Equal(t, int64(1+entryOverhead), c.Stats().Size)
+}
@@ -0,0 +1,91 @@
+package LingDB_go
+
+import "bytes"
+
+// CompareAndSwap 当 key 当前的值等于 expectedValue 时，将其更新为 newValue
+// key 不存在时返回 ErrKeyNotFound，值不相等时返回 ErrValueMismatch
+func (db *DB) CompareAndSwap(key, expectedValue, newValue []byte) error {
+	return db.compareAndSwap(db.defaultBucket, key, expectedValue, newValue)
+}
+
+// PutIfAbsent 当 key 不存在时写入数据，key 已经存在时返回 ErrKeyExists
+func (db *DB) PutIfAbsent(key, value []byte) error {
+	return db.putIfAbsent(db.defaultBucket, key, value)
+}
+
+// DeleteIfEquals 当 key 当前的值等于 expectedValue 时删除 key
+// key 不存在时返回 ErrKeyNotFound，值不相等时返回 ErrValueMismatch
+func (db *DB) DeleteIfEquals(key, expectedValue []byte) error {
+	return db.deleteIfEquals(db.defaultBucket, key, expectedValue)
+}
+
+// CompareAndSwap 同 DB.CompareAndSwap，作用于 bucket 中的数据
+func (b *Bucket) CompareAndSwap(key, expectedValue, newValue []byte) error {
+	return b.db.compareAndSwap(b, key, expectedValue, newValue)
+}
+
+// PutIfAbsent 同 DB.PutIfAbsent，作用于 bucket 中的数据
+func (b *Bucket) PutIfAbsent(key, value []byte) error {
+	return b.db.putIfAbsent(b, key, value)
+}
+
+// DeleteIfEquals 同 DB.DeleteIfEquals，作用于 bucket 中的数据
+func (b *Bucket) DeleteIfEquals(key, expectedValue []byte) error {
+	return b.db.deleteIfEquals(b, key, expectedValue)
+}
+
+// 比较和写入在同一个锁内完成，保证比较之后 key 不会被其他写操作修改
+func (db *DB) compareAndSwap(b *Bucket, key, expectedValue, newValue []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.checkValueLocked(b, key, expectedValue); err != nil {
+		return err
+	}
+	return db.putLocked(b, key, newValue)
+}
+
+func (db *DB) putIfAbsent(b *Bucket, key, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if pos := b.index.Get(key); pos != nil {
+		return ErrKeyExists
+	}
+	return db.putLocked(b, key, value)
+}
+
+func (db *DB) deleteIfEquals(b *Bucket, key, expectedValue []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.checkValueLocked(b, key, expectedValue); err != nil {
+		return err
+	}
+	return db.deleteLocked(b, key)
+}
+
+// 检查 key 当前的值是否等于 expectedValue，调用方需要持有互斥锁
+func (db *DB) checkValueLocked(b *Bucket, key, expectedValue []byte) error {
+	pos := b.index.Get(key)
+	if pos == nil {
+		return ErrKeyNotFound
+	}
+	value, err := db.getValueByPosition(pos)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(value, expectedValue) {
+		return ErrValueMismatch
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package LingDB_go
+
+import (
+	"LingDB/LingDB-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestDB_CompareAndSwap(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-cas")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	key := utils.GetTestKey(1)
+	err = db.CompareAndSwap(key, []byte("a"), []byte("b"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = db.DeleteIfEquals(key, []byte("a"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	err = db.PutIfAbsent(key, []byte("a"))
+	assert.Nil(t, err)
+	err = db.PutIfAbsent(key, []byte("b"))
+	assert.Equal(t, ErrKeyExists, err)
+
+	err = db.CompareAndSwap(key, []byte("b"), []byte("c"))
+	assert.Equal(t, ErrValueMismatch, err)
+	err = db.CompareAndSwap(key, []byte("a"), []byte("c"))
+	assert.Nil(t, err)
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), val)
+
+	err = db.DeleteIfEquals(key, []byte("a"))
+	assert.Equal(t, ErrValueMismatch, err)
+	err = db.DeleteIfEquals(key, []byte("c"))
+	assert.Nil(t, err)
+	_, err = db.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	err = db.PutIfAbsent(nil, []byte("a"))
+	assert.Equal(t, ErrKeyIsEmpty, err)
+
+	// bucket 中的条件写入不影响默认 bucket
+	b, err := db.Bucket("leases")
+	assert.Nil(t, err)
+	assert.Nil(t, b.PutIfAbsent(key, []byte("owner-1")))
+	assert.Nil(t, db.PutIfAbsent(key, []byte("owner-2")))
+	assert.Nil(t, b.CompareAndSwap(key, []byte("owner-1"), []byte("owner-3")))
+	assert.Equal(t, ErrValueMismatch, db.DeleteIfEquals(key, []byte("owner-3")))
+	assert.Nil(t, b.DeleteIfEquals(key, []byte("owner-3")))
+
+	// 重启后数据保持一致
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	db = db2
+	val, err = db2.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("owner-2"), val)
+}
+
+func TestDB_CompareAndSwap_Concurrent(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-cas-concurrent")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 多个协程通过 CAS 对同一个计数器加一，不会丢失更新
+	key := []byte("counter")
+	assert.Nil(t, db.Put(key, []byte("0")))
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; {
+				val, err := db.Get(key)
+				assert.Nil(t, err)
+				n, _ := strconv.Atoi(string(val))
+				err = db.CompareAndSwap(key, val, []byte(strconv.Itoa(n+1)))
+				if err == ErrValueMismatch {
+					continue
+				}
+				assert.Nil(t, err)
+				j++
+			}
+		}()
+	}
+	wg.Wait()
+
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("400"), val)
+}
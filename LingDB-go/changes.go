@@ -0,0 +1,125 @@
+package LingDB_go
+
+import (
+	"LingDB/LingDB-go/data"
+	"context"
+	"io"
+	"sort"
+	"sync/atomic"
+)
+
+// SeqNo 最近一次提交的序列号，Watch 以及 ChangesSince 返回的变更都带有对应的序列号
+func (db *DB) SeqNo() uint64 {
+	return atomic.LoadUint64(&db.seqNo)
+}
+
+// ChangesSince 按照提交的顺序重放默认 bucket 中序列号大于 seqNo 的变更，fn 返回 false 时终止重放
+// 订阅方重启后可以从处理过的最后一个序列号继续消费，再通过 Watch 接收之后的变更
+// 只重放调用时已经提交的变更，需要的变更历史已经被 merge 清理时返回 ErrChangesCompacted
+// 数据库关闭时停止重放并返回 ErrDatabaseClosed，Close 会等待 fn 返回
+func (db *DB) ChangesSince(seqNo uint64, fn func(event *ChangeEvent) bool) error {
+	return db.changesSince(context.Background(), db.defaultBucket, seqNo, fn)
+}
+
+// ChangesSinceContext 同 ChangesSince，ctx 被取消时停止重放并返回 ctx.Err()
+func (db *DB) ChangesSinceContext(ctx context.Context, seqNo uint64, fn func(event *ChangeEvent) bool) error {
+	return db.changesSince(ctx, db.defaultBucket, seqNo, fn)
+}
+
+// ChangesSince 同 DB.ChangesSince，重放 bucket 中的变更
+func (b *Bucket) ChangesSince(seqNo uint64, fn func(event *ChangeEvent) bool) error {
+	return b.db.changesSince(context.Background(), b, seqNo, fn)
+}
+
+func (db *DB) changesSince(ctx context.Context, b *Bucket, seqNo uint64, fn func(event *ChangeEvent) bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// 重放期间持有 replayMu 的读锁，Close 以及副本重置会等待重放结束之后才关闭数据文件
+	db.replayMu.RLock()
+	defer db.replayMu.RUnlock()
+	if db.isClosed() {
+		return ErrDatabaseClosed
+	}
+
+	// 记录当前所有的数据文件以及活跃文件的写入位置，之后写入的数据不会被重放
+	db.mu.RLock()
+	if seqNo < db.changesFloor {
+		db.mu.RUnlock()
+		return ErrChangesCompacted
+	}
+	dataFiles := make([]*data.DataFile, 0, len(db.olderFiles)+1)
+	for _, dataFile := range db.olderFiles {
+		dataFiles = append(dataFiles, dataFile)
+	}
+	sort.Slice(dataFiles, func(i, j int) bool {
+		return dataFiles[i].FileId < dataFiles[j].FileId
+	})
+	activeFile := db.activeFile
+	var activeWriteOff int64
+	if activeFile != nil {
+		dataFiles = append(dataFiles, activeFile)
+		activeWriteOff = activeFile.WriteOff
+	}
+	db.mu.RUnlock()
+
+	// 事务中的变更在读到事务完成标识之后才能确定已经提交
+	transactionChanges := make(map[uint64][]*ChangeEvent)
+	for _, dataFile := range dataFiles {
+		var offset int64 = 0
+		for dataFile != activeFile || offset < activeWriteOff {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			// 数据库正在关闭，尽快释放读锁
+			if db.isClosed() {
+				return ErrDatabaseClosed
+			}
+			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+			offset += size
+
+			// merge 重写的记录没有序列号，不会被重放
+			key, recordSeqNo := parseLogRecordKey(logRecord.Key)
+			if recordSeqNo <= seqNo {
+				continue
+			}
+			if logRecord.Type == data.LogRecordTxnFinished {
+				for _, event := range transactionChanges[recordSeqNo] {
+					if !fn(event) {
+						return nil
+					}
+				}
+				delete(transactionChanges, recordSeqNo)
+				continue
+			}
+			if logRecord.BucketId != b.id {
+				continue
+			}
+
+			event := &ChangeEvent{Key: key, SeqNo: recordSeqNo}
+			switch logRecord.Type {
+			case data.LogRecordNormal:
+				event.Op, event.Value = ChangePut, logRecord.Value
+			case data.LogRecordDeleted:
+				event.Op = ChangeDelete
+			case data.LogRecordMergeOperand:
+				event.Op, event.Value = ChangeMerge, logRecord.Value
+			}
+			if !logRecord.AutoCommit {
+				transactionChanges[recordSeqNo] = append(transactionChanges[recordSeqNo], event)
+				continue
+			}
+			if !fn(event) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
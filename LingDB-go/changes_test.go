@@ -0,0 +1,237 @@
+package LingDB_go
+
+import (
+	"LingDB/LingDB-go/utils"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func collectChanges(t *testing.T, db *DB, seqNo uint64) []*ChangeEvent {
+	var events []*ChangeEvent
+	err := db.ChangesSince(seqNo, func(event *ChangeEvent) bool {
+		events = append(events, event)
+		return true
+	})
+	assert.Nil(t, err)
+	return events
+}
+
+func TestDB_ChangesSince(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-changes")
+	opts.DirPath = dir
+	opts.MergeOperator = Int64AddOperator{}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	watcher := db.Watch(nil)
+	assert.Nil(t, db.Put([]byte("a"), []byte("1")))
+	assert.Nil(t, db.MergeValue([]byte("counter"), EncodeInt64(2)))
+	assert.Nil(t, db.Delete([]byte("a")))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("b"), []byte("2")))
+	assert.Nil(t, wb.Put([]byte("c"), []byte("3")))
+	assert.Nil(t, wb.Commit())
+	// 其他 bucket 中的变更不会出现在默认 bucket 中
+	bucket, err := db.Bucket("other")
+	assert.Nil(t, err)
+	assert.Nil(t, bucket.Put([]byte("a"), []byte("x")))
+	assert.Equal(t, uint64(5), db.SeqNo())
+
+	events := collectChanges(t, db, 0)
+	assert.Equal(t, 5, len(events))
+	assert.Equal(t, ChangePut, events[0].Op)
+	assert.Equal(t, []byte("a"), events[0].Key)
+	assert.Equal(t, []byte("1"), events[0].Value)
+	assert.Equal(t, ChangeMerge, events[1].Op)
+	assert.Equal(t, EncodeInt64(2), events[1].Value)
+	assert.Equal(t, ChangeDelete, events[2].Op)
+	assert.Nil(t, events[2].Value)
+	assert.Equal(t, uint64(4), events[3].SeqNo)
+	assert.Equal(t, uint64(4), events[4].SeqNo)
+
+	// 重放的变更和 Watch 收到的变更一致
+	for _, event := range events {
+		watched := <-watcher.Events()
+		assert.Equal(t, watched.SeqNo, event.SeqNo)
+		assert.Equal(t, watched.Op, event.Op)
+		assert.Equal(t, watched.Key, event.Key)
+	}
+
+	// 从指定的序列号之后继续重放
+	events = collectChanges(t, db, 2)
+	assert.Equal(t, 3, len(events))
+	assert.Equal(t, uint64(3), events[0].SeqNo)
+
+	var bucketEvents []*ChangeEvent
+	err = bucket.ChangesSince(0, func(event *ChangeEvent) bool {
+		bucketEvents = append(bucketEvents, event)
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(bucketEvents))
+	assert.Equal(t, uint64(5), bucketEvents[0].SeqNo)
+
+	// 函数返回 false 时终止重放
+	var count int
+	err = db.ChangesSince(0, func(event *ChangeEvent) bool {
+		count++
+		return false
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	// 重启之后序列号继续递增
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	db = db2
+	assert.Equal(t, uint64(5), db2.SeqNo())
+	assert.Equal(t, 5, len(collectChanges(t, db2, 0)))
+
+	assert.Nil(t, db2.Put([]byte("a"), []byte("3")))
+	events = collectChanges(t, db2, 5)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, uint64(6), events[0].SeqNo)
+}
+
+func TestDB_ChangesSince_MergeRetention(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-changes-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.MergeOperator = ByteAppendOperator{}
+	opts.ChangeRetention = 100
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 500; i++ {
+		key := utils.GetTestKey(i % 50)
+		switch i % 7 {
+		case 0:
+			assert.Nil(t, db.Delete(key))
+		case 1:
+			assert.Nil(t, db.MergeValue(key, []byte(fmt.Sprintf("%d", i))))
+		case 2:
+			wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+			assert.Nil(t, wb.Put(key, utils.RandomValue(64)))
+			assert.Nil(t, wb.Commit())
+		default:
+			assert.Nil(t, db.Put(key, utils.RandomValue(64)))
+		}
+	}
+	lastSeqNo := db.SeqNo()
+	floor := lastSeqNo - opts.ChangeRetention
+	expected := collectChanges(t, db, floor)
+	values := make(map[string][]byte)
+	assert.Nil(t, db.Fold(func(key []byte, value []byte) bool {
+		values[string(key)] = value
+		return true
+	}))
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	db = db2
+	assert.Equal(t, lastSeqNo, db2.SeqNo())
+
+	// merge 之后只保留最近 ChangeRetention 个序列号的变更历史
+	err = db2.ChangesSince(floor-1, func(event *ChangeEvent) bool { return true })
+	assert.Equal(t, ErrChangesCompacted, err)
+	assert.Equal(t, expected, collectChanges(t, db2, floor))
+
+	// 数据没有受到变更历史的影响
+	assert.Equal(t, len(values), len(db2.ListKeys()))
+	for key, value := range values {
+		val, err := db2.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+
+	// 新的写入从 merge 时的序列号继续递增
+	assert.Nil(t, db2.Put([]byte("new"), []byte("value")))
+	events := collectChanges(t, db2, lastSeqNo)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, lastSeqNo+1, events[0].SeqNo)
+
+	// 不保留变更历史时 merge 之前的变更都会被清理
+	err = db2.Close()
+	assert.Nil(t, err)
+	opts.ChangeRetention = 0
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	db = db3
+	assert.Nil(t, db3.Merge())
+	assert.Nil(t, db3.Close())
+	db4, err := Open(opts)
+	assert.Nil(t, err)
+	db = db4
+	err = db4.ChangesSince(lastSeqNo, func(event *ChangeEvent) bool { return true })
+	assert.Equal(t, ErrChangesCompacted, err)
+	assert.Equal(t, 0, len(collectChanges(t, db4, lastSeqNo+1)))
+	val, err := db4.Get([]byte("new"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+	assert.Equal(t, len(values)+1, len(db4.ListKeys()))
+}
+
+func TestDB_ChangesSince_Close(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-changes-close")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+
+	// 重放过程中关闭数据库，Close 等待 fn 返回，之后的重放返回 ErrDatabaseClosed
+	started := make(chan struct{})
+	resume := make(chan struct{})
+	replayErr := make(chan error, 1)
+	count := 0
+	go func() {
+		replayErr <- db.ChangesSince(0, func(event *ChangeEvent) bool {
+			count++
+			if count == 1 {
+				close(started)
+				<-resume
+			}
+			return true
+		})
+	}()
+	<-started
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- db.Close()
+	}()
+	for !db.isClosed() {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-closed:
+		t.Fatal("Close returned while changes were being replayed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(resume)
+	assert.Equal(t, ErrDatabaseClosed, <-replayErr)
+	assert.Equal(t, 1, count)
+	assert.Nil(t, <-closed)
+
+	err = db.ChangesSince(0, func(event *ChangeEvent) bool { return true })
+	assert.Equal(t, ErrDatabaseClosed, err)
+}
@@ -0,0 +1,254 @@
+package LingDB_go
+
+import (
+	"LingDB/LingDB-go/data"
+	"LingDB/LingDB-go/index"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const checkpointMetaKey = "checkpoint.meta"
+
+var errInvalidCheckpoint = errors.New("the index checkpoint file is invalid")
+
+// 索引快照的元数据，记录快照时活跃文件的写入位置以及事务序列号
+type checkpointMeta struct {
+	fid      uint32 // 快照时的活跃文件 id
+	writeOff int64  // 快照时活跃文件的写入偏移量
+	seqNo    uint64 // 快照时的事务序列号
+	count    uint64 // 快照中的索引数量
+}
+
+// Checkpoint 将内存索引的全部内容持久化到快照文件中
+// 重启时加载快照文件，只需要重放快照之后写入的数据，减少重启时间
+func (db *DB) Checkpoint() error {
+	db.checkpointMu.Lock()
+	defer db.checkpointMu.Unlock()
+
+	db.mu.Lock()
+	if db.activeFile == nil {
+		db.mu.Unlock()
+		return nil
+	}
+	// 快照记录的写入位置之前的数据必须已经持久化
+	if err := db.syncActiveFile(); err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	meta := &checkpointMeta{
+		fid:      db.activeFile.FileId,
+		writeOff: db.activeFile.WriteOff,
+		seqNo:    db.seqNo,
+	}
+	// 索引迭代器持有的是索引的快照，释放锁之后写入的数据不会影响快照的内容
+	iterators := make(map[uint32]index.Iterator, len(db.buckets))
+	for id, b := range db.buckets {
+		iterators[id] = b.index.Iterator(false)
+	}
+	db.mu.Unlock()
+	for _, iterator := range iterators {
+		defer iterator.Close()
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			for pos := iterator.Value(); pos != nil; pos = pos.Prev {
+				meta.count++
+			}
+		}
+	}
+
+	// 先写入临时文件，完成后再重命名，避免留下不完整的快照文件
+	tempFileName := filepath.Join(db.options.DirPath, data.CheckpointFileName+data.CheckpointTempSuffix)
+	if err := db.fs.Remove(tempFileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	checkpointFile, err := data.OpenCheckpointFile(db.options.DirPath, true, db.cipher, db.fs)
+	if err != nil {
+		return err
+	}
+	defer checkpointFile.Close()
+
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   []byte(checkpointMetaKey),
+		Value: encodeCheckpointMeta(meta),
+	})
+	if err := checkpointFile.Write(encRecord); err != nil {
+		return err
+	}
+	for bucketId, iterator := range iterators {
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			if err := writeCheckpointEntry(checkpointFile, bucketId, iterator.Key(), iterator.Value()); err != nil {
+				return err
+			}
+		}
+	}
+	if err := checkpointFile.Sync(); err != nil {
+		return err
+	}
+
+	return db.fs.Rename(tempFileName, filepath.Join(db.options.DirPath, data.CheckpointFileName))
+}
+
+// 将一个 key 的索引写入到快照中，合并操作数的链表从最早的记录开始依次写入，加载时按照相同的顺序重新串联
+func writeCheckpointEntry(checkpointFile *data.DataFile, bucketId uint32, key []byte, pos *data.LogRecordPos) error {
+	var chain []*data.LogRecordPos
+	for p := pos; p != nil; p = p.Prev {
+		chain = append(chain, p)
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		typ := data.LogRecordNormal
+		if chain[i].Operand {
+			typ = data.LogRecordMergeOperand
+		}
+		encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+			Key:      key,
+			Value:    data.EncodeLogRecordPos(chain[i]),
+			Type:     typ,
+			BucketId: bucketId,
+		})
+		if err := checkpointFile.Write(encRecord); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 定期生成索引快照，直到数据库关闭
+func (db *DB) runPeriodicCheckpoint(interval time.Duration) {
+	db.checkpointWg.Add(1)
+	go func() {
+		defer db.checkpointWg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := db.Checkpoint(); err != nil {
+					db.logger.Warn("failed to write index checkpoint", "err", err)
+				}
+			case <-db.closeCh:
+				return
+			}
+		}
+	}()
+}
+
+// 从快照文件中加载索引，快照不存在或者无效时返回 false，此时需要从 hint 文件和数据文件中加载索引
+func (db *DB) loadIndexFromCheckpoint(ctx context.Context) (bool, error) {
+	fileName := filepath.Join(db.options.DirPath, data.CheckpointFileName)
+	if _, err := db.fs.Stat(fileName); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	checkpointFile, err := data.OpenCheckpointFile(db.options.DirPath, false, db.cipher, db.fs)
+	if err != nil {
+		return false, err
+	}
+	defer checkpointFile.Close()
+
+	metaRecord, size, err := checkpointFile.ReadLogRecord(0)
+	if err != nil || string(metaRecord.Key) != checkpointMetaKey {
+		return false, nil
+	}
+	meta, err := decodeCheckpointMeta(metaRecord.Value)
+	if err != nil || !db.checkpointUsable(meta) {
+		return false, nil
+	}
+
+	// 先完整读取并校验整个快照，再更新到内存索引中
+	keys := make([][]byte, 0, meta.count)
+	positions := make([]*data.LogRecordPos, 0, meta.count)
+	bucketIds := make([]uint32, 0, meta.count)
+	types := make([]data.LogRecordType, 0, meta.count)
+	offset := size
+	for i := uint64(0); i < meta.count; i++ {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		logRecord, size, err := checkpointFile.ReadLogRecord(offset)
+		if err != nil {
+			return false, nil
+		}
+		keys = append(keys, logRecord.Key)
+		positions = append(positions, data.DecodeLogRecordPos(logRecord.Value))
+		bucketIds = append(bucketIds, logRecord.BucketId)
+		types = append(types, logRecord.Type)
+		offset += size
+	}
+
+	for i, key := range keys {
+		idx := db.bucketById(bucketIds[i]).index
+		if types[i] == data.LogRecordMergeOperand {
+			positions[i].Operand = true
+			positions[i].Prev = idx.Get(key)
+		}
+		idx.Put(key, positions[i])
+		db.collectBloomKey(positions[i].Fid, key)
+	}
+	db.checkpoint = meta
+	return true, nil
+}
+
+// 快照记录的活跃文件必须存在，并且文件长度不小于快照时的写入位置
+func (db *DB) checkpointUsable(meta *checkpointMeta) bool {
+	var dataFile *data.DataFile
+	if db.activeFile != nil && db.activeFile.FileId == meta.fid {
+		dataFile = db.activeFile
+	} else {
+		dataFile = db.olderFiles[meta.fid]
+	}
+	if dataFile == nil {
+		return false
+	}
+	fileSize, err := dataFile.Size()
+	if err != nil {
+		return false
+	}
+	return fileSize >= meta.writeOff
+}
+
+// 启动时从数据文件中重放索引的起始位置，快照对应的文件从快照时的写入位置开始
+func (db *DB) replayStartOffset(fileId uint32) int64 {
+	if db.checkpoint != nil && db.checkpoint.fid == fileId {
+		return db.checkpoint.writeOff
+	}
+	return 0
+}
+
+func encodeCheckpointMeta(meta *checkpointMeta) []byte {
+	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64*3)
+	var index = 0
+	index += binary.PutUvarint(buf[index:], uint64(meta.fid))
+	index += binary.PutVarint(buf[index:], meta.writeOff)
+	index += binary.PutUvarint(buf[index:], meta.seqNo)
+	index += binary.PutUvarint(buf[index:], meta.count)
+	return buf[:index]
+}
+
+func decodeCheckpointMeta(buf []byte) (*checkpointMeta, error) {
+	meta := &checkpointMeta{}
+	var index = 0
+	fid, n := binary.Uvarint(buf[index:])
+	if n <= 0 {
+		return nil, errInvalidCheckpoint
+	}
+	index += n
+	writeOff, n := binary.Varint(buf[index:])
+	if n <= 0 {
+		return nil, errInvalidCheckpoint
+	}
+	index += n
+	seqNo, n := binary.Uvarint(buf[index:])
+	if n <= 0 {
+		return nil, errInvalidCheckpoint
+	}
+	index += n
+	count, n := binary.Uvarint(buf[index:])
+	if n <= 0 {
+		return nil, errInvalidCheckpoint
+	}
+	meta.fid, meta.writeOff, meta.seqNo, meta.count = uint32(fid), writeOff, seqNo, count
+	return meta, nil
+}
@@ -0,0 +1,136 @@
+package LingDB_go
+
+import (
+	"LingDB/LingDB-go/data"
+	"LingDB/LingDB-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDB_Checkpoint(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	err = db.Checkpoint()
+	assert.Nil(t, err)
+	checkpointFid := db.activeFile.FileId
+
+	// 快照之后写入的数据需要重放
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 1000; i < 1200; i++ {
+		err := wb.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	err = wb.Commit()
+	assert.Nil(t, err)
+	seqNo := db.seqNo
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 快照之前的数据文件不会被重放，将其损坏后依然可以正常启动
+	assert.True(t, checkpointFid > 0)
+	_ = os.Remove(data.GetDataHintFileName(dir, 0))
+	err = os.WriteFile(data.GetDataFileName(dir, 0), []byte("corrupted"), 0644)
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db2.checkpoint)
+	assert.Equal(t, seqNo, db2.seqNo)
+	assert.Equal(t, 1100, len(db2.ListKeys()))
+	for i := 100; i < 1200; i++ {
+		if pos := db2.index.Get(utils.GetTestKey(i)); pos.Fid == 0 {
+			continue
+		}
+		_, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	_, err = db2.Get(utils.GetTestKey(50))
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+	db = db2
+}
+
+func TestDB_Checkpoint_Invalid(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint-invalid")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 500; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	err = db.Checkpoint()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 损坏的快照会被忽略
+	checkpointFileName := filepath.Join(dir, data.CheckpointFileName)
+	err = os.Truncate(checkpointFileName, 100)
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db2.checkpoint)
+	assert.Equal(t, 500, len(db2.ListKeys()))
+
+	// merge 之后快照失效
+	err = db2.Checkpoint()
+	assert.Nil(t, err)
+	err = db2.Merge()
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	db = db3
+	assert.Nil(t, db3.checkpoint)
+	_, err = os.Stat(checkpointFileName)
+	assert.True(t, os.IsNotExist(err))
+	for i := 0; i < 500; i++ {
+		_, err := db3.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+}
+
+func TestDB_Checkpoint_Periodic(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint-periodic")
+	opts.DirPath = dir
+	opts.CheckpointInterval = 10 * time.Millisecond
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(64))
+	assert.Nil(t, err)
+	time.Sleep(50 * time.Millisecond)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	_, err = os.Stat(filepath.Join(dir, data.CheckpointFileName))
+	assert.Nil(t, err)
+}
@@ -0,0 +1,113 @@
+package data
+
+import (
+	"LingDB/LingDB-go/fio"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+)
+
+var (
+	ErrInvalidBloomFilter = errors.New("invalid bloom filter data")
+)
+
+const BloomFileNameSuffix = ".bloom"
+
+// BloomFilter 布隆过滤器，每个封存的数据文件对应一个，用于快速判断 key 一定不在某个文件中
+type BloomFilter struct {
+	bits []byte // 位数组
+	k    uint8  // 哈希函数的个数
+}
+
+// BloomHash 计算 key 的哈希值，构造布隆过滤器之前先收集所有 key 的哈希值
+func BloomHash(key []byte) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(key)
+	return h.Sum64()
+}
+
+// NewBloomFilter 根据 key 的哈希值构造布隆过滤器，bitsPerKey 为每个 key 占用的位数
+func NewBloomFilter(hashes []uint64, bitsPerKey int) *BloomFilter {
+	// 最优的哈希函数个数为 bitsPerKey * ln2
+	k := bitsPerKey * 69 / 100
+	if k < 1 {
+		k = 1
+	}
+	if k > 30 {
+		k = 30
+	}
+
+	nBits := len(hashes) * bitsPerKey
+	// key 较少时误判率会很高，因此设置一个最小值
+	if nBits < 64 {
+		nBits = 64
+	}
+	bf := &BloomFilter{
+		bits: make([]byte, (nBits+7)/8),
+		k:    uint8(k),
+	}
+	for _, h := range hashes {
+		bf.add(h)
+	}
+	return bf
+}
+
+// MayContain key 是否可能存在，返回 false 时 key 一定不存在
+func (bf *BloomFilter) MayContain(key []byte) bool {
+	return bf.mayContainHash(BloomHash(key))
+}
+
+// EncodeBloomFilter 对布隆过滤器进行编码
+//
+//	+-----------+-------------+
+//	| k 哈希个数 |    位数组    |
+//	+-----------+-------------+
+//	    1字节         变长
+func EncodeBloomFilter(bf *BloomFilter) []byte {
+	buf := make([]byte, len(bf.bits)+1)
+	buf[0] = bf.k
+	copy(buf[1:], bf.bits)
+	return buf
+}
+
+// DecodeBloomFilter 解码布隆过滤器
+func DecodeBloomFilter(buf []byte) (*BloomFilter, error) {
+	if len(buf) < 2 || buf[0] == 0 {
+		return nil, ErrInvalidBloomFilter
+	}
+	bits := make([]byte, len(buf)-1)
+	copy(bits, buf[1:])
+	return &BloomFilter{bits: bits, k: buf[0]}, nil
+}
+
+// OpenBloomFile 打开数据文件对应的布隆过滤器文件
+func OpenBloomFile(dirPath string, fileId uint32, c *Cipher, fs fio.FileSystem) (*DataFile, error) {
+	return newDataFile(GetBloomFileName(dirPath, fileId), fileId, c, fs)
+}
+
+func GetBloomFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+BloomFileNameSuffix)
+}
+
+// 使用双重哈希模拟 k 个哈希函数
+func (bf *BloomFilter) add(h uint64) {
+	nBits := uint32(len(bf.bits) * 8)
+	h1, h2 := uint32(h), uint32(h>>32)
+	for i := uint32(0); i < uint32(bf.k); i++ {
+		pos := (h1 + i*h2) % nBits
+		bf.bits[pos/8] |= 1 << (pos % 8)
+	}
+}
+
+func (bf *BloomFilter) mayContainHash(h uint64) bool {
+	nBits := uint32(len(bf.bits) * 8)
+	h1, h2 := uint32(h), uint32(h>>32)
+	for i := uint32(0); i < uint32(bf.k); i++ {
+		pos := (h1 + i*h2) % nBits
+		if bf.bits[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,42 @@
+package data
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestBloomFilter_MayContain(t *testing.T) {
+	var hashes []uint64
+	for i := 0; i < 1000; i++ {
+		hashes = append(hashes, BloomHash([]byte(fmt.Sprintf("key-%d", i))))
+	}
+	bf := NewBloomFilter(hashes, 10)
+
+	// 存在的 key 一定返回 true
+	for i := 0; i < 1000; i++ {
+		assert.True(t, bf.MayContain([]byte(fmt.Sprintf("key-%d", i))))
+	}
+
+	// 不存在的 key 误判率应该很低
+	var falsePositive int
+	for i := 1000; i < 11000; i++ {
+		if bf.MayContain([]byte(fmt.Sprintf("key-%d", i))) {
+			falsePositive++
+		}
+	}
+	assert.True(t, falsePositive < 300)
+}
+
+func TestEncodeBloomFilter(t *testing.T) {
+	bf := NewBloomFilter([]uint64{BloomHash([]byte("a")), BloomHash([]byte("b"))}, 10)
+	buf := EncodeBloomFilter(bf)
+
+	bf2, err := DecodeBloomFilter(buf)
+	assert.Nil(t, err)
+	assert.True(t, bf2.MayContain([]byte("a")))
+	assert.True(t, bf2.MayContain([]byte("b")))
+
+	_, err = DecodeBloomFilter(nil)
+	assert.Equal(t, ErrInvalidBloomFilter, err)
+}
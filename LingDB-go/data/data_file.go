@@ -2,11 +2,14 @@ package data
 
 import (
 	"LingDB/LingDB-go/fio"
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
 	"path/filepath"
+	"sync"
 )
 
 var (
@@ -15,8 +18,14 @@ var (
 
 const (
 	DataFileNameSuffix    = ".data"
+	HintFileNameSuffix    = ".hint"
 	HintFileName          = "hint-index"
 	MergeFinishedFileName = "merge-finished"
+	CheckpointFileName    = "index-checkpoint"
+	CheckpointTempSuffix  = ".tmp"
+	BucketMetaFileName    = "buckets"
+	ReplicaMetaFileName   = "replica-meta"
+	ShardMetaFileName     = "shard-meta"
 )
 
 // DataFile 数据文件，抽象存放数据的文件
@@ -24,26 +33,73 @@ type DataFile struct {
 	FileId    uint32        //文件id
 	WriteOff  int64         //文件写到哪了，偏移量
 	IoManager fio.IOManager //io操作接口
+	Header    FileHeader    //文件头部中记录的信息
+
+	encryption *fileEncryption // 文件的加密状态，没有加密时为 nil
+	headerSize int64           // 文件头部的长度，WriteOff 以及记录的位置都不包括头部
+
+	writeBuf     []byte        // 还没有写入文件的数据，位于 WriteOff 之前
+	writeBufOff  int64         // 写缓冲区中的数据在文件中的位置
+	writeBufSize int           // 写缓冲区的容量，为 0 时不开启写缓冲
+	writeBufMu   *sync.RWMutex // 保护写缓冲区，读取和写入可能并发进行
 }
 
 // OpenDataFile 打开新的数据文件
 // 根据文件的配置路径以及文件id就可以拼装文件的url了
-func OpenDataFile(dirPath string, fileId uint32) (*DataFile, error) {
+// c 不为 nil 时新建的文件使用 c 加密，已有的文件按照其头部记录的 key 解密
+func OpenDataFile(dirPath string, fileId uint32, c *Cipher, fs fio.FileSystem) (*DataFile, error) {
+	return OpenDataFileWithIO(dirPath, fileId, c, fs, fio.StandardFIO)
+}
+
+// OpenDataFileWithIO 使用指定类型的文件 IO 打开数据文件
+func OpenDataFileWithIO(dirPath string, fileId uint32, c *Cipher, fs fio.FileSystem, ioType fio.FileIOType) (*DataFile, error) {
 	//初始化IOManager管理器接口
 	fileName := GetDataFileName(dirPath, fileId)
-	return newDataFile(fileName, fileId)
+	return openDataFile(fileName, fileId, c, fs, ioType)
 }
 
 // OpenHintFile 打开 Hint 索引文件
-func OpenHintFile(dirPath string) (*DataFile, error) {
+func OpenHintFile(dirPath string, c *Cipher, fs fio.FileSystem) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, HintFileName)
-	return newDataFile(fileName, 0)
+	return newDataFile(fileName, 0, c, fs)
+}
+
+// OpenDataHintFile 打开单个数据文件对应的 hint 索引文件
+func OpenDataHintFile(dirPath string, fileId uint32, c *Cipher, fs fio.FileSystem) (*DataFile, error) {
+	return newDataFile(GetDataHintFileName(dirPath, fileId), fileId, c, fs)
 }
 
 // OpenMergeFinishedFile 打开标识 merge 完成的文件
-func OpenMergeFinishedFile(dirPath string) (*DataFile, error) {
+func OpenMergeFinishedFile(dirPath string, c *Cipher, fs fio.FileSystem) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, MergeFinishedFileName)
-	return newDataFile(fileName, 0)
+	return newDataFile(fileName, 0, c, fs)
+}
+
+// OpenCheckpointFile 打开索引快照文件，temp 为 true 时打开写入过程中使用的临时文件
+func OpenCheckpointFile(dirPath string, temp bool, c *Cipher, fs fio.FileSystem) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, CheckpointFileName)
+	if temp {
+		fileName += CheckpointTempSuffix
+	}
+	return newDataFile(fileName, 0, c, fs)
+}
+
+// OpenBucketMetaFile 打开记录 bucket 名称和 id 的文件
+func OpenBucketMetaFile(dirPath string, c *Cipher, fs fio.FileSystem) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, BucketMetaFileName)
+	return newDataFile(fileName, 0, c, fs)
+}
+
+// OpenReplicaMetaFile 打开副本记录主库 merge 情况的文件
+func OpenReplicaMetaFile(dirPath string, c *Cipher, fs fio.FileSystem) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, ReplicaMetaFileName)
+	return newDataFile(fileName, 0, c, fs)
+}
+
+// OpenShardMetaFile 打开记录分片数量的文件
+func OpenShardMetaFile(dirPath string, c *Cipher, fs fio.FileSystem) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, ShardMetaFileName)
+	return newDataFile(fileName, 0, c, fs)
 }
 
 func GetDataFileName(dirPath string, fileId uint32) string {
@@ -51,23 +107,85 @@ func GetDataFileName(dirPath string, fileId uint32) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataFileNameSuffix)
 }
 
-func newDataFile(fileName string, fileId uint32) (*DataFile, error) {
+func GetDataHintFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+HintFileNameSuffix)
+}
+
+func newDataFile(fileName string, fileId uint32, c *Cipher, fs fio.FileSystem) (*DataFile, error) {
+	return openDataFile(fileName, fileId, c, fs, fio.StandardFIO)
+}
+
+// 通过文件系统 fs 打开文件，fs 为 nil 时使用操作系统的文件系统
+func openDataFile(fileName string, fileId uint32, c *Cipher, fs fio.FileSystem, ioType fio.FileIOType) (*DataFile, error) {
+	if fs == nil {
+		fs = fio.OS
+	}
 	//初始化IOManager管理器接口
-	ioManager, err := fio.NewIOManager(fileName)
+	ioManager, err := fs.OpenFile(fileName, ioType)
 	if err != nil {
 		return nil, err
 	}
-	return &DataFile{
-		FileId:    fileId,
-		WriteOff:  0,
-		IoManager: ioManager,
-	}, nil
+	df := &DataFile{
+		FileId:     fileId,
+		WriteOff:   0,
+		IoManager:  ioManager,
+		writeBufMu: new(sync.RWMutex),
+	}
+	if err := df.initHeader(c); err != nil {
+		_ = ioManager.Close()
+		return nil, err
+	}
+	// 默认追加到已有数据的末尾，活跃文件的写入位置在加载索引时重新确定
+	size, err := df.Size()
+	if err != nil {
+		_ = ioManager.Close()
+		return nil, err
+	}
+	df.WriteOff = size
+	return df, nil
+}
+
+// Size 文件中数据的长度，不包括文件头部
+// 写缓冲区中还没有写入文件的数据也属于文件的数据
+func (df *DataFile) Size() (int64, error) {
+	size, err := df.IoManager.Size()
+	if err != nil {
+		return 0, err
+	}
+	size -= df.headerSize
+	if df.writeBufSize > 0 {
+		df.writeBufMu.RLock()
+		if end := df.writeBufOff + int64(len(df.writeBuf)); len(df.writeBuf) > 0 && end > size {
+			size = end
+		}
+		df.writeBufMu.RUnlock()
+	}
+	return size, nil
 }
 
 // ReadLogRecord 传入文件偏移量，返回解析后的记录、这条记录的长度、err
+// 按照文件头部中的版本选择记录的解析方式
 func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
+	if df.encryption != nil {
+		// 加密的文件中每条记录单独加密，各个版本的格式相同
+		return df.readSealedLogRecord(offset)
+	}
+	switch df.Header.Version {
+	case FileVersionLegacy, FileVersion1:
+		// 旧版本的文件没有预先分配的空间，全为 0 的头部表示读取到了文件末尾
+		return df.readLogRecord(offset, true)
+	case FileVersion2:
+		return df.readLogRecord(offset, false)
+	default:
+		return nil, 0, ErrUnsupportedFileVersion
+	}
+}
+
+// 读取文件中的记录，各个版本的记录格式相同，zeroIsEOF 为 true 时全为 0 的头部表示文件结束
+// 版本 2 的文件中全为 0 的头部无法通过 crc 校验，只有日志结束标识或者文件末尾表示读取结束
+func (df *DataFile) readLogRecord(offset int64, zeroIsEOF bool) (*LogRecord, int64, error) {
 	//获取文件最大长度，用于判断是否读溢出
-	fileSize, err := df.IoManager.Size()
+	fileSize, err := df.Size()
 	if err != nil {
 		return nil, 0, err
 	}
@@ -90,15 +208,21 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	if header == nil {
 		return nil, 0, io.EOF
 	}
-	if header.crc == 0 && header.keySize == 0 && header.valueSize == 0 {
+	if zeroIsEOF && header.crc == 0 && header.keySize == 0 && header.valueSize == 0 {
 		return nil, 0, io.EOF
 	}
 
 	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
 	var recordSize = headerSize + keySize + valueSize
+	if !zeroIsEOF && offset+recordSize > fileSize {
+		// 记录的长度超出了文件的末尾，是写了一半的记录或者损坏的数据
+		return nil, 0, io.ErrUnexpectedEOF
+	}
 
 	logRecord := &LogRecord{
-		Type: header.recordType,
+		Type:       header.recordType,
+		BucketId:   header.bucketId,
+		AutoCommit: header.autoCommit,
 	}
 	//读取实际的key和value值
 	if keySize > 0 || valueSize > 0 {
@@ -117,11 +241,110 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	if crc != header.crc {
 		return nil, 0, ErrInvalidCRC
 	}
+	if logRecord.Type == LogRecordEndOfLog {
+		return nil, 0, io.EOF
+	}
 	return logRecord, recordSize, nil
 }
 
+// 读取加密文件中单独加密的一条记录，认证失败时返回 ErrInvalidCRC
+func (df *DataFile) readSealedLogRecord(offset int64) (*LogRecord, int64, error) {
+	encRecord, size, err := df.openSealedRecord(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	logRecord, err := decodeSealedRecord(encRecord)
+	if err != nil {
+		return nil, 0, err
+	}
+	return logRecord, size, nil
+}
+
+// 解码解密之后的一条记录，读取到日志结束标识时返回 io.EOF
+func decodeSealedRecord(encRecord []byte) (*LogRecord, error) {
+	logRecord, recordSize, err := DecodeLogRecord(encRecord)
+	if err != nil || recordSize != int64(len(encRecord)) {
+		return nil, ErrInvalidCRC
+	}
+	if logRecord.Type == LogRecordEndOfLog {
+		return nil, io.EOF
+	}
+	return logRecord, nil
+}
+
+// 读取并解密 offset 处的一条记录，返回编码后的记录以及其在文件中占用的长度
+func (df *DataFile) openSealedRecord(offset int64) ([]byte, int64, error) {
+	fileSize, err := df.Size()
+	if err != nil {
+		return nil, 0, err
+	}
+	if offset >= fileSize {
+		return nil, 0, io.EOF
+	}
+	if offset+recordFrameHeaderSize > fileSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	frameHeader, err := df.readNBytes(recordFrameHeaderSize, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	size := recordFrameHeaderSize + int64(binary.LittleEndian.Uint32(frameHeader))
+	if offset+size > fileSize {
+		// 记录的长度超出了文件的末尾，是写了一半的记录或者损坏的数据
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	frame, err := df.readNBytes(size, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	encRecord, err := df.encryption.openRecord(frame, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	return encRecord, size, nil
+}
+
+// RecordOverhead 加密时每条记录在文件中额外占用的长度，没有加密的文件为 0
+func (df *DataFile) RecordOverhead() int64 {
+	if df.encryption != nil {
+		return recordFrameOverhead
+	}
+	return 0
+}
+
+// ReadRawLogRecord 读取 offset 处一条记录编码后的完整字节以及其在文件中占用的长度，读取前会校验记录的 crc
+// 加密文件中返回的是解密之后的记录
+func (df *DataFile) ReadRawLogRecord(offset int64) ([]byte, int64, error) {
+	if df.encryption != nil {
+		encRecord, size, err := df.openSealedRecord(offset)
+		if err == nil {
+			_, err = decodeSealedRecord(encRecord)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		return encRecord, size, nil
+	}
+	_, size, err := df.ReadLogRecord(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	encRecord, err := df.readNBytes(size, offset)
+	return encRecord, size, err
+}
+
+// Write 在 WriteOff 处写入数据，文件中之后的空间可能是预先分配的
+// 开启写缓冲时数据先追加到缓冲区中，缓冲区写满、Sync 或者 Close 时才写入文件，Write 返回后可以复用 buf
+// 加密的文件中 buf 必须是一条完整的记录，记录单独加密之后写入
 func (df *DataFile) Write(buf []byte) error {
-	n, err := df.IoManager.Write(buf)
+	buf, err := df.sealRecord(buf, df.WriteOff)
+	if err != nil {
+		return err
+	}
+	if df.writeBufSize > 0 {
+		return df.bufferedWrite(buf)
+	}
+	n, err := df.writeAt(buf, df.WriteOff)
 	if err != nil {
 		return err
 	}
@@ -130,28 +353,249 @@ func (df *DataFile) Write(buf []byte) error {
 	return nil
 }
 
+// EnableWriteBuffer 开启容量为 size 的写缓冲，需要在文件被并发访问之前调用
+func (df *DataFile) EnableWriteBuffer(size int) {
+	if size <= 0 {
+		return
+	}
+	df.writeBufSize = size
+	df.writeBuf = make([]byte, 0, size)
+}
+
+// 追加到写缓冲区，缓冲区放不下时先将缓冲区写入文件，超过缓冲区容量的数据直接写入文件
+func (df *DataFile) bufferedWrite(buf []byte) error {
+	df.writeBufMu.Lock()
+	defer df.writeBufMu.Unlock()
+	if len(df.writeBuf)+len(buf) > df.writeBufSize {
+		if err := df.flushWriteBuf(); err != nil {
+			return err
+		}
+	}
+	if len(buf) >= df.writeBufSize {
+		if _, err := df.writeAt(buf, df.WriteOff); err != nil {
+			return err
+		}
+	} else {
+		if len(df.writeBuf) == 0 {
+			df.writeBufOff = df.WriteOff
+		}
+		df.writeBuf = append(df.writeBuf, buf...)
+	}
+	df.WriteOff += int64(len(buf))
+	return nil
+}
+
+// Flush 将写缓冲区中的数据写入文件，没有开启写缓冲时不做任何处理
+func (df *DataFile) Flush() error {
+	if df.writeBufSize == 0 {
+		return nil
+	}
+	df.writeBufMu.Lock()
+	defer df.writeBufMu.Unlock()
+	return df.flushWriteBuf()
+}
+
+// 将写缓冲区写入文件，调用方需要持有写缓冲区的锁
+func (df *DataFile) flushWriteBuf() error {
+	if len(df.writeBuf) == 0 {
+		return nil
+	}
+	if _, err := df.writeAt(df.writeBuf, df.writeBufOff); err != nil {
+		return err
+	}
+	df.writeBuf = df.writeBuf[:0]
+	return nil
+}
+
+// 加密写入到 offset 处的一条记录，没有加密时原样返回
+func (df *DataFile) sealRecord(encRecord []byte, offset int64) ([]byte, error) {
+	if df.encryption == nil {
+		return encRecord, nil
+	}
+	return df.encryption.sealRecord(encRecord, offset)
+}
+
+// 在 offset 处写入数据，offset 不包括文件头部
+func (df *DataFile) writeAt(buf []byte, offset int64) (int, error) {
+	return df.IoManager.WriteAt(buf, offset+df.headerSize)
+}
+
+// Preallocate 为文件预先分配 size 大小的数据空间，只有版本 2 及之后的文件能够识别预先分配的空间
+func (df *DataFile) Preallocate(size int64) error {
+	if df.Header.Version < FileVersion2 {
+		return nil
+	}
+	return df.IoManager.Preallocate(size + df.headerSize)
+}
+
+// MarkEndOfLog WriteOff 之后还有空间时在 WriteOff 处写入日志结束标识，不会移动写入位置
+// 打开文件时读取到结束标识即可确定写入位置，不需要依赖之后的空间全为 0
+// 之后的写入会覆盖结束标识，加密的文件中结束标识和其他记录一样使用随机的 nonce 单独加密，覆盖时不会复用密钥流
+func (df *DataFile) MarkEndOfLog() error {
+	if err := df.Flush(); err != nil {
+		return err
+	}
+	size, err := df.Size()
+	if err != nil || size <= df.WriteOff || df.Header.Version < FileVersion2 {
+		return err
+	}
+	encRecord, _ := EncodeLogRecord(&LogRecord{Type: LogRecordEndOfLog})
+	if encRecord, err = df.sealRecord(encRecord, df.WriteOff); err != nil {
+		return err
+	}
+	_, err = df.writeAt(encRecord, df.WriteOff)
+	return err
+}
+
+// Seal 封存文件，截断 WriteOff 之后预先分配的空间以及结束标识，封存之后文件的长度就是数据的长度
+func (df *DataFile) Seal() error {
+	if err := df.Flush(); err != nil {
+		return err
+	}
+	size, err := df.Size()
+	if err != nil || size <= df.WriteOff {
+		return err
+	}
+	return df.Truncate(df.WriteOff)
+}
+
+// Unwritten offset 之后是否为没有写入过数据的空间，即日志结束标识或者全为 0 的预先分配的空间
+func (df *DataFile) Unwritten(offset int64) (bool, error) {
+	fileSize, err := df.Size()
+	if err != nil || offset >= fileSize {
+		return true, err
+	}
+	n := fileSize - offset
+	if n > maxLogRecordHeaderSize {
+		n = maxLogRecordHeaderSize
+	}
+	// 预先分配的空间在磁盘上全为 0，需要检查解密之前的数据
+	buf := make([]byte, n)
+	if _, err := df.IoManager.Read(buf, offset+df.headerSize); err != nil {
+		return false, err
+	}
+	if bytes.Count(buf, []byte{0}) == len(buf) {
+		return true, nil
+	}
+	if df.encryption != nil {
+		_, _, err := df.readSealedLogRecord(offset)
+		return err == io.EOF, nil
+	}
+	endOfLog, _ := EncodeLogRecord(&LogRecord{Type: LogRecordEndOfLog})
+	return bytes.HasPrefix(buf, endOfLog), nil
+}
+
+// TornTail offset 处无法读取的记录是否为文件末尾写了一半的记录
+// 记录的结束位置到达了文件末尾，或者之后只有日志结束标识以及预先分配的空间时才是末尾的记录，否则是文件中间的数据损坏
+func (df *DataFile) TornTail(offset int64) (bool, error) {
+	fileSize, err := df.Size()
+	if err != nil {
+		return false, err
+	}
+	if offset >= fileSize {
+		return true, nil
+	}
+	size, err := df.recordSize(offset, fileSize)
+	if err != nil {
+		return false, err
+	}
+	if offset+size >= fileSize {
+		return true, nil
+	}
+	return df.Unwritten(offset + size)
+}
+
+// 根据记录的头部得到 offset 处记录在文件中占用的长度，不校验记录本身，头部不完整时为到文件末尾的长度
+func (df *DataFile) recordSize(offset, fileSize int64) (int64, error) {
+	if df.encryption != nil {
+		if offset+recordFrameHeaderSize > fileSize {
+			return fileSize - offset, nil
+		}
+		frameHeader, err := df.readNBytes(4, offset)
+		if err != nil {
+			return 0, err
+		}
+		return recordFrameHeaderSize + int64(binary.LittleEndian.Uint32(frameHeader)), nil
+	}
+	headerBytes := fileSize - offset
+	if headerBytes > maxLogRecordHeaderSize {
+		headerBytes = maxLogRecordHeaderSize
+	}
+	headerBuf, err := df.readNBytes(headerBytes, offset)
+	if err != nil {
+		return 0, err
+	}
+	header, headerSize := decodeLogRecordHeader(headerBuf)
+	if header == nil {
+		return fileSize - offset, nil
+	}
+	return headerSize + int64(header.keySize) + int64(header.valueSize), nil
+}
+
 // WriteHintRecord 写入索引信息到 hint 文件中
 func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
+	return df.WriteBucketHintRecord(0, key, pos)
+}
+
+// WriteBucketHintRecord 写入指定 bucket 中 key 的索引信息到 hint 文件中
+func (df *DataFile) WriteBucketHintRecord(bucketId uint32, key []byte, pos *LogRecordPos) error {
 	record := &LogRecord{
-		Key:   key,
-		Value: EncodeLogRecordPos(pos),
+		Key:      key,
+		Value:    EncodeLogRecordPos(pos),
+		BucketId: bucketId,
 	}
 	encRecord, _ := EncodeLogRecord(record)
 	return df.Write(encRecord)
 }
 
-// Sync 持久化到硬盘
+// Truncate 将数据文件截断到指定的大小，并将写入位置移动到文件末尾
+func (df *DataFile) Truncate(size int64) error {
+	if err := df.Flush(); err != nil {
+		return err
+	}
+	if err := df.IoManager.Truncate(size + df.headerSize); err != nil {
+		return err
+	}
+	df.WriteOff = size
+	return nil
+}
+
+// Sync 将写缓冲区中的数据写入文件并持久化到硬盘
 func (df *DataFile) Sync() error {
+	if err := df.Flush(); err != nil {
+		return err
+	}
 	return df.IoManager.Sync()
 }
 
 func (df *DataFile) Close() error {
+	if err := df.Flush(); err != nil {
+		_ = df.IoManager.Close()
+		return err
+	}
 	return df.IoManager.Close()
 }
 
-// 读取N个字节
+// 读取N个字节，写缓冲区中的部分直接从缓冲区中复制
 func (df *DataFile) readNBytes(n int64, offset int64) (b []byte, err error) {
 	b = make([]byte, n)
-	_, err = df.IoManager.Read(b, offset)
+	diskN := n
+	if df.writeBufSize > 0 {
+		df.writeBufMu.RLock()
+		defer df.writeBufMu.RUnlock()
+		if len(df.writeBuf) > 0 && offset+n > df.writeBufOff {
+			if diskN = df.writeBufOff - offset; diskN < 0 {
+				diskN = 0
+			}
+			start := offset + diskN - df.writeBufOff
+			if start > int64(len(df.writeBuf)) || int64(copy(b[diskN:], df.writeBuf[start:])) < n-diskN {
+				return b, io.EOF
+			}
+		}
+	}
+	if diskN == 0 {
+		return b, nil
+	}
+	_, err = df.IoManager.Read(b[:diskN], offset+df.headerSize)
 	return
 }
@@ -2,11 +2,14 @@ package data
 
 import (
 	"github.com/stretchr/testify/assert"
+	"os"
 	"testing"
 )
 
 func TestDataFile_Close(t *testing.T) {
-	dataFile, err := OpenDataFile("../../db_data", 123)
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file-close")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 123, nil, nil)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -18,7 +21,9 @@ func TestDataFile_Close(t *testing.T) {
 }
 
 func TestDataFile_Sync(t *testing.T) {
-	dataFile, err := OpenDataFile("../../db_data", 456)
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file-sync")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 456, nil, nil)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -30,7 +35,9 @@ func TestDataFile_Sync(t *testing.T) {
 }
 
 func TestDataFile_Write(t *testing.T) {
-	dataFile, err := OpenDataFile("../../db_data", 0)
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file-write")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, nil, nil)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -45,21 +52,25 @@ func TestDataFile_Write(t *testing.T) {
 }
 
 func TestOpenDataFile(t *testing.T) {
-	dataFile1, err := OpenDataFile("../../db_data", 0)
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file-open")
+	defer os.RemoveAll(dir)
+	dataFile1, err := OpenDataFile(dir, 0, nil, nil)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile1)
 
-	dataFile2, err := OpenDataFile("../../db_data", 111)
+	dataFile2, err := OpenDataFile(dir, 111, nil, nil)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile2)
 
-	dataFile3, err := OpenDataFile("../../db_data", 111)
+	dataFile3, err := OpenDataFile(dir, 111, nil, nil)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile3)
 }
 
 func TestDataFile_ReadLogRecord(t *testing.T) {
-	dataFile, err := OpenDataFile("../../db_data", 6666)
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file-read")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 6666, nil, nil)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
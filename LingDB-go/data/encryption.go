@@ -0,0 +1,148 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var (
+	ErrInvalidEncryptionKey = errors.New("the encryption key must be 16, 24 or 32 bytes")
+	ErrUnknownEncryptionKey = errors.New("the file is encrypted with an unknown key")
+)
+
+// Cipher 文件的加密方式，每个文件在头部记录随机生成的 iv 以及加密使用的 key 的 id，可以使用旧的 key 读取之前写入的文件
+// 使用 key 和 iv 派生出文件自己的 key，每条记录单独使用 AES-GCM 加密，能够发现对密文的篡改
+type Cipher struct {
+	current *encryptionKey
+	keys    map[uint64]*encryptionKey
+}
+
+type encryptionKey struct {
+	id  uint64
+	key []byte
+}
+
+// NewCipher 使用 key 加密新的文件，oldKeys 只用于读取之前使用旧的 key 加密的文件
+func NewCipher(key []byte, oldKeys ...[]byte) (*Cipher, error) {
+	c := &Cipher{keys: make(map[uint64]*encryptionKey)}
+	for i, k := range append([][]byte{key}, oldKeys...) {
+		if _, err := aes.NewCipher(k); err != nil {
+			return nil, ErrInvalidEncryptionKey
+		}
+		ek := &encryptionKey{id: encryptionKeyId(k), key: k}
+		if i == 0 {
+			c.current = ek
+		}
+		c.keys[ek.id] = ek
+	}
+	return c, nil
+}
+
+// 使用 key 计算其 id，文件中只记录 id，不会泄露 key 本身
+func encryptionKeyId(key []byte) uint64 {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("LingDB encryption key id"))
+	return binary.LittleEndian.Uint64(mac.Sum(nil))
+}
+
+// 一个文件的加密状态
+type fileEncryption struct {
+	keyId uint64
+	key   *encryptionKey
+	iv    [aes.BlockSize]byte
+	aead  cipher.AEAD // 加密记录使用的 AES-GCM
+}
+
+// 使用当前的 key 以及随机的 iv 加密新的文件
+func (c *Cipher) newFileEncryption() (*fileEncryption, error) {
+	fe := &fileEncryption{keyId: c.current.id, key: c.current}
+	if _, err := io.ReadFull(rand.Reader, fe.iv[:]); err != nil {
+		return nil, err
+	}
+	if err := fe.enableAEAD(); err != nil {
+		return nil, err
+	}
+	return fe, nil
+}
+
+// 使用头部中记录的 key id 以及 iv 解密已有的文件
+func (c *Cipher) fileEncryption(keyId uint64, iv []byte) (*fileEncryption, error) {
+	if c == nil {
+		return nil, ErrUnknownEncryptionKey
+	}
+	ek, ok := c.keys[keyId]
+	if !ok {
+		return nil, ErrUnknownEncryptionKey
+	}
+	fe := &fileEncryption{keyId: ek.id, key: ek}
+	copy(fe.iv[:], iv)
+	if err := fe.enableAEAD(); err != nil {
+		return nil, err
+	}
+	return fe, nil
+}
+
+// 使用 key 和文件的 iv 派生出文件自己的 key，开启记录的 AES-GCM 加密
+// 不同文件的记录使用不同的 key 加密，随机 nonce 只需要在一个文件中不重复
+func (fe *fileEncryption) enableAEAD() error {
+	mac := hmac.New(sha256.New, fe.key.key)
+	mac.Write([]byte("LingDB file key"))
+	mac.Write(fe.iv[:])
+	block, err := aes.NewCipher(mac.Sum(nil)[:len(fe.key.key)])
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	fe.aead = aead
+	return nil
+}
+
+// 加密文件中的一条记录，明文为编码后的完整记录
+//
+//	+-----------+-----------+--------------------------+
+//	| 密文长度   |   nonce   |   密文以及认证 tag         |
+//	+-----------+-----------+--------------------------+
+//	   4字节        12字节      记录长度 + 16字节
+//
+// 每次写入都使用随机生成的 nonce，同一个位置重新写入的记录也不会复用密钥流
+// 记录在文件中的位置作为附加数据参与认证，记录被移动到其他位置之后无法通过认证
+const (
+	recordFrameHeaderSize = 4 + 12
+	recordFrameOverhead   = recordFrameHeaderSize + 16
+)
+
+// 加密写入到 offset 处的一条记录，返回写入文件的完整数据
+func (fe *fileEncryption) sealRecord(record []byte, offset int64) ([]byte, error) {
+	frame := make([]byte, recordFrameHeaderSize, recordFrameOverhead+len(record))
+	binary.LittleEndian.PutUint32(frame[:4], uint32(len(record)+fe.aead.Overhead()))
+	nonce := frame[4:recordFrameHeaderSize]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return fe.aead.Seal(frame, nonce, record, recordAdditionalData(offset)), nil
+}
+
+// 解密 offset 处的一条记录，frame 为写入文件的完整数据，认证失败时返回 ErrInvalidCRC
+func (fe *fileEncryption) openRecord(frame []byte, offset int64) ([]byte, error) {
+	nonce := frame[4:recordFrameHeaderSize]
+	record, err := fe.aead.Open(nil, nonce, frame[recordFrameHeaderSize:], recordAdditionalData(offset))
+	if err != nil {
+		return nil, ErrInvalidCRC
+	}
+	return record, nil
+}
+
+func recordAdditionalData(offset int64) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, uint64(offset))
+	return buf
+}
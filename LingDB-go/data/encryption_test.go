@@ -0,0 +1,114 @@
+package data
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDataFile_Encryption(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption")
+	defer os.RemoveAll(dir)
+	key := bytes.Repeat([]byte{1}, 32)
+	c, err := NewCipher(key)
+	assert.Nil(t, err)
+
+	dataFile, err := OpenDataFile(dir, 0, c, nil)
+	assert.Nil(t, err)
+	assert.True(t, dataFile.Header.Encrypted)
+	var offsets []int64
+	for i := 0; i < 100; i++ {
+		encRecord, _ := EncodeLogRecord(&LogRecord{
+			Key:   []byte("secret-key"),
+			Value: bytes.Repeat([]byte("secret-value"), i),
+		})
+		offsets = append(offsets, dataFile.WriteOff)
+		assert.Nil(t, dataFile.Write(encRecord))
+	}
+	assert.Nil(t, dataFile.Close())
+
+	// 文件中没有明文，记录的位置不包括头部
+	content, err := os.ReadFile(GetDataFileName(dir, 0))
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(content, []byte("secret")))
+	dataFile, err = OpenDataFile(dir, 0, c, nil)
+	assert.Nil(t, err)
+	size, err := dataFile.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(content))-fileHeaderSize, size)
+	for i, offset := range offsets {
+		record, _, err := dataFile.ReadLogRecord(offset)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("secret-key"), record.Key)
+		assert.Equal(t, bytes.Repeat([]byte("secret-value"), i), record.Value)
+	}
+	assert.Nil(t, dataFile.Close())
+
+	// 没有 key 或者 key 不对时无法打开
+	_, err = OpenDataFile(dir, 0, nil, nil)
+	assert.Equal(t, ErrUnknownEncryptionKey, err)
+	other, _ := NewCipher(bytes.Repeat([]byte{2}, 16))
+	_, err = OpenDataFile(dir, 0, other, nil)
+	assert.Equal(t, ErrUnknownEncryptionKey, err)
+
+	// 旧的 key 可以读取之前的文件，新建的文件使用新的 key
+	rotated, err := NewCipher(bytes.Repeat([]byte{2}, 16), key)
+	assert.Nil(t, err)
+	dataFile, err = OpenDataFile(dir, 0, rotated, nil)
+	assert.Nil(t, err)
+	assert.False(t, dataFile.UpToDate(rotated))
+	record, _, err := dataFile.ReadLogRecord(offsets[10])
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("secret-key"), record.Key)
+	assert.Nil(t, dataFile.Close())
+	newFile, err := OpenDataFile(dir, 1, rotated, nil)
+	assert.Nil(t, err)
+	assert.True(t, newFile.UpToDate(rotated))
+	assert.Nil(t, newFile.Close())
+
+	_, err = NewCipher([]byte("short"))
+	assert.Equal(t, ErrInvalidEncryptionKey, err)
+}
+
+func TestDataFile_EncryptionAuthenticated(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption-auth")
+	defer os.RemoveAll(dir)
+	c, err := NewCipher(bytes.Repeat([]byte{1}, 16))
+	assert.Nil(t, err)
+
+	dataFile, err := OpenDataFile(dir, 0, c, nil)
+	assert.Nil(t, err)
+	encRecord, _ := EncodeLogRecord(&LogRecord{Key: []byte("key"), Value: []byte("value")})
+	assert.Nil(t, dataFile.Write(encRecord))
+	assert.Nil(t, dataFile.Write(encRecord))
+	size := int64(len(encRecord)) + recordFrameOverhead
+	assert.Equal(t, 2*size, dataFile.WriteOff)
+
+	// 截断之后在同一个位置重新写入相同的记录，使用新的 nonce，密文不同
+	first, err := dataFile.readNBytes(size, size)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.Truncate(size))
+	assert.Nil(t, dataFile.Write(encRecord))
+	second, err := dataFile.readNBytes(size, size)
+	assert.Nil(t, err)
+	assert.NotEqual(t, first, second)
+	record, _, err := dataFile.ReadLogRecord(size)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), record.Value)
+
+	// 修改密文中的任何一位都无法通过认证
+	tampered := append([]byte(nil), second...)
+	tampered[len(tampered)-20] ^= 1
+	_, err = dataFile.IoManager.WriteAt(tampered, size+fileHeaderSize)
+	assert.Nil(t, err)
+	_, _, err = dataFile.ReadLogRecord(size)
+	assert.Equal(t, ErrInvalidCRC, err)
+
+	// 记录被移动到其他位置之后也无法通过认证
+	_, err = dataFile.IoManager.WriteAt(second, fileHeaderSize)
+	assert.Nil(t, err)
+	_, _, err = dataFile.ReadLogRecord(0)
+	assert.Equal(t, ErrInvalidCRC, err)
+	assert.Nil(t, dataFile.Close())
+}
@@ -0,0 +1,225 @@
+package data
+
+import (
+	"LingDB/LingDB-go/fio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidFileHeader      = errors.New("invalid file header, the file maybe corrupted")
+	ErrUnsupportedFileVersion = errors.New("the file format version is not supported")
+)
+
+const (
+	// FileVersionLegacy 没有文件头部的旧版本明文文件
+	FileVersionLegacy uint8 = iota
+	// FileVersion1 带有格式版本、创建信息以及加密信息的文件头部
+	FileVersion1
+	// FileVersion2 记录之后可能有预先分配的空间，通过日志结束标识或者校验失败确定数据的结束位置
+	FileVersion2
+)
+
+// CurrentFileVersion 新建的文件使用的格式版本
+const CurrentFileVersion = FileVersion2
+
+// 文件头部，明文存储，WriteOff 以及记录的位置都不包括头部
+//
+//	+--------+---------+-------+------------+---------+--------+-------+-------+
+//	| magic  | version | flags | created at | file id | key id |   iv  |  crc  |
+//	+--------+---------+-------+------------+---------+--------+-------+-------+
+//	  6字节     1字节    1字节      8字节        4字节     8字节    16字节   4字节
+//
+// 没有加密的文件中 key id 和 iv 都为 0
+const (
+	fileHeaderMagic        = "LINGDB"
+	fileHeaderSize         = 48
+	fileFlagEncrypted byte = 1
+)
+
+// FileHeader 文件头部中记录的信息
+type FileHeader struct {
+	Version   uint8     // 文件格式的版本
+	CreatedAt time.Time // 文件创建的时间，旧版本的文件为零值
+	FileId    uint32    // 创建时的文件 id
+	Encrypted bool      // 文件是否被加密
+}
+
+// 读取已有文件的头部，新建的空文件写入当前版本的头部
+func (df *DataFile) initHeader(c *Cipher) error {
+	fileSize, err := df.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	n := fileSize
+	if n > fileHeaderSize {
+		n = fileHeaderSize
+	}
+	buf := make([]byte, n)
+	if _, err := df.IoManager.Read(buf, 0); err != nil {
+		return err
+	}
+
+	switch {
+	case fileSize == 0:
+		return df.writeHeader(c)
+	case isTornHeader(buf):
+		// 头部没有写完整时文件中不会有任何数据，重新写入
+		if err := df.IoManager.Truncate(0); err != nil {
+			return err
+		}
+		return df.writeHeader(c)
+	case strings.HasPrefix(string(buf), fileHeaderMagic):
+		return df.decodeHeader(buf, c)
+	default:
+		// 没有头部的旧版本文件，开启加密之前写入的明文文件也是如此
+		df.Header = FileHeader{Version: FileVersionLegacy, FileId: df.FileId}
+		return nil
+	}
+}
+
+// 文件的开头是 magic 的一部分，但是长度不足一个完整的头部
+func isTornHeader(buf []byte) bool {
+	if len(buf) >= fileHeaderSize {
+		return false
+	}
+	n := len(buf)
+	if n > len(fileHeaderMagic) {
+		n = len(fileHeaderMagic)
+	}
+	return string(buf[:n]) == fileHeaderMagic[:n]
+}
+
+// 写入当前版本的头部，c 不为 nil 时使用 c 当前的 key 以及随机的 iv 加密文件
+func (df *DataFile) writeHeader(c *Cipher) error {
+	header := FileHeader{
+		Version:   CurrentFileVersion,
+		CreatedAt: time.Now(),
+		FileId:    df.FileId,
+		Encrypted: c != nil,
+	}
+	buf := make([]byte, fileHeaderSize)
+	copy(buf, fileHeaderMagic)
+	buf[6] = header.Version
+	binary.LittleEndian.PutUint64(buf[8:16], uint64(header.CreatedAt.UnixNano()))
+	binary.LittleEndian.PutUint32(buf[16:20], header.FileId)
+	var fe *fileEncryption
+	if c != nil {
+		var err error
+		if fe, err = c.newFileEncryption(); err != nil {
+			return err
+		}
+		buf[7] |= fileFlagEncrypted
+		binary.LittleEndian.PutUint64(buf[20:28], fe.keyId)
+		copy(buf[28:44], fe.iv[:])
+	}
+	binary.LittleEndian.PutUint32(buf[44:], crc32.ChecksumIEEE(buf[:44]))
+	if _, err := df.IoManager.WriteAt(buf, 0); err != nil {
+		return err
+	}
+
+	df.Header = header
+	df.encryption = fe
+	df.headerSize = fileHeaderSize
+	return nil
+}
+
+// 解析文件头部，按照版本号判断是否能够读取
+func (df *DataFile) decodeHeader(buf []byte, c *Cipher) error {
+	if binary.LittleEndian.Uint32(buf[44:]) != crc32.ChecksumIEEE(buf[:44]) {
+		return ErrInvalidFileHeader
+	}
+	version := buf[6]
+	if version == FileVersionLegacy || version > CurrentFileVersion {
+		return ErrUnsupportedFileVersion
+	}
+	df.Header = FileHeader{
+		Version:   version,
+		CreatedAt: time.Unix(0, int64(binary.LittleEndian.Uint64(buf[8:16]))),
+		FileId:    binary.LittleEndian.Uint32(buf[16:20]),
+		Encrypted: buf[7]&fileFlagEncrypted != 0,
+	}
+	if df.Header.Encrypted {
+		fe, err := c.fileEncryption(binary.LittleEndian.Uint64(buf[20:28]), buf[28:44])
+		if err != nil {
+			return err
+		}
+		df.encryption = fe
+	}
+	df.headerSize = fileHeaderSize
+	return nil
+}
+
+// UpToDate 文件是否为当前的格式版本，并且和 c 当前的 key 一致，c 为 nil 时文件不能被加密
+func (df *DataFile) UpToDate(c *Cipher) bool {
+	if df.Header.Version != CurrentFileVersion {
+		return false
+	}
+	if c == nil {
+		return df.encryption == nil
+	}
+	return df.encryption != nil && df.encryption.keyId == c.current.id
+}
+
+// UpgradeFile 使用当前的格式版本以及 c 当前的 key 重写文件，文件不存在或者已经是最新的时不做任何处理
+// 先写入临时文件，完成之后再替换原来的文件，用于升级 merge 不会重写的元数据文件，fs 为 nil 时使用操作系统的文件系统
+func UpgradeFile(fileName string, c *Cipher, fs fio.FileSystem) error {
+	if fs == nil {
+		fs = fio.OS
+	}
+	if _, err := fs.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+	file, err := newDataFile(fileName, 0, c, fs)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if file.UpToDate(c) {
+		return nil
+	}
+	tempFileName := fileName + CheckpointTempSuffix
+	if err := fs.Remove(tempFileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	tempFile, err := newDataFile(tempFileName, 0, c, fs)
+	if err != nil {
+		return err
+	}
+	defer tempFile.Close()
+	// 逐条重写记录，加密的文件中每条记录需要单独加密
+	// 末尾写了一半的记录被丢弃，文件中间的记录损坏时返回错误，保留原来的文件
+	var offset int64
+	for {
+		record, size, err := file.ReadLogRecord(offset)
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF || err == ErrInvalidCRC {
+			tail, tailErr := file.TornTail(offset)
+			if tailErr != nil {
+				return tailErr
+			}
+			if tail {
+				break
+			}
+		}
+		if err != nil {
+			return err
+		}
+		encRecord, _ := EncodeLogRecord(record)
+		if err := tempFile.Write(encRecord); err != nil {
+			return err
+		}
+		offset += size
+	}
+	if err := tempFile.Sync(); err != nil {
+		return err
+	}
+	return fs.Rename(tempFileName, fileName)
+}
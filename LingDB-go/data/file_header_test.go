@@ -0,0 +1,186 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDataFile_Header(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-file-header")
+	defer os.RemoveAll(dir)
+
+	// 新建的文件写入当前版本的头部
+	dataFile, err := OpenDataFile(dir, 7, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, CurrentFileVersion, dataFile.Header.Version)
+	assert.Equal(t, uint32(7), dataFile.Header.FileId)
+	assert.False(t, dataFile.Header.Encrypted)
+	assert.True(t, time.Since(dataFile.Header.CreatedAt) < time.Minute)
+	assert.True(t, dataFile.UpToDate(nil))
+	encRecord, _ := EncodeLogRecord(&LogRecord{Key: []byte("key"), Value: []byte("value")})
+	assert.Nil(t, dataFile.Write(encRecord))
+	assert.Nil(t, dataFile.Close())
+
+	dataFile, err = OpenDataFile(dir, 7, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(7), dataFile.Header.FileId)
+	size, err := dataFile.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(encRecord)), size)
+	record, _, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), record.Value)
+	assert.Nil(t, dataFile.Close())
+
+	// 头部损坏或者版本不支持时无法打开
+	fileName := GetDataFileName(dir, 7)
+	content, _ := os.ReadFile(fileName)
+	content[10] ^= 0xff
+	assert.Nil(t, os.WriteFile(fileName, content, 0644))
+	_, err = OpenDataFile(dir, 7, nil, nil)
+	assert.Equal(t, ErrInvalidFileHeader, err)
+	content[10] ^= 0xff
+	content[6] = CurrentFileVersion + 1
+	binary.LittleEndian.PutUint32(content[44:], crc32.ChecksumIEEE(content[:44]))
+	assert.Nil(t, os.WriteFile(fileName, content, 0644))
+	_, err = OpenDataFile(dir, 7, nil, nil)
+	assert.Equal(t, ErrUnsupportedFileVersion, err)
+
+	// 没有写完整的头部会被重新写入
+	assert.Nil(t, os.WriteFile(fileName, content[:20], 0644))
+	dataFile, err = OpenDataFile(dir, 7, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, CurrentFileVersion, dataFile.Header.Version)
+	size, err = dataFile.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), size)
+	assert.Nil(t, dataFile.Close())
+}
+
+func TestDataFile_LegacyHeader(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-legacy-header")
+	defer os.RemoveAll(dir)
+	encRecord, _ := EncodeLogRecord(&LogRecord{Key: []byte("key"), Value: []byte("value")})
+
+	// 没有头部的旧版本文件
+	assert.Nil(t, os.WriteFile(GetDataFileName(dir, 0), encRecord, 0644))
+	dataFile, err := OpenDataFile(dir, 0, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, FileVersionLegacy, dataFile.Header.Version)
+	assert.False(t, dataFile.UpToDate(nil))
+	record, size, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), record.Value)
+	assert.Equal(t, int64(len(encRecord)), size)
+	assert.Nil(t, dataFile.Close())
+}
+
+func TestUpgradeFile(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-reencrypt")
+	defer os.RemoveAll(dir)
+
+	// 没有头部的旧版本明文文件升级之后记录不变，加密之后每条记录单独加密
+	encRecord, _ := EncodeLogRecord(&LogRecord{Key: []byte("bucket"), Value: []byte{1}})
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, BucketMetaFileName), encRecord, 0644))
+
+	c, _ := NewCipher(bytes.Repeat([]byte{3}, 24))
+	fileName := filepath.Join(dir, BucketMetaFileName)
+	assert.Nil(t, UpgradeFile(fileName, c, nil))
+	metaFile, err := OpenBucketMetaFile(dir, c, nil)
+	assert.Nil(t, err)
+	assert.True(t, metaFile.UpToDate(c))
+	record, size, err := metaFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bucket"), record.Key)
+	assert.Equal(t, int64(len(encRecord))+recordFrameOverhead, size)
+	assert.Nil(t, metaFile.Close())
+
+	// 不存在的文件不做处理
+	assert.Nil(t, UpgradeFile(filepath.Join(dir, "not-exist"), c, nil))
+	_, err = os.Stat(filepath.Join(dir, "not-exist"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestUpgradeFile_Corrupted(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-upgrade-corrupted")
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, BucketMetaFileName)
+	var content []byte
+	var offsets []int
+	for _, key := range []string{"a", "b", "c"} {
+		encRecord, _ := EncodeLogRecord(&LogRecord{Key: []byte(key), Value: []byte("value")})
+		offsets = append(offsets, len(content))
+		content = append(content, encRecord...)
+	}
+	c, _ := NewCipher(bytes.Repeat([]byte{3}, 16))
+
+	// 中间的记录损坏时返回错误，原来的文件保持不变
+	corrupted := append([]byte(nil), content...)
+	corrupted[offsets[2]-1] ^= 0xff
+	assert.Nil(t, os.WriteFile(fileName, corrupted, 0644))
+	assert.Equal(t, ErrInvalidCRC, UpgradeFile(fileName, c, nil))
+	onDisk, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, corrupted, onDisk)
+
+	// 末尾的记录损坏或者没有写完整时被丢弃
+	for _, tail := range [][]byte{
+		append(append([]byte(nil), content[:len(content)-1]...), content[len(content)-1]^0xff),
+		content[:len(content)-3],
+	} {
+		assert.Nil(t, os.WriteFile(fileName, tail, 0644))
+		assert.Nil(t, UpgradeFile(fileName, c, nil))
+		metaFile, err := OpenBucketMetaFile(dir, c, nil)
+		assert.Nil(t, err)
+		var keys []string
+		var offset int64
+		for {
+			record, size, err := metaFile.ReadLogRecord(offset)
+			if err == io.EOF {
+				break
+			}
+			assert.Nil(t, err)
+			keys = append(keys, string(record.Key))
+			offset += size
+		}
+		assert.Equal(t, []string{"a", "b"}, keys)
+		assert.Nil(t, metaFile.Close())
+	}
+}
+
+func TestDataFile_TornTail(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-torn-tail")
+	defer os.RemoveAll(dir)
+	c, _ := NewCipher(bytes.Repeat([]byte{4}, 16))
+	for i, c := range []*Cipher{nil, c} {
+		dataFile, err := OpenDataFile(dir, uint32(i), c, nil)
+		assert.Nil(t, err)
+		var offsets []int64
+		for _, key := range []string{"a", "b", "c"} {
+			encRecord, _ := EncodeLogRecord(&LogRecord{Key: []byte(key), Value: []byte("value")})
+			offsets = append(offsets, dataFile.WriteOff)
+			assert.Nil(t, dataFile.Write(encRecord))
+		}
+		// 最后一条记录之后是日志结束标识以及预先分配的空间
+		assert.Nil(t, dataFile.Preallocate(dataFile.WriteOff+1024))
+		assert.Nil(t, dataFile.MarkEndOfLog())
+
+		tail, err := dataFile.TornTail(offsets[1])
+		assert.Nil(t, err)
+		assert.False(t, tail)
+		tail, err = dataFile.TornTail(offsets[2])
+		assert.Nil(t, err)
+		assert.True(t, tail)
+		tail, err = dataFile.TornTail(dataFile.WriteOff)
+		assert.Nil(t, err)
+		assert.True(t, tail)
+		assert.Nil(t, dataFile.Close())
+	}
+}
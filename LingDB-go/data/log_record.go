@@ -3,6 +3,7 @@ package data
 import (
 	"encoding/binary"
 	"hash/crc32"
+	"io"
 )
 
 // LogRecordType 定义记录的枚举类型，因为记录有过期和非过期之说
@@ -12,24 +13,42 @@ const (
 	LogRecordNormal LogRecordType = iota
 	LogRecordDeleted
 	LogRecordTxnFinished
+	LogRecordMergeOperand
+	// LogRecordEndOfLog 日志结束标识，之后的空间是预先分配的或者没有写入过数据
+	LogRecordEndOfLog
 )
 
-// crc type keySize valueSize
-// 4 + 1 + 5 + 5
-const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 5
+// 类型的最高位标识记录属于非默认的 bucket，此时类型之后紧跟变长编码的 bucket id
+// 默认 bucket 中的记录不设置该标识，编码结果和之前保持一致
+const logRecordBucketFlag byte = 0x80
+
+// 类型的次高位标识记录写入即提交，不需要等待事务完成标识
+// 非事务的写入同样带有序列号，需要该标识和 WriteBatch 中的记录区分
+const logRecordAutoCommitFlag byte = 0x40
+
+// crc type bucketId keySize valueSize
+// 4 + 1 + 5 + 5 + 5
+const maxLogRecordHeaderSize = binary.MaxVarintLen32*3 + 5
+
+// MaxRecordOverhead 一条记录在文件中除了 key 和 value 之外最多额外占用的长度，包括头部以及加密的开销
+const MaxRecordOverhead = maxLogRecordHeaderSize + recordFrameOverhead
 
 // LogRecord 写入到数据文件的记录
 // 之所以叫日志记录，是因为bitcask写入的数据都是以追加的形式去写入的，类似于日志的实现
 type LogRecord struct {
-	Key   []byte
-	Value []byte
-	Type  LogRecordType //定义这条记录的类型（过期，非过期等）
+	Key        []byte
+	Value      []byte
+	Type       LogRecordType //定义这条记录的类型（过期，非过期等）
+	BucketId   uint32        //记录所属的 bucket，0 表示默认 bucket
+	AutoCommit bool          //记录写入即提交，不属于 WriteBatch
 }
 
 // LogRecord的头部信息
 type logRecordHeader struct {
 	crc        uint32        // crc校验值
 	recordType LogRecordType // 标识 LogRecord 的类型
+	autoCommit bool          // 记录是否写入即提交
+	bucketId   uint32        // 记录所属的 bucket
 	keySize    uint32        // key 的长度
 	valueSize  uint32        // value 的长度
 }
@@ -39,6 +58,10 @@ type logRecordHeader struct {
 type LogRecordPos struct {
 	Fid    uint32 // 文件id，表示将数据存储到了那个文件中
 	Offset int64  // 偏移，表示将数据存储到了数据文件中的那个位置
+
+	// 以下字段只保存在内存中，不会被编码
+	Operand bool          // 该位置的记录是否为合并操作数
+	Prev    *LogRecordPos // 合并操作数之前的一条记录，读取时沿着链表合并出完整的值
 }
 
 // TransactionRecord 暂存的事务相关的数据
@@ -53,16 +76,41 @@ type TransactionRecord struct {
 //	| crc 校验值  |  type 类型   |    key size |   value size |      key    |      value   |
 //	+-------------+-------------+-------------+--------------+-------------+--------------+
 //	    4字节          1字节        变长（最大5）   变长（最大5）     变长           变长
+//
+// 非默认 bucket 中的记录在 type 之后还有变长（最大5）的 bucket id
 func EncodeLogRecord(record *LogRecord) ([]byte, int64) {
-	//初始化一个header的数组，按照最大头部长度来初始化
-	header := make([]byte, maxLogRecordHeaderSize)
+	return AppendLogRecord(nil, record)
+}
+
+// AppendLogRecord 将 LogRecord 编码后追加到 dst 中，返回追加后的字节数组以及编码的长度
+// dst 的容量足够时不会分配新的内存，可以配合缓冲池复用编码使用的字节数组
+func AppendLogRecord(dst []byte, record *LogRecord) ([]byte, int64) {
+	start := len(dst)
+	maxSize := maxLogRecordHeaderSize + len(record.Key) + len(record.Value)
+	if cap(dst)-start < maxSize {
+		grown := make([]byte, start, start+maxSize)
+		copy(grown, dst)
+		dst = grown
+	}
+	//头部直接写入到 dst 中，按照最大头部长度预留空间
+	header := dst[start : start+maxLogRecordHeaderSize]
 
 	//header的第5字节表示记录的类型，这里4是从0开始
 	header[4] = record.Type
 
+	if record.AutoCommit {
+		header[4] |= logRecordAutoCommitFlag
+	}
+
 	//后面的keySize和ValueSize使用变长字符串从索引5开始操作
 	var index = 5
 
+	//非默认 bucket 的记录需要写入 bucket id
+	if record.BucketId != 0 {
+		header[4] |= logRecordBucketFlag
+		index += binary.PutUvarint(header[index:], uint64(record.BucketId))
+	}
+
 	//这里使用binary的PutVarint方法操作，方法返回操作的长度
 	//这个方法在写入数字时采用变长编码的方式，比如一个字节数组占用8位，那么那么会被切分为前7位和后一位，如果没有结束，那么最高位为1，如果结束了那么最高位为0
 	//比如：1000111010
@@ -71,16 +119,11 @@ func EncodeLogRecord(record *LogRecord) ([]byte, int64) {
 	index += binary.PutVarint(header[index:], int64(len(record.Key)))
 	index += binary.PutVarint(header[index:], int64(len(record.Value)))
 
-	//这里最终的长度大小已经确定，头部index + len(key) + len(value)
-	//计算最终的写入数组长度
-	var size = index + len(record.Key) + len(record.Value)
-	//这时已经放入了头部除了crc的所有信息，那么将header从0到index内容拷贝到最终写入数组
-	encBytes := make([]byte, size)
-
-	//将header内容拷贝过来
-	copy(encBytes[:index], header[:index])
-	copy(encBytes[index:], record.Key)
-	copy(encBytes[index+len(record.Key):], record.Value)
+	//头部之后紧跟 key 和 value，最终的长度为头部index + len(key) + len(value)
+	dst = dst[:start+index]
+	dst = append(dst, record.Key...)
+	dst = append(dst, record.Value...)
+	encBytes := dst[start:]
 
 	//计算校验位
 	crc := crc32.ChecksumIEEE(encBytes[4:])
@@ -88,7 +131,7 @@ func EncodeLogRecord(record *LogRecord) ([]byte, int64) {
 	//一个十六进制数 0x12345678 在大端存储下的内存顺序为 12 34 56 78 ==> 这里计算机读取都是从文件低位开始读的(从左向右)，那么处理数据时会先读取到高位，不方便操作
 	//一个十六进制数 0x12345678 在小端存储中的内存顺序为 78 56 34 12 ==> 计算机读取时候会先读到低位
 	binary.LittleEndian.PutUint32(encBytes[:4], crc)
-	return encBytes, int64(size)
+	return dst, int64(len(encBytes))
 }
 
 // EncodeLogRecordPos 对位置信息进行编码
@@ -117,10 +160,16 @@ func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
 
 	header := &logRecordHeader{
 		crc:        binary.LittleEndian.Uint32(buf[:4]),
-		recordType: buf[4],
+		recordType: buf[4] &^ (logRecordBucketFlag | logRecordAutoCommitFlag),
+		autoCommit: buf[4]&logRecordAutoCommitFlag != 0,
 	}
 
 	var index = 5
+	if buf[4]&logRecordBucketFlag != 0 {
+		bucketId, n := binary.Uvarint(buf[index:])
+		header.bucketId = uint32(bucketId)
+		index += n
+	}
 	//获取实际的key和value
 	keySize, n := binary.Varint(buf[index:])
 	header.keySize = uint32(keySize)
@@ -133,6 +182,31 @@ func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
 	return header, int64(index)
 }
 
+// DecodeLogRecord 解码一条编码后的完整记录并校验 crc，返回记录以及其编码长度
+func DecodeLogRecord(buf []byte) (*LogRecord, int64, error) {
+	header, headerSize := decodeLogRecordHeader(buf)
+	if header == nil {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
+	recordSize := headerSize + keySize + valueSize
+	if headerSize <= 5 || int64(len(buf)) < recordSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	logRecord := &LogRecord{
+		Key:        buf[headerSize : headerSize+keySize],
+		Value:      buf[headerSize+keySize : recordSize],
+		Type:       header.recordType,
+		BucketId:   header.bucketId,
+		AutoCommit: header.autoCommit,
+	}
+	if getLogRecordCRC(logRecord, buf[crc32.Size:headerSize]) != header.crc {
+		return nil, 0, ErrInvalidCRC
+	}
+	return logRecord, recordSize, nil
+}
+
 // 获取crc，类似于计算机网络里的摘要算法
 // 也就是说对数据部分进行摘要计算，生成一个摘要值，如果内容被篡改，那么头部的crc和根据内容计算出的crc会不一样
 func getLogRecordCRC(lr *LogRecord, header []byte) uint32 {
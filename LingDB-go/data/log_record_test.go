@@ -1,8 +1,10 @@
 package data
 
 import (
+	"bytes"
 	"github.com/stretchr/testify/assert"
 	"hash/crc32"
+	"io"
 	"testing"
 )
 
@@ -93,3 +95,100 @@ func TestGetLogRecordCRC(t *testing.T) {
 	crc3 := getLogRecordCRC(rec3, headerBuf3[crc32.Size:])
 	assert.Equal(t, uint32(290887979), crc3)
 }
+
+func TestEncodeLogRecord_Bucket(t *testing.T) {
+	rec := &LogRecord{
+		Key:      []byte("name"),
+		Value:    []byte("bitcask-go"),
+		Type:     LogRecordDeleted,
+		BucketId: 300,
+	}
+	res, n := EncodeLogRecord(rec)
+	assert.Equal(t, int64(len(res)), n)
+
+	// 默认 bucket 的编码结果不包含 bucket id
+	res0, _ := EncodeLogRecord(&LogRecord{Key: rec.Key, Value: rec.Value, Type: rec.Type})
+	assert.Equal(t, len(res0)+2, len(res))
+
+	h, size := decodeLogRecordHeader(res)
+	assert.NotNil(t, h)
+	assert.Equal(t, int64(9), size)
+	assert.Equal(t, LogRecordDeleted, h.recordType)
+	assert.Equal(t, uint32(300), h.bucketId)
+	assert.Equal(t, uint32(4), h.keySize)
+	assert.Equal(t, uint32(10), h.valueSize)
+
+	crc := getLogRecordCRC(rec, res[crc32.Size:size])
+	assert.Equal(t, h.crc, crc)
+}
+
+func TestEncodeLogRecord_AutoCommit(t *testing.T) {
+	rec := &LogRecord{
+		Key:        []byte("name"),
+		Value:      []byte("bitcask-go"),
+		Type:       LogRecordMergeOperand,
+		BucketId:   300,
+		AutoCommit: true,
+	}
+	res, _ := EncodeLogRecord(rec)
+
+	h, size := decodeLogRecordHeader(res)
+	assert.NotNil(t, h)
+	assert.Equal(t, LogRecordMergeOperand, h.recordType)
+	assert.True(t, h.autoCommit)
+	assert.Equal(t, uint32(300), h.bucketId)
+
+	crc := getLogRecordCRC(rec, res[crc32.Size:size])
+	assert.Equal(t, h.crc, crc)
+
+	// 未设置标识的记录不是自动提交的
+	res, _ = EncodeLogRecord(&LogRecord{Key: rec.Key, Value: rec.Value, Type: rec.Type})
+	h, _ = decodeLogRecordHeader(res)
+	assert.False(t, h.autoCommit)
+	assert.Equal(t, LogRecordMergeOperand, h.recordType)
+}
+
+func TestAppendLogRecord(t *testing.T) {
+	rec1 := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-go"), BucketId: 7}
+	rec2 := &LogRecord{Key: []byte("key"), Value: bytes.Repeat([]byte("v"), 100), Type: LogRecordDeleted}
+	enc1, _ := EncodeLogRecord(rec1)
+	enc2, _ := EncodeLogRecord(rec2)
+
+	// 追加到已有的数据之后，容量不足时重新分配
+	dst := make([]byte, 0, 8)
+	dst, size1 := AppendLogRecord(dst, rec1)
+	dst, size2 := AppendLogRecord(dst, rec2)
+	assert.Equal(t, int64(len(enc1)), size1)
+	assert.Equal(t, int64(len(enc2)), size2)
+	assert.Equal(t, append(append([]byte{}, enc1...), enc2...), dst)
+
+	// 容量足够时复用 dst，残留的数据不影响编码结果
+	reused, _ := AppendLogRecord(dst[:0], rec2)
+	assert.Equal(t, enc2, reused)
+	assert.Equal(t, &dst[0], &reused[0])
+}
+
+func TestDecodeLogRecord(t *testing.T) {
+	rec := &LogRecord{
+		Key:        []byte("name"),
+		Value:      []byte("bitcask-go"),
+		Type:       LogRecordNormal,
+		BucketId:   3,
+		AutoCommit: true,
+	}
+	res, n := EncodeLogRecord(rec)
+
+	decoded, size, err := DecodeLogRecord(res)
+	assert.Nil(t, err)
+	assert.Equal(t, n, size)
+	assert.Equal(t, rec, decoded)
+
+	// 数据不完整
+	_, _, err = DecodeLogRecord(res[:len(res)-1])
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// 数据被篡改
+	res[len(res)-1] ^= 0xff
+	_, _, err = DecodeLogRecord(res)
+	assert.Equal(t, ErrInvalidCRC, err)
+}
@@ -0,0 +1,93 @@
+package data
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestDataFile_EndOfLog(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-end-of-log")
+	defer os.RemoveAll(dir)
+
+	encrypted, _ := NewCipher(bytes.Repeat([]byte{4}, 16))
+	for _, c := range []*Cipher{nil, encrypted} {
+		dataFile, err := OpenDataFile(dir, 1, c, nil)
+		assert.Nil(t, err)
+		assert.Nil(t, dataFile.Preallocate(4096))
+		size, err := dataFile.Size()
+		assert.Nil(t, err)
+		assert.True(t, size >= 4096)
+
+		encRecord, _ := EncodeLogRecord(&LogRecord{Key: []byte("key"), Value: []byte("value")})
+		assert.Nil(t, dataFile.Write(encRecord))
+		writeOff := dataFile.WriteOff
+
+		// 预先分配的空间全为 0，不是有效的记录，加密的文件中解密之后是随机的数据
+		_, _, err = dataFile.ReadLogRecord(writeOff)
+		assert.Contains(t, []error{ErrInvalidCRC, io.ErrUnexpectedEOF}, err)
+		unwritten, err := dataFile.Unwritten(writeOff)
+		assert.Nil(t, err)
+		assert.True(t, unwritten)
+		unwritten, err = dataFile.Unwritten(0)
+		assert.Nil(t, err)
+		assert.False(t, unwritten)
+
+		// 写入结束标识之后读取到 EOF，写入位置不变
+		assert.Nil(t, dataFile.MarkEndOfLog())
+		assert.Equal(t, writeOff, dataFile.WriteOff)
+		_, _, err = dataFile.ReadLogRecord(writeOff)
+		assert.Equal(t, io.EOF, err)
+		unwritten, err = dataFile.Unwritten(writeOff)
+		assert.Nil(t, err)
+		assert.True(t, unwritten)
+		marker, err := dataFile.readNBytes(recordFrameHeaderSize, writeOff)
+		assert.Nil(t, err)
+
+		// 继续写入会覆盖结束标识
+		assert.Nil(t, dataFile.Write(encRecord))
+		record, _, err := dataFile.ReadLogRecord(writeOff)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("value"), record.Value)
+		if c != nil {
+			// 加密的文件中覆盖结束标识的记录使用新的 nonce，不会复用结束标识的密钥流
+			overwritten, err := dataFile.readNBytes(recordFrameHeaderSize, writeOff)
+			assert.Nil(t, err)
+			assert.NotEqual(t, marker[4:], overwritten[4:])
+		}
+
+		// 封存之后文件的长度就是数据的长度
+		assert.Nil(t, dataFile.Seal())
+		size, err = dataFile.Size()
+		assert.Nil(t, err)
+		assert.Equal(t, dataFile.WriteOff, size)
+		_, _, err = dataFile.ReadLogRecord(size)
+		assert.Equal(t, io.EOF, err)
+		assert.Nil(t, dataFile.Close())
+
+		// 重新打开时写入位置为文件末尾
+		dataFile, err = OpenDataFile(dir, 1, c, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, size, dataFile.WriteOff)
+		assert.Nil(t, dataFile.Close())
+		assert.Nil(t, os.Remove(GetDataFileName(dir, 1)))
+	}
+}
+
+func TestDataFile_RecordPastEnd(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-record-past-end")
+	defer os.RemoveAll(dir)
+
+	dataFile, err := OpenDataFile(dir, 1, nil, nil)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+	encRecord, _ := EncodeLogRecord(&LogRecord{Key: []byte("key"), Value: []byte("value")})
+	assert.Nil(t, dataFile.Write(encRecord))
+	assert.Nil(t, dataFile.Truncate(int64(len(encRecord)-2)))
+
+	// 记录的长度超出文件末尾
+	_, _, err = dataFile.ReadLogRecord(0)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
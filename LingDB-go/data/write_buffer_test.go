@@ -0,0 +1,74 @@
+package data
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestDataFile_WriteBuffer(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-write-buffer")
+	defer os.RemoveAll(dir)
+
+	encrypted, _ := NewCipher(bytes.Repeat([]byte{5}, 16))
+	for _, c := range []*Cipher{nil, encrypted} {
+		dataFile, err := OpenDataFile(dir, 1, c, nil)
+		assert.Nil(t, err)
+		dataFile.EnableWriteBuffer(256)
+
+		// 缓冲区中的记录可以直接读取，但是还没有写入文件
+		var offsets []int64
+		for i := 0; i < 10; i++ {
+			encRecord, _ := EncodeLogRecord(&LogRecord{Key: []byte{byte(i)}, Value: bytes.Repeat([]byte{byte(i)}, 30)})
+			offsets = append(offsets, dataFile.WriteOff)
+			assert.Nil(t, dataFile.Write(encRecord))
+		}
+		physical, err := dataFile.IoManager.Size()
+		assert.Nil(t, err)
+		size, err := dataFile.Size()
+		assert.Nil(t, err)
+		assert.Equal(t, dataFile.WriteOff, size)
+		assert.True(t, physical-fileHeaderSize < size)
+		for i, offset := range offsets {
+			record, _, err := dataFile.ReadLogRecord(offset)
+			assert.Nil(t, err)
+			assert.Equal(t, bytes.Repeat([]byte{byte(i)}, 30), record.Value)
+		}
+		_, _, err = dataFile.ReadLogRecord(dataFile.WriteOff)
+		assert.Equal(t, io.EOF, err)
+
+		// 超过缓冲区容量的数据直接写入文件
+		large, _ := EncodeLogRecord(&LogRecord{Key: []byte("large"), Value: bytes.Repeat([]byte("v"), 1000)})
+		largeOff := dataFile.WriteOff
+		assert.Nil(t, dataFile.Write(large))
+		physical, err = dataFile.IoManager.Size()
+		assert.Nil(t, err)
+		assert.Equal(t, dataFile.WriteOff, physical-fileHeaderSize)
+
+		// Sync 之后缓冲区中的数据都写入了文件
+		small, _ := EncodeLogRecord(&LogRecord{Key: []byte("small"), Value: []byte("value")})
+		assert.Nil(t, dataFile.Write(small))
+		assert.Nil(t, dataFile.Sync())
+		physical, err = dataFile.IoManager.Size()
+		assert.Nil(t, err)
+		assert.Equal(t, dataFile.WriteOff, physical-fileHeaderSize)
+		assert.Nil(t, dataFile.Write(small))
+		writeOff := dataFile.WriteOff
+		assert.Nil(t, dataFile.Close())
+
+		// 关闭时写入缓冲区中剩余的数据
+		dataFile, err = OpenDataFile(dir, 1, c, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, writeOff, dataFile.WriteOff)
+		record, _, err := dataFile.ReadLogRecord(largeOff)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("large"), record.Key)
+		record, _, err = dataFile.ReadLogRecord(offsets[9])
+		assert.Nil(t, err)
+		assert.Equal(t, bytes.Repeat([]byte{9}, 30), record.Value)
+		assert.Nil(t, dataFile.Close())
+		assert.Nil(t, os.Remove(GetDataFileName(dir, 1)))
+	}
+}
@@ -1,40 +1,106 @@
 package LingDB_go
 
 import (
+	"LingDB/LingDB-go/cache"
 	"LingDB/LingDB-go/data"
+	"LingDB/LingDB-go/fio"
 	"LingDB/LingDB-go/index"
+	"context"
 	"errors"
-	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const (
+	foldPrefetchSize    = 256 // Fold 每次预读的数据量
+	foldPrefetchWorkers = 4   // Fold 预读时的并发数
+	multiGetWorkers     = 4   // MultiGet 并发读取的协程数量
+
+	maxPooledEncodeBufferSize = 1 << 20 // 超过该容量的编码缓冲区不放回缓冲池，避免长期占用内存
+
+	inMemoryDirPath = "lingdb" // InMemory 模式下 DirPath 为空时使用的数据目录
+)
+
+// 写入记录时复用的编码缓冲区
+var encodeBufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 4096)
+		return &buf
+	},
+}
+
 // DB bitcask存储引擎实例，用户用来操作数据库的对象
 type DB struct {
-	options    Options                   //用户配置项
-	mu         *sync.RWMutex             //操作db需要加锁
-	fileIds    []int                     //文件的id，只能用在加载索引时使用，不能修改这个属性的值和内部指针
-	activeFile *data.DataFile            //当前活跃数据文件，可以用于写入
-	olderFiles map[uint32]*data.DataFile //旧的数据文件，只能用于读
-	index      index.Indexer             //内存索引
-	seqNo      uint64                    // 事务序列号，全局递增
-	isMerging  bool                      // 是否正在 merge
+	options      Options                   //用户配置项
+	mu           *sync.RWMutex             //操作db需要加锁
+	fileIds      []int                     //文件的id，只能用在加载索引时使用，不能修改这个属性的值和内部指针
+	activeFile   *data.DataFile            //当前活跃数据文件，可以用于写入
+	olderFiles   map[uint32]*data.DataFile //旧的数据文件，只能用于读
+	index        index.Indexer             //内存索引，即默认 bucket 的索引
+	seqNo        uint64                    // 序列号，每次提交递增，同一个 WriteBatch 中的记录相同
+	changesFloor uint64                    // 序列号不大于该值的变更历史已经被 merge 清理
+	isMerging    bool                      // 是否正在 merge
+	readCache    *cache.LRU                // 读缓存，未开启时为 nil
+	metrics      *metrics                  // 运行时统计信息
+	hintWg       *sync.WaitGroup           // 后台生成 hint 文件的任务
+	logger       Logger                    // 日志输出，用户未配置时不输出
+	events       *EventListener            // 存储引擎事件的回调
+	cipher       *data.Cipher              // 文件的加密方式，没有开启加密时为 nil
+	loaded       bool                      // 索引是否已经加载完成，之前活跃文件的写入位置还没有确定
+	fs           fio.FileSystem            // 数据目录所在的文件系统
+
+	bloomFilters    map[uint32]*data.BloomFilter // 旧数据文件的布隆过滤器
+	activeKeyHashes map[uint64]struct{}          // 写入活跃文件的 key 的哈希值，封存时构造布隆过滤器
+	bloomRebuilds   map[uint32][]uint64          // 启动时需要重新构造布隆过滤器的文件及其 key 的哈希值
+
+	checkpoint   *checkpointMeta // 启动时加载的索引快照，没有加载快照时为 nil
+	checkpointMu *sync.Mutex     // 保证同一时间只有一个快照在生成
+	checkpointWg *sync.WaitGroup // 定期生成快照的后台任务
+	closeCh      chan struct{}   // 数据库关闭时通知后台任务退出
+	replayMu     *sync.RWMutex   // 重放变更时持有读锁，关闭或删除数据文件之前需要等待重放结束
+
+	defaultBucket *Bucket            // 默认 bucket，DB 本身的读写方法都作用于默认 bucket
+	buckets       map[uint32]*Bucket // 所有的 bucket，包括默认 bucket
+	bucketIds     map[string]uint32  // bucket 名称到 id 的映射，不包括默认 bucket
+
+	watchMu  *sync.RWMutex         // 保护 watchers
+	watchers map[*Watcher]struct{} // 订阅数据变更的 Watcher
+
+	mergeGen     mergeGeneration                      // 最近一次安装的 merge，副本中为主库的 merge
+	pendingTxns  map[uint64][]*data.TransactionRecord // 还没有读到完成标识的事务记录，副本之后可能收到其完成标识
+	appendSignal *appendSignal                        // 数据文件有新的写入时通知向副本发送日志的任务
+	replica      int32                                // 是否为只读副本，副本只能通过同步主库的日志写入数据
+	replicating  int32                                // 是否正在从主库同步日志
+	replicaEnd   *replicaPosition                     // 副本活跃文件末尾在主库日志中的位置，为 nil 时需要扫描活跃文件计算
 }
 
 // Open 打开db存储引擎实例
 func Open(options Options) (*DB, error) {
+	return OpenContext(context.Background(), options)
+}
+
+// OpenContext 打开db存储引擎实例，ctx 被取消时停止加载索引，关闭已经打开的文件并返回 ctx.Err()
+func OpenContext(ctx context.Context, options Options) (*DB, error) {
+	start := time.Now()
+
 	//对用户传入配置项进行校验
+	fileSystem := openFileSystem(&options)
 	if err := checkOptions(options); err != nil {
 		return nil, err
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	//校验目录是否存在，如果不存在则创建
-	if _, err := os.Stat(options.DirPath); os.IsNotExist(err) {
-		if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
+	if _, err := fileSystem.Stat(options.DirPath); os.IsNotExist(err) {
+		if err := fileSystem.MkdirAll(options.DirPath); err != nil {
 			return nil, err
 		}
 	}
@@ -42,42 +108,149 @@ func Open(options Options) (*DB, error) {
 	//初始化DB实例结构体
 	db := &DB{
 		options:    options,
+		fs:         fileSystem,
 		mu:         new(sync.RWMutex),
 		olderFiles: make(map[uint32]*data.DataFile),
+		hintWg:     new(sync.WaitGroup),
+		metrics:    newMetrics(),
 		index:      index.NewIndexer(index.IndexType(options.IndexType)),
+
+		bloomFilters:    make(map[uint32]*data.BloomFilter),
+		activeKeyHashes: make(map[uint64]struct{}),
+		bloomRebuilds:   make(map[uint32][]uint64),
+
+		checkpointMu: new(sync.Mutex),
+		checkpointWg: new(sync.WaitGroup),
+		closeCh:      make(chan struct{}),
+		replayMu:     new(sync.RWMutex),
+	}
+	db.defaultBucket = &Bucket{db: db, id: defaultBucketId, index: db.index}
+	db.buckets = map[uint32]*Bucket{defaultBucketId: db.defaultBucket}
+	db.bucketIds = make(map[string]uint32)
+	db.watchMu = new(sync.RWMutex)
+	db.watchers = make(map[*Watcher]struct{})
+	db.pendingTxns = make(map[uint64][]*data.TransactionRecord)
+	db.appendSignal = new(appendSignal)
+	db.events = &db.options.EventListener
+	db.logger = options.Logger
+	if db.logger == nil {
+		db.logger = nopLogger{}
 	}
+	if options.ReadCacheSize > 0 {
+		db.readCache = cache.NewLRU(options.ReadCacheSize)
+	}
+	db.cipher = newCipher(options)
 
 	// 加载 merge 数据目录
 	if err := db.loadMergeFiles(); err != nil {
 		return nil, err
 	}
 
+	// merge 不会重写元数据文件，旧版本以及轮换加密 key 之前的元数据文件在打开时直接升级
+	if err := db.upgradeMetaFiles(); err != nil {
+		return nil, err
+	}
+
 	//加载数据文件内容
 	if err := db.loadDataFiles(); err != nil {
 		return nil, err
 	}
 
-	// 从 hint 索引文件中加载索引
-	if err := db.loadIndexFromHintFile(); err != nil {
+	//加载索引，失败时关闭已经打开的数据文件
+	if err := db.loadIndex(ctx); err != nil {
+		_ = db.Close()
 		return nil, err
 	}
 
-	//从数据文件中加载索引
-	if err := db.loadIndexFromDataFiles(); err != nil {
-		return nil, err
+	db.loaded = true
+
+	// 加载时已经截断了活跃文件写入位置之后的空间，需要重新预先分配
+	if db.activeFile != nil {
+		if err := db.preallocate(db.activeFile); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+
+	// 定期生成索引快照
+	if options.CheckpointInterval > 0 {
+		db.runPeriodicCheckpoint(options.CheckpointInterval)
 	}
 
+	db.metrics.openDuration.observeSince(start)
 	return db, nil
 }
 
+// 加载布隆过滤器以及内存索引
+func (db *DB) loadIndex(ctx context.Context) error {
+	// 加载所有的 bucket
+	if err := db.loadBuckets(); err != nil {
+		return err
+	}
+
+	// 副本的数据文件中可能包含主库 merge 产生的文件
+	if err := db.loadReplicaMeta(); err != nil {
+		return err
+	}
+
+	// 加载旧数据文件的布隆过滤器
+	if err := db.loadBloomFilters(); err != nil {
+		return err
+	}
+
+	// 优先从索引快照中加载索引，快照中已经包含了 hint 索引文件中的所有内容
+	loaded, err := db.loadIndexFromCheckpoint(ctx)
+	if err != nil {
+		return err
+	}
+
+	// 从 hint 索引文件中加载索引
+	if !loaded {
+		if err := db.loadIndexFromHintFile(ctx); err != nil {
+			return err
+		}
+	}
+
+	//从数据文件中加载索引
+	if err := db.loadIndexFromDataFiles(ctx); err != nil {
+		return err
+	}
+
+	// 为缺失布隆过滤器的数据文件重新构造布隆过滤器
+	return db.rebuildBloomFilters()
+}
+
 // Close 关闭活跃文件和旧数据文件
 func (db *DB) Close() error {
+	//通知后台任务退出，并等待后台生成 hint 文件和快照的任务完成，这些任务会读取旧的数据文件
+	db.watchMu.Lock()
+	if !db.isClosed() {
+		close(db.closeCh)
+	}
+	db.watchMu.Unlock()
+	db.closeWatchers()
+	db.checkpointWg.Wait()
+	db.hintWg.Wait()
+	//等待正在进行的变更重放退出，之后的重放会返回 ErrDatabaseClosed
+	db.replayMu.Lock()
+	defer db.replayMu.Unlock()
+
 	if db.activeFile == nil {
 		return nil
 	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	//活跃文件之后还有预先分配的空间时写入日志结束标识，重启时据此确定写入位置
+	if db.loaded {
+		if err := db.activeFile.MarkEndOfLog(); err != nil {
+			return err
+		}
+		if err := db.activeFile.Sync(); err != nil {
+			return err
+		}
+	}
+
 	//关闭当前活跃文件
 	if err := db.activeFile.Close(); err != nil {
 		return err
@@ -93,6 +266,16 @@ func (db *DB) Close() error {
 	return nil
 }
 
+// 数据库是否已经关闭
+func (db *DB) isClosed() bool {
+	select {
+	case <-db.closeCh:
+		return true
+	default:
+		return false
+	}
+}
+
 // Sync 刷盘
 func (db *DB) Sync() error {
 	if db.activeFile == nil {
@@ -101,71 +284,129 @@ func (db *DB) Sync() error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	return db.activeFile.Sync()
+	return db.syncActiveFile()
+}
+
+// 持久化活跃文件，并记录持久化的耗时，调用方需要持有互斥锁
+func (db *DB) syncActiveFile() error {
+	defer db.metrics.syncLatency.observeSince(time.Now())
+	atomic.AddUint64(&db.metrics.syncs, 1)
+	if err := db.activeFile.Sync(); err != nil {
+		db.logger.Error("failed to sync data file", "fid", db.activeFile.FileId, "err", err)
+		db.events.syncError(SyncErrorInfo{FileId: db.activeFile.FileId, Err: err})
+		return err
+	}
+	return nil
 }
 
 // Put 写入KV数据，key不能为nil
 func (db *DB) Put(key []byte, value []byte) error {
+	return db.put(db.defaultBucket, key, value)
+}
+
+// 写入数据到指定的 bucket 中
+func (db *DB) put(b *Bucket, key []byte, value []byte) error {
+	defer db.metrics.putLatency.observeSince(time.Now())
+	atomic.AddUint64(&db.metrics.puts, 1)
+
 	//如果传递进来的key为nil，那么直接返回nil异常
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
 
+	//添加记录到文件，写入文件和更新索引需要在同一个锁内完成，保证索引与数据文件的写入位置一致
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.putLocked(b, key, value)
+}
+
+// 写入数据并更新索引，调用方需要持有互斥锁
+func (db *DB) putLocked(b *Bucket, key []byte, value []byte) error {
 	//根据kv构造一个记录对象LogRecord，记录对象表示落盘的一条记录
+	seqNo := atomic.AddUint64(&db.seqNo, 1)
 	logRecord := &data.LogRecord{
-		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
-		Value: value,
-		Type:  data.LogRecordNormal,
+		Key:        logRecordKeyWithSeq(key, seqNo),
+		Value:      value,
+		Type:       data.LogRecordNormal,
+		BucketId:   b.id,
+		AutoCommit: true,
 	}
-
-	//添加记录到文件
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
 
 	//文件写入后更新内存索引
-	if ok := db.index.Put(key, pos); !ok {
+	if ok := b.index.Put(key, pos); !ok {
 		return ErrIndexUpdateFailed
 	}
 
+	db.notifyWatchers(b.id, ChangePut, key, value, seqNo)
 	return nil
 }
 
 // Delete 删除操作
 // 如果内存中没有key，那么就不需要追加日志，如果有，那么就向磁盘中追加日志，然后删除内存中的key
 func (db *DB) Delete(key []byte) error {
+	return db.delete(db.defaultBucket, key)
+}
+
+// 删除指定 bucket 中的数据
+func (db *DB) delete(b *Bucket, key []byte) error {
+	defer db.metrics.deleteLatency.observeSince(time.Now())
+	atomic.AddUint64(&db.metrics.deletes, 1)
+
 	//判断key的有效性
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
 
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
 	//先检查key在索引里是否存在，如果不存在的话直接返回
-	if pos := db.index.Get(key); pos == nil {
+	if pos := b.index.Get(key); pos == nil {
 		return nil
 	}
+	return db.deleteLocked(b, key)
+}
 
+// 追加删除记录并删除索引，调用方需要持有互斥锁
+func (db *DB) deleteLocked(b *Bucket, key []byte) error {
 	//构造LogRecord记录对象，标记该记录是被删除的
+	seqNo := atomic.AddUint64(&db.seqNo, 1)
 	logRecord := &data.LogRecord{
-		Key:  logRecordKeyWithSeq(key, nonTransactionSeqNo),
-		Type: data.LogRecordDeleted,
+		Key:        logRecordKeyWithSeq(key, seqNo),
+		Type:       data.LogRecordDeleted,
+		BucketId:   b.id,
+		AutoCommit: true,
 	}
 	//将删除操作追加到数据文件中
-	_, err := db.appendLogRecordWithLock(logRecord)
+	_, err := db.appendLogRecord(logRecord)
 	if err != nil {
-		return nil
+		return err
 	}
 
 	//删除内存中对应的key
-	ok := db.index.Delete(key)
+	ok := b.index.Delete(key)
 	if !ok {
 		return ErrIndexUpdateFailed
 	}
+
+	db.notifyWatchers(b.id, ChangeDelete, key, nil, seqNo)
 	return nil
 }
 
 // Get 获取数据
 func (db *DB) Get(key []byte) ([]byte, error) {
+	return db.get(db.defaultBucket, key)
+}
+
+// 获取指定 bucket 中的数据
+func (db *DB) get(b *Bucket, key []byte) ([]byte, error) {
+	defer db.metrics.getLatency.observeSince(time.Now())
+	atomic.AddUint64(&db.metrics.gets, 1)
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
@@ -174,8 +415,13 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 		return nil, ErrKeyIsEmpty
 	}
 
+	//布隆过滤器判断 key 一定不存在时，无需访问索引
+	if !db.mayContainKey(key) {
+		return nil, ErrKeyNotFound
+	}
+
 	//从内存数据结构中取出key对应的索引信息
-	logRecordPos := db.index.Get(key)
+	logRecordPos := b.index.Get(key)
 	//如果key在内存索引中找不到，那么就说明key不存在
 	if logRecordPos == nil {
 		return nil, ErrKeyNotFound
@@ -184,26 +430,100 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	return db.getValueByPosition(logRecordPos)
 }
 
+// MultiGet 批量获取数据，返回的结果与传入的 key 一一对应，key 不存在时对应的错误为 ErrKeyNotFound
+// 一次性查询所有 key 的索引，再按照 (Fid, Offset) 排序后并发读取，相比逐个调用 Get 减少了加锁和随机读的开销
+func (db *DB) MultiGet(keys [][]byte) ([][]byte, []error) {
+	return db.multiGet(db.defaultBucket, keys)
+}
+
+// 批量获取指定 bucket 中的数据
+func (db *DB) multiGet(b *Bucket, keys [][]byte) ([][]byte, []error) {
+	defer db.metrics.getLatency.observeSince(time.Now())
+	atomic.AddUint64(&db.metrics.gets, uint64(len(keys)))
+
+	values := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	//查询所有 key 的索引，只读取存在的 key
+	var positions []*data.LogRecordPos
+	var indexes []int
+	for i, key := range keys {
+		if len(key) == 0 {
+			errs[i] = ErrKeyIsEmpty
+			continue
+		}
+		if !db.mayContainKey(key) {
+			errs[i] = ErrKeyNotFound
+			continue
+		}
+		logRecordPos := b.index.Get(key)
+		if logRecordPos == nil {
+			errs[i] = ErrKeyNotFound
+			continue
+		}
+		positions = append(positions, logRecordPos)
+		indexes = append(indexes, i)
+	}
+	if len(positions) == 0 {
+		return values, errs
+	}
+
+	found, foundErrs := db.getValuesByPositions(positions, multiGetWorkers)
+	for j, i := range indexes {
+		values[i], errs[i] = found[j], foundErrs[j]
+	}
+	return values, errs
+}
+
 // ListKeys 获取所有的key，返回二位数组，key[i]的i是迭代器下表
 func (db *DB) ListKeys() [][]byte {
-	iterator := db.index.Iterator(false)
-	keys := make([][]byte, db.index.Size())
-	var idx int
+	keys, _ := db.ListKeysContext(context.Background())
+	return keys
+}
+
+// ListKeysContext 获取数据库中所有的 key，ctx 被取消时返回 ctx.Err()
+func (db *DB) ListKeysContext(ctx context.Context) ([][]byte, error) {
+	return db.listKeys(ctx, db.defaultBucket)
+}
+
+// 获取指定 bucket 中所有的 key
+func (db *DB) listKeys(ctx context.Context, b *Bucket) ([][]byte, error) {
+	iterator := b.index.Iterator(false)
+	defer iterator.Close()
+	keys := make([][]byte, 0, b.index.Size())
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		keys[idx] = iterator.Key()
-		idx++
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		keys = append(keys, iterator.Key())
 	}
-	return keys
+	return keys, nil
 }
 
 // Fold 获取所有的数据，并执行用户指定的操作，函数返回 false 时终止遍历
+// 遍历时会批量预读 value，减少随机读带来的系统调用开销
 func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+	return db.FoldContext(context.Background(), fn)
+}
 
-	iterator := db.index.Iterator(false)
-	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		value, err := db.getValueByPosition(iterator.Value())
+// FoldContext 同 Fold，ctx 被取消时停止遍历并返回 ctx.Err()
+func (db *DB) FoldContext(ctx context.Context, fn func(key []byte, value []byte) bool) error {
+	return db.fold(ctx, db.defaultBucket, fn)
+}
+
+// 遍历指定 bucket 中所有的数据
+func (db *DB) fold(ctx context.Context, b *Bucket, fn func(key []byte, value []byte) bool) error {
+	iterator := db.newIterator(ctx, b, IteratorOptions{
+		PrefetchSize:    foldPrefetchSize,
+		PrefetchWorkers: foldPrefetchWorkers,
+	})
+	defer iterator.Close()
+
+	for ; iterator.Valid(); iterator.Next() {
+		value, err := iterator.Value()
 		if err != nil {
 			return err
 		}
@@ -211,14 +531,50 @@ func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 			break
 		}
 	}
-	return nil
+	return iterator.Err()
+}
+
+// ReadCacheStats 获取读缓存的命中统计信息，未开启读缓存时返回空的统计信息
+func (db *DB) ReadCacheStats() cache.Stats {
+	if db.readCache == nil {
+		return cache.Stats{}
+	}
+	return db.readCache.Stats()
 }
 
 // 根据指针获取硬盘文件中的数据
 func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error) {
-	//根据文件的id找到对应的数据文件
+	//合并操作数需要和之前的记录一起合并出完整的值
+	if logRecordPos.Operand {
+		return db.foldMergeOperands(logRecordPos)
+	}
+
+	logRecord, err := db.readLogRecordAt(logRecordPos)
+	if err != nil {
+		return nil, err
+	}
+
+	//如果磁盘中的数据类型是被删除，那么返回没找到
+	//如果一个数据被删除，那么对于索引来说key会被更新为最小的value，而磁盘文件中该记录会被以日志的形式记录
+	if logRecord.Type == data.LogRecordDeleted {
+		return nil, ErrKeyNotFound
+	}
+
+	//如果文件存在，能找到这个value且type不是被删除，那么返回value
+	return logRecord.Value, nil
+}
+
+// 根据指针读取硬盘文件中的记录，优先从读缓存中获取
+func (db *DB) readLogRecordAt(logRecordPos *data.LogRecordPos) (*data.LogRecord, error) {
+	if db.readCache != nil {
+		if logRecord, ok := db.readCache.Get(logRecordPos); ok {
+			return logRecord, nil
+		}
+	}
+
+	//根据文件的id找到对应的数据文件，副本重置之后没有活跃文件，之前取得的位置都已经失效
 	var dataFile *data.DataFile
-	if logRecordPos.Fid == db.activeFile.FileId {
+	if db.activeFile != nil && logRecordPos.Fid == db.activeFile.FileId {
 		dataFile = db.activeFile
 	} else {
 		dataFile = db.olderFiles[logRecordPos.Fid]
@@ -235,24 +591,66 @@ func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error
 		return nil, err
 	}
 
-	//如果磁盘中的数据类型是被删除，那么返回没找到
-	//如果一个数据被删除，那么对于索引来说key会被更新为最小的value，而磁盘文件中该记录会被以日志的形式记录
-	if logRecord.Type == data.LogRecordDeleted {
-		return nil, ErrKeyNotFound
+	if db.readCache != nil {
+		db.readCache.Put(logRecordPos, logRecord)
 	}
-
-	//如果文件存在，能找到这个value且type不是被删除，那么返回value
-	return logRecord.Value, nil
+	return logRecord, nil
 }
-func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	return db.appendLogRecord(logRecord)
+
+// 批量根据指针获取硬盘文件中的数据，返回的结果与传入的位置一一对应
+// 读取前按照 (Fid, Offset) 排序，让每个协程尽量顺序地读取同一个文件，调用方需要持有读锁
+func (db *DB) getValuesByPositions(positions []*data.LogRecordPos, workers int) ([][]byte, []error) {
+	values := make([][]byte, len(positions))
+	errs := make([]error, len(positions))
+
+	//按照文件id以及偏移量排序，记录排序后的下标
+	order := make([]int, len(positions))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		a, b := positions[order[i]], positions[order[j]]
+		if a.Fid != b.Fid {
+			return a.Fid < b.Fid
+		}
+		return a.Offset < b.Offset
+	})
+
+	if workers <= 0 {
+		workers = 1
+	}
+	if workers > len(order) {
+		workers = len(order)
+	}
+
+	//将排好序的位置切分成连续的若干段，每个协程负责一段
+	var wg sync.WaitGroup
+	chunkSize := (len(order) + workers - 1) / workers
+	for start := 0; start < len(order); start += chunkSize {
+		end := start + chunkSize
+		if end > len(order) {
+			end = len(order)
+		}
+		wg.Add(1)
+		go func(chunk []int) {
+			defer wg.Done()
+			for _, i := range chunk {
+				values[i], errs[i] = db.getValueByPosition(positions[i])
+			}
+		}(order[start:end])
+	}
+	wg.Wait()
+	return values, errs
 }
 
 // 添加记录方法，追加的形势
 // 添加记录需要通过db对文件进行操作，所以只能串行化去写，需要加锁
 func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+	//副本的数据文件需要和主库保持一致，只能写入从主库同步的日志
+	if db.isReplica() {
+		return nil, ErrReadOnlyReplica
+	}
+
 	//检测当前活跃文件是否存在，如果不存在，那么需要初始化活跃文件
 	if db.activeFile == nil {
 		//初始化文件，因为刚开始启动的时候没有初始文件
@@ -262,23 +660,20 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	}
 
 	//这里db对象就持有活跃文件对象了
-	//对记录对象进行编码，编码为文件写入字节流
-	encRecord, size := data.EncodeLogRecord(logRecord)
+	//对记录对象进行编码，编码为文件写入字节流，写入之后编码缓冲区放回缓冲池
+	bufPtr := encodeBufferPool.Get().(*[]byte)
+	encRecord, size := data.AppendLogRecord((*bufPtr)[:0], logRecord)
+	defer func() {
+		if cap(encRecord) <= maxPooledEncodeBufferSize {
+			*bufPtr = encRecord[:0]
+			encodeBufferPool.Put(bufPtr)
+		}
+	}()
 
 	//写入前的活跃文件检测
 	//判断是否可能写满当前活跃文件
 	if db.activeFile.WriteOff+size > db.options.DataFileSize {
-		//先持久化数据，保证已有的数据持久化到硬盘当中
-		if err := db.activeFile.Sync(); err != nil {
-			return nil, err
-		}
-
-		//持久化后需要将当前活跃文件转换为旧数据文件
-		//将当前活跃文件放入到旧数据文件map集合中，id为key
-		db.olderFiles[db.activeFile.FileId] = db.activeFile
-
-		//打开新的数据文件
-		if err := db.setActiveDataFile(); err != nil {
+		if err := db.rotateActiveFile(); err != nil {
 			return nil, err
 		}
 	}
@@ -289,14 +684,22 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	if err := db.activeFile.Write(encRecord); err != nil {
 		return nil, err
 	}
+	atomic.AddUint64(&db.metrics.bytesWritten, uint64(size))
+	db.appendSignal.notify()
 
 	//这里写入了只是写入到了操作系统缓存区，并没有立即入盘，这里需要根据用户配置来判断是否立即刷盘
 	if db.options.SyncWrites {
-		if err := db.activeFile.Sync(); err != nil {
+		if err := db.syncActiveFile(); err != nil {
 			return nil, err
 		}
 	}
 
+	//记录写入活跃文件的 key，用于封存时构造布隆过滤器
+	if logRecord.Type != data.LogRecordTxnFinished {
+		realKey, _ := parseLogRecordKey(logRecord.Key)
+		db.collectBloomKey(db.activeFile.FileId, realKey)
+	}
+
 	//返回内存索引信息，一条记录如果想定位到磁盘，那么需要他的文件id，文件内偏移量
 	pos := &data.LogRecordPos{
 		Fid:    db.activeFile.FileId,
@@ -305,6 +708,55 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	return pos, nil
 }
 
+// 封存当前活跃文件并打开新的活跃文件，调用方需要持有互斥锁
+func (db *DB) rotateActiveFile() error {
+	return db.rotateActiveFileTo(db.activeFile.FileId + 1)
+}
+
+// 封存当前活跃文件并打开指定 id 的数据文件作为新的活跃文件，调用方需要持有互斥锁
+// 副本按照主库的文件 id 切换活跃文件，主库 merge 之后文件 id 可能不连续
+func (db *DB) rotateActiveFileTo(fileId uint32) error {
+	//截断预先分配的空间，封存的文件长度就是数据的长度
+	if err := db.activeFile.Seal(); err != nil {
+		return err
+	}
+
+	//先持久化数据，保证已有的数据持久化到硬盘当中
+	if err := db.syncActiveFile(); err != nil {
+		return err
+	}
+
+	//构造并持久化封存文件的布隆过滤器
+	if db.bloomEnabled() {
+		if err := db.sealBloomFilter(db.activeFile.FileId); err != nil {
+			return err
+		}
+	}
+
+	//持久化后需要将当前活跃文件转换为旧数据文件
+	//将当前活跃文件放入到旧数据文件map集合中，id为key
+	sealedFile := db.activeFile
+	db.olderFiles[sealedFile.FileId] = sealedFile
+	atomic.AddUint64(&db.metrics.fileRotations, 1)
+
+	//在后台为封存的文件生成 hint 文件，下次启动时无需扫描整个数据文件
+	db.writeHintForSealedFile(sealedFile)
+
+	//打开新的数据文件
+	dataFile, err := db.openActiveDataFile(fileId)
+	if err != nil {
+		return err
+	}
+	db.activeFile = dataFile
+	db.logger.Debug("active file rotated", "sealed", sealedFile.FileId, "active", db.activeFile.FileId)
+	db.events.fileRotated(FileRotatedInfo{
+		SealedFileId: sealedFile.FileId,
+		NewFileId:    db.activeFile.FileId,
+		SealedSize:   sealedFile.WriteOff,
+	})
+	return nil
+}
+
 // 设置活跃文件：该方法需要在初始化/当前活跃文件写满的情况下调用
 // 注意调用这种数据库DB实例的共享数据改变操作方法，必须持有互斥锁
 func (db *DB) setActiveDataFile() error {
@@ -315,7 +767,7 @@ func (db *DB) setActiveDataFile() error {
 	}
 
 	//打开新的数据文件(路径由用户配置)
-	dataFile, err := data.OpenDataFile(db.options.DirPath, initialFileId)
+	dataFile, err := db.openActiveDataFile(initialFileId)
 	if err != nil {
 		return err
 	}
@@ -323,10 +775,32 @@ func (db *DB) setActiveDataFile() error {
 	return nil
 }
 
+// 打开新的活跃文件，开启预先分配时为其分配空间
+func (db *DB) openActiveDataFile(fileId uint32) (*data.DataFile, error) {
+	dataFile, err := data.OpenDataFileWithIO(db.options.DirPath, fileId, db.cipher, db.fs, fio.FileIOType(db.options.IOType))
+	if err != nil {
+		return nil, err
+	}
+	if err := db.preallocate(dataFile); err != nil {
+		_ = dataFile.Close()
+		return nil, err
+	}
+	dataFile.EnableWriteBuffer(db.options.WriteBufferSize)
+	return dataFile, nil
+}
+
+// 开启预先分配时为活跃文件分配 DataFileSize 大小的空间
+func (db *DB) preallocate(dataFile *data.DataFile) error {
+	if !db.options.Preallocate {
+		return nil
+	}
+	return dataFile.Preallocate(db.options.DataFileSize)
+}
+
 // 加载数据文件，db的activeFile以及olderFiles
 func (db *DB) loadDataFiles() error {
 	//根据配置项读取目录
-	dirEntries, err := os.ReadDir(db.options.DirPath)
+	dirEntries, err := db.fs.ReadDir(db.options.DirPath)
 	if err != nil {
 		return err
 	}
@@ -352,13 +826,19 @@ func (db *DB) loadDataFiles() error {
 	db.fileIds = fileIds
 	//遍历文件
 	for i, fid := range fileIds {
-		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fid))
+		//活跃文件使用配置的文件 IO 类型，旧数据文件只用于读取
+		ioType := fio.StandardFIO
+		if i == len(fileIds)-1 {
+			ioType = fio.FileIOType(db.options.IOType)
+		}
+		dataFile, err := data.OpenDataFileWithIO(db.options.DirPath, uint32(fid), db.cipher, db.fs, ioType)
 		if err != nil {
 			return err
 		}
 
 		//如果是最后一个文件，那么就是活跃文件
 		if i == len(fileIds)-1 {
+			dataFile.EnableWriteBuffer(db.options.WriteBufferSize)
 			db.activeFile = dataFile
 		} else { //如果不是那都是旧文件
 			db.olderFiles[uint32(fid)] = dataFile
@@ -370,7 +850,7 @@ func (db *DB) loadDataFiles() error {
 
 // 从数据文件中加载索引
 // 遍历所有文件中的数据，并且更新到内存索引中
-func (db *DB) loadIndexFromDataFiles() error {
+func (db *DB) loadIndexFromDataFiles(ctx context.Context) error {
 	//如果是0，那么是空的数据库，直接返回
 	if len(db.fileIds) == 0 {
 		return nil
@@ -379,99 +859,99 @@ func (db *DB) loadIndexFromDataFiles() error {
 	// 查看是否发生过 merge
 	hasMerge, nonMergeFileId := false, uint32(0)
 	mergeFinFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
-	if _, err := os.Stat(mergeFinFileName); err == nil {
-		fid, err := db.getNonMergeFileId(db.options.DirPath)
+	if _, err := db.fs.Stat(mergeFinFileName); err == nil {
+		gen, err := db.getMergeGeneration(db.options.DirPath)
 		if err != nil {
 			return err
 		}
 		hasMerge = true
-		nonMergeFileId = fid
+		nonMergeFileId = gen.nonMergeFileId
+		db.mergeGen = *gen
+		db.changesFloor = gen.changesFloor
 	}
 
-	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
-		// 因为按照文件id顺序遍历的，所以如果后续有追加了delete的record，那么需要删除这个索引中的kv
-		var ok bool
-		if typ == data.LogRecordDeleted {
-			ok = db.index.Delete(key)
-		} else {
-			ok = db.index.Put(key, pos)
-		}
-		if !ok {
-			panic("failed to update index at startup")
-		}
-	}
-
-	// 暂存事务数据
-	transactionRecords := make(map[uint64][]*data.TransactionRecord)
+	// 暂存事务数据，读取结束时仍未完成的事务保留在 pendingTxns 中，副本之后可能收到其完成标识
+	transactionRecords := db.pendingTxns
 	var currentSeqNo = nonTransactionSeqNo
+	if db.checkpoint != nil {
+		currentSeqNo = db.checkpoint.seqNo
+	}
+	// merge 文件中的记录不会被重放，序列号至少从 merge 时的序列号开始
+	if db.mergeGen.seqNo > currentSeqNo {
+		currentSeqNo = db.mergeGen.seqNo
+	}
 
-	//遍历所有文件id，处理文件数据
-	for i, fid := range db.fileIds {
+	//需要加载的数据文件，比最近未参与 merge 的文件 id 更小的，说明已经从 Hint 文件中加载索引了
+	//如果加载了索引快照，则只需要加载快照之后写入的数据
+	var dataFiles []*data.DataFile
+	for _, fid := range db.fileIds {
 		var fileId = uint32(fid)
-		// 如果比最近未参与 merge 的文件 id 更小，则说明已经从 Hint 文件中加载索引了
+		if db.checkpoint != nil && fileId < db.checkpoint.fid {
+			continue
+		}
 		if hasMerge && fileId < nonMergeFileId {
 			continue
 		}
-		var dataFile *data.DataFile
 		//判断是否是活跃文件
 		if fileId == db.activeFile.FileId {
-			dataFile = db.activeFile
+			dataFiles = append(dataFiles, db.activeFile)
 		} else {
-			dataFile = db.olderFiles[fileId]
+			dataFiles = append(dataFiles, db.olderFiles[fileId])
 		}
+	}
+
+	//并发解码各个数据文件，解码结果按照文件 id 的顺序依次更新到内存索引中，保证后写入的数据覆盖先写入的数据
+	decoder := db.decodeDataFiles(dataFiles)
+	defer decoder.close()
+	for i, dataFile := range dataFiles {
+		res := decoder.result(i)
+		if res.err != nil {
+			return res.err
+		}
+		fileId := dataFile.FileId
 
 		//循环处理每一行Record
-		var offset int64 = 0
-		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
-			if err != nil {
-				//如果是io问题，那么退出循环
-				if err == io.EOF {
-					break
-				}
+		for _, entry := range res.entries {
+			if err := ctx.Err(); err != nil {
 				return err
 			}
-
-			//构造内存索引并保存
-			logRecordPos := &data.LogRecordPos{
-				Fid:    fileId,
-				Offset: offset,
-			}
-
 			// 解析 key，拿到事务序列号
-			realKey, seqNo := parseLogRecordKey(logRecord.Key)
-			if seqNo == nonTransactionSeqNo {
+			realKey, seqNo := parseLogRecordKey(entry.key)
+			if entry.typ != data.LogRecordTxnFinished {
+				db.collectBloomKey(fileId, realKey)
+			}
+			if seqNo == nonTransactionSeqNo || entry.autoCommit {
 				// 非事务操作，直接更新内存索引
-				updateIndex(realKey, logRecord.Type, logRecordPos)
+				if err := db.replayIndexUpdate(entry.bucketId, realKey, entry.typ, entry.pos); err != nil {
+					return err
+				}
 			} else {
 				// 事务完成，对应的 seq no 的数据可以更新到内存索引中
-				if logRecord.Type == data.LogRecordTxnFinished {
+				if entry.typ == data.LogRecordTxnFinished {
 					for _, txnRecord := range transactionRecords[seqNo] {
-						updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
+						if err := db.replayIndexUpdate(txnRecord.Record.BucketId, txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos); err != nil {
+							return err
+						}
 					}
 					delete(transactionRecords, seqNo)
 				} else {
-					logRecord.Key = realKey
 					transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
-						Record: logRecord,
-						Pos:    logRecordPos,
+						Record: &data.LogRecord{Key: realKey, Type: entry.typ, BucketId: entry.bucketId},
+						Pos:    entry.pos,
 					})
 				}
 			}
 
-			// 更新事务序列号
+			// 更新序列号
 			if seqNo > currentSeqNo {
 				currentSeqNo = seqNo
 			}
-
-			//底座offset，下一次读取下一个Record
-			offset += size
 		}
 
 		//该文件读取完毕
 		//如果这个文件是当前活跃文件，那么需要记录当前文件写入指针（写入偏移量），方便put追加
-		if i == len(db.fileIds)-1 {
-			db.activeFile.WriteOff = offset
+		if fileId == db.activeFile.FileId {
+			db.activeFile.WriteOff = res.size
 		}
 	}
 
@@ -480,6 +960,108 @@ func (db *DB) loadIndexFromDataFiles() error {
 	return nil
 }
 
+// 按照写入的顺序重放一条已经提交的记录，更新对应 bucket 的内存索引
+func (db *DB) replayIndexUpdate(bucketId uint32, key []byte, typ data.LogRecordType, pos *data.LogRecordPos) error {
+	// 因为按照文件id顺序遍历的，所以如果后续有追加了delete的record，那么需要删除这个索引中的kv
+	// 删除的 key 可能已经在 merge 中被清理，索引中不存在是正常的
+	idx := db.bucketById(bucketId).index
+	if typ == data.LogRecordDeleted {
+		idx.Delete(key)
+		return nil
+	}
+	if typ == data.LogRecordMergeOperand {
+		pos.Operand = true
+		pos.Prev = idx.Get(key)
+	}
+	if ok := idx.Put(key, pos); !ok {
+		db.logger.Error("failed to update index", "fid", pos.Fid, "offset", pos.Offset)
+		return ErrIndexUpdateFailed
+	}
+	return nil
+}
+
+// 单个数据文件的解码结果
+type decodeResult struct {
+	entries []*replayEntry
+	size    int64
+	err     error
+}
+
+// 启动时并发解码数据文件，解码结果按照文件的顺序被取走
+type fileDecoder struct {
+	results []chan *decodeResult // 第 i 个文件的解码结果
+	tokens  chan struct{}        // 解码中以及已经解码但还未被取走的文件数量不超过并发数，避免占用过多内存
+	stop    chan struct{}        // 关闭后不再解码新的文件
+}
+
+// 使用 LoadConcurrency 个协程并发解码数据文件
+func (db *DB) decodeDataFiles(dataFiles []*data.DataFile) *fileDecoder {
+	concurrency := db.options.LoadConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	d := &fileDecoder{
+		results: make([]chan *decodeResult, len(dataFiles)),
+		tokens:  make(chan struct{}, concurrency),
+		stop:    make(chan struct{}),
+	}
+	for i := range d.results {
+		d.results[i] = make(chan *decodeResult, 1)
+	}
+
+	go func() {
+		for i, dataFile := range dataFiles {
+			select {
+			case d.tokens <- struct{}{}:
+			case <-d.stop:
+				return
+			}
+			go func(i int, dataFile *data.DataFile) {
+				isActive := dataFile.FileId == db.activeFile.FileId
+				entries, size, err := db.readReplayEntries(dataFile, !isActive, db.replayStartOffset(dataFile.FileId))
+				d.results[i] <- &decodeResult{entries: entries, size: size, err: err}
+			}(i, dataFile)
+		}
+	}()
+	return d
+}
+
+// 阻塞等待第 i 个文件的解码结果，取走后允许解码新的文件
+func (d *fileDecoder) result(i int) *decodeResult {
+	res := <-d.results[i]
+	<-d.tokens
+	return res
+}
+
+func (d *fileDecoder) close() {
+	close(d.stop)
+}
+
+// 根据配置项创建文件的加密方式，没有配置加密 key 时返回 nil，配置项需要先经过校验
+func newCipher(options Options) *data.Cipher {
+	if options.EncryptionKey == nil {
+		return nil
+	}
+	c, _ := data.NewCipher(options.EncryptionKey, options.OldEncryptionKeys...)
+	return c
+}
+
+// 按照配置项选择数据库使用的文件系统，并记录到配置项中，merge、分片等内部打开的数据库沿用同一个文件系统
+func openFileSystem(options *Options) fio.FileSystem {
+	if options.InMemory && options.DirPath == "" {
+		options.DirPath = inMemoryDirPath
+	}
+	if options.FileSystem == nil {
+		if options.InMemory {
+			options.FileSystem = fio.NewMemFileSystem()
+		} else {
+			options.FileSystem = fio.OS
+		}
+	}
+	return options.FileSystem
+}
+
 func checkOptions(options Options) error {
 	if options.DirPath == "" {
 		return errors.New("database dir path is empty")
@@ -487,5 +1069,16 @@ func checkOptions(options Options) error {
 	if options.DataFileSize <= 0 {
 		return errors.New("database data file size must to be greater than 0")
 	}
+	if options.IOType < StandardFIO || options.IOType > DirectFIO {
+		return errors.New("database file io type is invalid")
+	}
+	if options.EncryptionKey == nil && len(options.OldEncryptionKeys) > 0 {
+		return ErrInvalidEncryptionKey
+	}
+	for _, key := range append([][]byte{options.EncryptionKey}, options.OldEncryptionKeys...) {
+		if key != nil && len(key) != 16 && len(key) != 24 && len(key) != 32 {
+			return ErrInvalidEncryptionKey
+		}
+	}
 	return nil
 }
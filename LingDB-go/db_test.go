@@ -1,11 +1,17 @@
 package LingDB_go
 
 import (
+	"LingDB/LingDB-go/data"
+	"LingDB/LingDB-go/fio"
 	"LingDB/LingDB-go/utils"
+	"bytes"
+	"context"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -16,7 +22,7 @@ func destroyDB(db *DB) {
 		if db.activeFile != nil {
 			_ = db.Close()
 		}
-		err := os.RemoveAll(db.options.DirPath)
+		err := db.fs.RemoveAll(db.options.DirPath)
 		if err != nil {
 			panic(err)
 		}
@@ -25,9 +31,11 @@ func destroyDB(db *DB) {
 
 func TestOpen(t *testing.T) {
 	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-open")
+	opts.DirPath = dir
 	opts.DataFileSize = 64 * 1024 * 1024
 	db, err := Open(opts)
-	//defer destroyDB(db)
+	defer destroyDB(db)
 	assert.Nil(t, err)
 	assert.NotNil(t, db)
 }
@@ -40,6 +48,8 @@ func Test2(t *testing.T) {
 
 func TestDB_Main(t *testing.T) {
 	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-main")
+	opts.DirPath = dir
 	opts.DataFileSize = 64 * 1024 * 1024
 	db, err := Open(opts)
 	defer destroyDB(db)
@@ -62,6 +72,8 @@ func TestDB_Main(t *testing.T) {
 
 func TestDB_Put(t *testing.T) {
 	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-put")
+	opts.DirPath = dir
 	opts.DataFileSize = 64 * 1024 * 1024
 	db, err := Open(opts)
 	defer destroyDB(db)
@@ -116,6 +128,8 @@ func TestDB_Put(t *testing.T) {
 
 func TestDB_Get(t *testing.T) {
 	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-get")
+	opts.DirPath = dir
 	opts.DataFileSize = 64 * 1024 * 1024
 	db, err := Open(opts)
 	defer destroyDB(db)
@@ -180,6 +194,8 @@ func TestDB_Get(t *testing.T) {
 
 func TestDB_Delete(t *testing.T) {
 	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-delete")
+	opts.DirPath = dir
 	opts.DataFileSize = 64 * 1024 * 1024
 	db, err := Open(opts)
 	defer destroyDB(db)
@@ -227,6 +243,8 @@ func TestDB_Delete(t *testing.T) {
 
 func TestDB_ListKeys(t *testing.T) {
 	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-list-keys")
+	opts.DirPath = dir
 	db, err := Open(opts)
 	defer destroyDB(db)
 	assert.Nil(t, err)
@@ -260,8 +278,8 @@ func TestDB_ListKeys(t *testing.T) {
 
 func TestDB_Fold(t *testing.T) {
 	opts := DefaultOptions
-	//dir, _ := os.MkdirTemp("", "bitcask-go-fold")
-	//opts.DirPath = dir
+	dir, _ := os.MkdirTemp("", "bitcask-go-fold")
+	opts.DirPath = dir
 	db, err := Open(opts)
 	defer destroyDB(db)
 	assert.Nil(t, err)
@@ -287,8 +305,8 @@ func TestDB_Fold(t *testing.T) {
 
 func TestDB_Close(t *testing.T) {
 	opts := DefaultOptions
-	//dir, _ := os.MkdirTemp("", "bitcask-go-close")
-	//opts.DirPath = dir
+	dir, _ := os.MkdirTemp("", "bitcask-go-close")
+	opts.DirPath = dir
 	db, err := Open(opts)
 	defer destroyDB(db)
 	assert.Nil(t, err)
@@ -300,8 +318,8 @@ func TestDB_Close(t *testing.T) {
 
 func TestDB_Sync(t *testing.T) {
 	opts := DefaultOptions
-	//dir, _ := os.MkdirTemp("", "bitcask-go-sync")
-	//opts.DirPath = dir
+	dir, _ := os.MkdirTemp("", "bitcask-go-sync")
+	opts.DirPath = dir
 	db, err := Open(opts)
 	defer destroyDB(db)
 	assert.Nil(t, err)
@@ -316,9 +334,11 @@ func TestDB_Sync(t *testing.T) {
 
 func TestDB_Merge(t *testing.T) {
 	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge")
+	opts.DirPath = dir
 	opts.DataFileSize = 64 * 1024 * 1024
 	db, err := Open(opts)
-	//defer destroyDB(db)
+	defer destroyDB(db)
 	assert.Nil(t, err)
 	assert.NotNil(t, db)
 
@@ -339,3 +359,682 @@ func TestDB_Merge(t *testing.T) {
 	//assert.Nil(t, err)
 	//assert.NotNil(t, db)
 }
+
+func TestDB_ReadCache(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-read-cache")
+	opts.DirPath = dir
+	opts.ReadCacheSize = 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	val := utils.RandomValue(128)
+	err = db.Put(utils.GetTestKey(1), val)
+	assert.Nil(t, err)
+
+	// 第一次读取未命中，之后都会命中缓存
+	for i := 0; i < 3; i++ {
+		val1, err := db.Get(utils.GetTestKey(1))
+		assert.Nil(t, err)
+		assert.Equal(t, val, val1)
+	}
+	stats := db.ReadCacheStats()
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+
+	// 更新后读取到的是新的数据
+	val2 := utils.RandomValue(128)
+	err = db.Put(utils.GetTestKey(1), val2)
+	assert.Nil(t, err)
+	val3, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, val2, val3)
+
+	// 删除后无法读取
+	err = db.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_LoadConcurrency(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-load")
+	opts.DirPath = dir
+	opts.DataFileSize = 16 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 3000; i++ {
+		err := db.Put(utils.GetTestKey(rand.Intn(500)), utils.RandomValue(32))
+		assert.Nil(t, err)
+		if i%7 == 0 {
+			err := db.Delete(utils.GetTestKey(rand.Intn(500)))
+			assert.Nil(t, err)
+		}
+		if i%100 == 0 {
+			wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+			for j := 0; j < 50; j++ {
+				err := wb.Put(utils.GetTestKey(rand.Intn(500)), utils.RandomValue(32))
+				assert.Nil(t, err)
+			}
+			err := wb.Commit()
+			assert.Nil(t, err)
+		}
+	}
+	assert.True(t, len(db.olderFiles) > 10)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 删除部分 hint 文件，让部分文件从数据文件中加载
+	for fid := range db.olderFiles {
+		if fid%2 == 0 {
+			_ = os.Remove(data.GetDataHintFileName(dir, fid))
+		}
+	}
+
+	snapshot := func(db *DB) map[string]data.LogRecordPos {
+		positions := make(map[string]data.LogRecordPos)
+		iter := db.index.Iterator(false)
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			positions[string(iter.Key())] = *iter.Value()
+		}
+		return positions
+	}
+
+	// 顺序加载
+	opts.LoadConcurrency = 1
+	db1, err := Open(opts)
+	assert.Nil(t, err)
+	expected := snapshot(db1)
+	expectedSeqNo, expectedWriteOff := db1.seqNo, db1.activeFile.WriteOff
+	err = db1.Close()
+	assert.Nil(t, err)
+
+	// 并发加载的结果与顺序加载完全一致
+	for _, concurrency := range []int{2, 8, 64} {
+		opts.LoadConcurrency = concurrency
+		db2, err := Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, snapshot(db2))
+		assert.Equal(t, expectedSeqNo, db2.seqNo)
+		assert.Equal(t, expectedWriteOff, db2.activeFile.WriteOff)
+		err = db2.Close()
+		assert.Nil(t, err)
+	}
+}
+
+// 调用 Err 超过 n 次之后被取消的 context，用于在长时间运行的操作中途取消
+type countdownContext struct {
+	context.Context
+	n int64
+}
+
+func (c *countdownContext) Err() error {
+	if atomic.AddInt64(&c.n, -1) < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestDB_FoldContext(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-fold-context")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(20))
+		assert.Nil(t, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var count int
+	err = db.FoldContext(ctx, func(key []byte, value []byte) bool {
+		count++
+		if count == 10 {
+			cancel()
+		}
+		return true
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 10, count)
+
+	keys, err := db.ListKeysContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+	assert.Nil(t, keys)
+
+	keys, err = db.ListKeysContext(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(keys))
+}
+
+func TestDB_MergeContext(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-context")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// merge 进行到一半时被取消，不会留下 merge 目录
+	err = db.MergeContext(&countdownContext{Context: context.Background(), n: 200})
+	assert.Equal(t, context.Canceled, err)
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+
+	// 取消之后可以重新 merge
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	db = db2
+	assert.Equal(t, 500, len(db2.ListKeys()))
+}
+
+func TestDB_OpenContext(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-open-context")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 加载索引的过程中被取消
+	db2, err := OpenContext(&countdownContext{Context: context.Background(), n: 100}, opts)
+	assert.Equal(t, context.Canceled, err)
+	assert.Nil(t, db2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	db2, err = OpenContext(ctx, opts)
+	assert.Equal(t, context.Canceled, err)
+	assert.Nil(t, db2)
+
+	db3, err := OpenContext(context.Background(), opts)
+	assert.Nil(t, err)
+	db = db3
+	assert.Equal(t, 1000, len(db3.ListKeys()))
+}
+
+func TestDB_MultiGet(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-multi-get")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	values := make(map[int][]byte)
+	for i := 0; i < 1000; i++ {
+		val := utils.RandomValue(64)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), val))
+		values[i] = val
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.True(t, len(db.olderFiles) > 0)
+
+	// key 的顺序和数据在文件中的顺序不一致，结果仍然按照传入的顺序返回
+	var keys [][]byte
+	for i := 999; i >= 0; i -= 3 {
+		keys = append(keys, utils.GetTestKey(i))
+	}
+	keys = append(keys, nil, []byte("not-exist"))
+	vals, errs := db.MultiGet(keys)
+	assert.Equal(t, len(keys), len(vals))
+	assert.Equal(t, len(keys), len(errs))
+	for j, i := 0, 999; i >= 0; j, i = j+1, i-3 {
+		if i < 100 {
+			assert.Equal(t, ErrKeyNotFound, errs[j])
+			assert.Nil(t, vals[j])
+		} else {
+			assert.Nil(t, errs[j])
+			assert.Equal(t, values[i], vals[j])
+		}
+	}
+	assert.Equal(t, ErrKeyIsEmpty, errs[len(keys)-2])
+	assert.Equal(t, ErrKeyNotFound, errs[len(keys)-1])
+
+	vals, errs = db.MultiGet(nil)
+	assert.Equal(t, 0, len(vals))
+	assert.Equal(t, 0, len(errs))
+}
+
+// 目录中是否有文件包含明文
+func dirContains(t *testing.T, dir string, plain []byte) bool {
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		assert.Nil(t, err)
+		if bytes.Contains(content, plain) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDB_Encryption(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.EncryptionKey = bytes.Repeat([]byte{1}, 32)
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	bucket, err := db.Bucket("secret-bucket")
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte(fmt.Sprintf("secret-value-%d", i))))
+	}
+	assert.Nil(t, bucket.Put([]byte("secret-key"), []byte("secret-value")))
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	// 数据文件、hint 文件以及 merge 完成文件中都没有明文
+	assert.False(t, dirContains(t, dir, []byte("secret")))
+	assert.False(t, dirContains(t, db.getMergePath(), []byte("secret")))
+	assert.False(t, dirContains(t, db.getMergePath(), []byte(mergeFinishedKey)))
+
+	// 没有 key 或者 key 不正确时无法打开
+	wrongOpts := opts
+	wrongOpts.EncryptionKey = nil
+	_, err = Open(wrongOpts)
+	assert.Equal(t, data.ErrUnknownEncryptionKey, err)
+	wrongOpts.EncryptionKey = []byte("short")
+	_, err = Open(wrongOpts)
+	assert.Equal(t, ErrInvalidEncryptionKey, err)
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 500, len(db.ListKeys()))
+	value, err := db.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("secret-value-999"), value)
+	bucket, err = db.Bucket("secret-bucket")
+	assert.Nil(t, err)
+	value, err = bucket.Get([]byte("secret-key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("secret-value"), value)
+}
+
+func TestDB_EncryptionKeyRotation(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption-rotation")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 开启加密之前写入的明文数据
+	_, err = db.Bucket("plain-bucket")
+	assert.Nil(t, err)
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte(fmt.Sprintf("plain-value-%d", i))))
+	}
+	assert.Nil(t, db.Close())
+
+	// 开启加密之后仍然可以读取明文数据，merge 之后所有的文件都被加密
+	oldKey := bytes.Repeat([]byte{1}, 16)
+	opts.EncryptionKey = oldKey
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 500; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte(fmt.Sprintf("plain-value-%d", i))))
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+	assert.False(t, dirContains(t, dir, []byte("plain")))
+
+	// 轮换 key，旧的 key 只用于读取，merge 并重启之后不再需要旧的 key
+	newKey := bytes.Repeat([]byte{2}, 32)
+	opts.EncryptionKey = newKey
+	opts.OldEncryptionKeys = [][]byte{oldKey}
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	value, err := db.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("plain-value-10"), value)
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	opts.OldEncryptionKeys = nil
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+	value, err = db.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("plain-value-999"), value)
+	assert.Equal(t, []string{"plain-bucket"}, db.BucketNames())
+}
+
+func TestDB_Preallocate(t *testing.T) {
+	var truncated []TornWriteTruncatedInfo
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-preallocate")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.Preallocate = true
+	opts.EventListener = EventListener{
+		OnTornWriteTruncated: func(info TornWriteTruncatedInfo) {
+			truncated = append(truncated, info)
+		},
+	}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.True(t, len(db.olderFiles) > 0)
+	// 封存的文件截断了预先分配的空间，活跃文件预先分配了 DataFileSize 大小的空间
+	for _, dataFile := range db.olderFiles {
+		size, err := dataFile.Size()
+		assert.Nil(t, err)
+		assert.Equal(t, dataFile.WriteOff, size)
+	}
+	fid, writeOff := db.activeFile.FileId, db.activeFile.WriteOff
+	size, err := db.activeFile.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, opts.DataFileSize, size)
+	assert.Nil(t, db.Close())
+
+	// 正常关闭时写入了结束标识，重启后写入位置不变，也不会报告写了一半的数据
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	db = db2
+	assert.Equal(t, 0, len(truncated))
+	assert.Equal(t, writeOff, db2.activeFile.WriteOff)
+	size, err = db2.activeFile.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, opts.DataFileSize, size)
+	assert.Nil(t, db2.Put([]byte("after-reopen"), []byte("value")))
+	writeOff = db2.activeFile.WriteOff
+	assert.Nil(t, db2.Close())
+
+	// 模拟崩溃时没有写入结束标识：写入位置之后全为 0
+	fileName := data.GetDataFileName(dir, fid)
+	content, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	headerSize := int64(len(content)) - opts.DataFileSize
+	for i := headerSize + writeOff; i < int64(len(content)); i++ {
+		content[i] = 0
+	}
+	assert.Nil(t, os.WriteFile(fileName, content, 0644))
+
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	db = db3
+	assert.Equal(t, 0, len(truncated))
+	assert.Equal(t, writeOff, db3.activeFile.WriteOff)
+	val, err := db3.Get([]byte("after-reopen"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+	assert.Nil(t, db3.Close())
+
+	// 写了一半的记录仍然会被截断并报告
+	content, err = os.ReadFile(fileName)
+	assert.Nil(t, err)
+	copy(content[headerSize+writeOff:], "torn")
+	assert.Nil(t, os.WriteFile(fileName, content, 0644))
+
+	db4, err := Open(opts)
+	assert.Nil(t, err)
+	db = db4
+	assert.Equal(t, 1, len(truncated))
+	assert.Equal(t, writeOff, truncated[0].Offset)
+	assert.Equal(t, writeOff, db4.activeFile.WriteOff)
+	for i := 0; i < 2000; i++ {
+		_, err := db4.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+}
+
+func TestDB_IOType(t *testing.T) {
+	for name, ioType := range map[string]FileIOType{"datasync": DataSyncFIO, "dsync": DSyncFIO, "direct": DirectFIO} {
+		t.Run(name, func(t *testing.T) {
+			opts := DefaultOptions
+			dir, _ := os.MkdirTemp("", "bitcask-go-io-type")
+			opts.DirPath = dir
+			opts.DataFileSize = 64 * 1024
+			opts.IOType = ioType
+			opts.Preallocate = true
+			db, err := Open(opts)
+			defer destroyDB(db)
+			if err != nil && ioType == DirectFIO {
+				_ = os.RemoveAll(dir)
+				t.Skipf("direct io is not supported: %v", err)
+			}
+			assert.Nil(t, err)
+
+			for i := 0; i < 1000; i++ {
+				assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+			}
+			for i := 0; i < 500; i++ {
+				assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+			}
+			assert.Nil(t, db.Sync())
+			assert.True(t, len(db.olderFiles) > 0)
+			assert.Nil(t, db.Merge())
+			assert.Nil(t, db.Close())
+
+			db2, err := Open(opts)
+			assert.Nil(t, err)
+			db = db2
+			assert.Equal(t, 500, len(db2.ListKeys()))
+			for i := 500; i < 1000; i++ {
+				val, err := db2.Get(utils.GetTestKey(i))
+				assert.Nil(t, err)
+				assert.Equal(t, utils.GetTestKey(i), val)
+			}
+		})
+	}
+}
+
+func BenchmarkDB_PutSync(b *testing.B) {
+	for name, ioType := range map[string]FileIOType{"standard": StandardFIO, "datasync": DataSyncFIO, "dsync": DSyncFIO, "direct": DirectFIO} {
+		b.Run(name, func(b *testing.B) {
+			opts := DefaultOptions
+			dir, _ := os.MkdirTemp("", "bitcask-go-put-sync")
+			opts.DirPath = dir
+			opts.SyncWrites = true
+			opts.Preallocate = true
+			opts.IOType = ioType
+			db, err := Open(opts)
+			defer destroyDB(db)
+			if err != nil {
+				_ = os.RemoveAll(dir)
+				b.Skipf("failed to open db: %v", err)
+			}
+
+			value := utils.RandomValue(128)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := db.Put(utils.GetTestKey(i), value); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestDB_WriteBuffer(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-write-buffer")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.WriteBufferSize = 4096
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 还在缓冲区中的数据可以读取、遍历
+	assert.Nil(t, db.Put([]byte("buffered"), []byte("value")))
+	val, err := db.Get([]byte("buffered"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+	iter := db.NewIterator(DefaultIteratorOptions)
+	assert.True(t, iter.Valid())
+	val, err = iter.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+	iter.Close()
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, wb.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, wb.Commit())
+	assert.True(t, len(db.olderFiles) > 0)
+	for i := 100; i < 2000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+
+	// Sync 之后缓冲区中的数据都写入了文件
+	assert.Nil(t, db.Put([]byte("synced"), []byte("value")))
+	assert.Nil(t, db.Sync())
+	size, err := db.activeFile.IoManager.Size()
+	assert.Nil(t, err)
+	content, err := os.ReadFile(data.GetDataFileName(dir, db.activeFile.FileId))
+	assert.Nil(t, err)
+	assert.Equal(t, size, int64(len(content)))
+	assert.True(t, bytes.Contains(content, []byte("synced")))
+
+	// 关闭时写入缓冲区中剩余的数据，merge 之后重启数据不变
+	assert.Nil(t, db.Put([]byte("closed"), []byte("value")))
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	db = db2
+	assert.Equal(t, 1903, len(db2.ListKeys()))
+	val, err = db2.Get([]byte("closed"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+}
+
+func BenchmarkDB_Put(b *testing.B) {
+	for _, bufferSize := range []int{0, 64 * 1024} {
+		b.Run(fmt.Sprintf("buffer-%d", bufferSize), func(b *testing.B) {
+			opts := DefaultOptions
+			dir, _ := os.MkdirTemp("", "bitcask-go-put")
+			opts.DirPath = dir
+			opts.WriteBufferSize = bufferSize
+			db, err := Open(opts)
+			defer destroyDB(db)
+			assert.Nil(b, err)
+
+			value := utils.RandomValue(16)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := db.Put(utils.GetTestKey(i), value); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestDB_InMemory(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "bitcask-go-in-memory"
+	opts.DataFileSize = 64 * 1024
+	opts.InMemory = true
+	opts.FileSystem = fio.NewMemFileSystem()
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.True(t, len(db.olderFiles) > 0)
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	// 数据只保存在内存中，没有创建任何目录
+	_, err = os.Stat(opts.DirPath)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(opts.DirPath + mergeDirName)
+	assert.True(t, os.IsNotExist(err))
+
+	// 使用同一个内存文件系统重新打开，merge 的结果在启动时生效
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	db = db2
+	assert.Equal(t, 1000, len(db2.ListKeys()))
+	_, err = db2.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db2.Get(utils.GetTestKey(1999))
+	assert.Nil(t, err)
+
+	// 没有设置文件系统时每次打开都是一个新的空数据库，DirPath 可以为空
+	for _, dirPath := range []string{opts.DirPath, ""} {
+		emptyOpts := opts
+		emptyOpts.DirPath = dirPath
+		emptyOpts.FileSystem = nil
+		db3, err := Open(emptyOpts)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(db3.ListKeys()))
+		assert.Nil(t, db3.Put([]byte("key"), []byte("value")))
+		assert.Nil(t, db3.Merge())
+		value, err := db3.Get([]byte("key"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("value"), value)
+		destroyDB(db3)
+	}
+}
@@ -3,11 +3,29 @@ package LingDB_go
 import "errors"
 
 var (
-	ErrKeyIsEmpty             = errors.New("the key is empty")
-	ErrIndexUpdateFailed      = errors.New("failed to update index")
-	ErrKeyNotFound            = errors.New("key not found in database")
-	ErrDataFileNotFound       = errors.New("data file is not found")
-	ErrDataDirectoryCorrupted = errors.New("the database directory maybe corrupted")
-	ErrExceedMaxBatchNum      = errors.New("exceed the max batch num")
-	ErrMergeIsProgress        = errors.New("merge is in progress, try again later")
+	ErrKeyIsEmpty                 = errors.New("the key is empty")
+	ErrIndexUpdateFailed          = errors.New("failed to update index")
+	ErrKeyNotFound                = errors.New("key not found in database")
+	ErrDataFileNotFound           = errors.New("data file is not found")
+	ErrDataDirectoryCorrupted     = errors.New("the database directory maybe corrupted")
+	ErrExceedMaxBatchNum          = errors.New("exceed the max batch num")
+	ErrMergeIsProgress            = errors.New("merge is in progress, try again later")
+	ErrIteratorKeyOnly            = errors.New("the iterator is key only, value is not available")
+	ErrBucketNotInDB              = errors.New("the bucket does not belong to this database")
+	ErrValueMismatch              = errors.New("the current value does not match the expected value")
+	ErrKeyExists                  = errors.New("key already exists in database")
+	ErrMergeOperatorNotSet        = errors.New("the merge operator is not set in options")
+	ErrInvalidMergeOperand        = errors.New("the merge operand is invalid")
+	ErrWatchOverflow              = errors.New("the watcher buffer is full, some changes are lost")
+	ErrDatabaseClosed             = errors.New("the database is closed")
+	ErrChangesCompacted           = errors.New("the changes since the sequence number have been removed by merge")
+	ErrReadOnlyReplica            = errors.New("the database is a read only replica")
+	ErrReplicationInProgress      = errors.New("the replica is already replicating from a primary")
+	ErrReplicaOutOfSync           = errors.New("the replicated log record does not match the replica position")
+	ErrInvalidReplication         = errors.New("invalid replication message")
+	ErrReplicationMessageTooLarge = errors.New("the replication message is larger than a data file")
+	ErrSnapshotDirExists          = errors.New("the snapshot directory already exists")
+	ErrInvalidShardCount          = errors.New("the shard count must be greater than 0")
+	ErrShardCountMismatch         = errors.New("the shard count does not match the existing sharded database")
+	ErrInvalidEncryptionKey       = errors.New("the encryption keys must be 16, 24 or 32 bytes")
 )
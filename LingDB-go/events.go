@@ -0,0 +1,153 @@
+package LingDB_go
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Logger 结构化日志接口，keysAndValues 为交替出现的 key 和 value，用户可以接入自己的日志库
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// EventListener 存储引擎事件的回调，未设置的回调不会被调用
+// 回调可能在持有数据库锁的情况下被调用，不能在回调中调用数据库的读写方法
+type EventListener struct {
+	OnFileRotated        func(info FileRotatedInfo)        // 活跃文件写满后被封存
+	OnMergeBegin         func(info MergeInfo)              // merge 开始
+	OnMergeEnd           func(info MergeInfo)              // merge 结束，无论成功还是失败
+	OnMergeInstalled     func(info MergeInstalledInfo)     // 启动时 merge 产生的文件替换了旧的数据文件
+	OnTornWriteTruncated func(info TornWriteTruncatedInfo) // 启动时活跃文件末尾写了一半的数据被截断
+	OnSyncError          func(info SyncErrorInfo)          // 数据文件持久化失败
+}
+
+// FileRotatedInfo 活跃文件封存事件
+type FileRotatedInfo struct {
+	SealedFileId uint32 // 被封存的文件 id
+	NewFileId    uint32 // 新的活跃文件 id
+	SealedSize   int64  // 被封存的文件大小
+}
+
+// MergeInfo merge 事件
+type MergeInfo struct {
+	NonMergeFileId uint32        // 最近没有参与 merge 的文件 id
+	Duration       time.Duration // merge 耗时，只在 OnMergeEnd 中有效
+	Err            error         // merge 失败的原因，只在 OnMergeEnd 中有效
+}
+
+// MergeInstalledInfo merge 文件替换事件
+type MergeInstalledInfo struct {
+	NonMergeFileId uint32 // 比该 id 小的数据文件都被 merge 产生的文件替换
+	Files          int    // 移动到数据目录中的文件数量
+}
+
+// TornWriteTruncatedInfo 截断写了一半的数据事件
+type TornWriteTruncatedInfo struct {
+	FileId       uint32 // 被截断的文件 id
+	Offset       int64  // 截断的位置，即最后一条完整记录的结束位置
+	DroppedBytes int64  // 被丢弃的字节数
+	Err          error  // 读取时遇到的错误
+}
+
+// SyncErrorInfo 持久化失败事件
+type SyncErrorInfo struct {
+	FileId uint32 // 持久化失败的文件 id
+	Err    error  // 失败的原因
+}
+
+// NewWriterLogger 初始化一个输出到 w 的日志，每条日志一行，格式为 时间 级别 消息 key=value...
+func NewWriterLogger(w io.Writer) Logger {
+	return &writerLogger{w: w, mu: new(sync.Mutex)}
+}
+
+type writerLogger struct {
+	w  io.Writer
+	mu *sync.Mutex
+}
+
+func (l *writerLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.log("DEBUG", msg, keysAndValues)
+}
+
+func (l *writerLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.log("INFO", msg, keysAndValues)
+}
+
+func (l *writerLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.log("WARN", msg, keysAndValues)
+}
+
+func (l *writerLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.log("ERROR", msg, keysAndValues)
+}
+
+func (l *writerLogger) log(level, msg string, keysAndValues []interface{}) {
+	var sb strings.Builder
+	sb.WriteString(time.Now().Format("2006-01-02 15:04:05.000"))
+	sb.WriteString(" ")
+	sb.WriteString(level)
+	sb.WriteString(" ")
+	sb.WriteString(msg)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if i+1 < len(keysAndValues) {
+			sb.WriteString(fmt.Sprintf(" %v=%v", keysAndValues[i], keysAndValues[i+1]))
+		} else {
+			sb.WriteString(fmt.Sprintf(" %v", keysAndValues[i]))
+		}
+	}
+	sb.WriteString("\n")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = io.WriteString(l.w, sb.String())
+}
+
+// 不输出任何日志，用户没有配置日志时使用
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+
+func (l *EventListener) fileRotated(info FileRotatedInfo) {
+	if l.OnFileRotated != nil {
+		l.OnFileRotated(info)
+	}
+}
+
+func (l *EventListener) mergeBegin(info MergeInfo) {
+	if l.OnMergeBegin != nil {
+		l.OnMergeBegin(info)
+	}
+}
+
+func (l *EventListener) mergeEnd(info MergeInfo) {
+	if l.OnMergeEnd != nil {
+		l.OnMergeEnd(info)
+	}
+}
+
+func (l *EventListener) mergeInstalled(info MergeInstalledInfo) {
+	if l.OnMergeInstalled != nil {
+		l.OnMergeInstalled(info)
+	}
+}
+
+func (l *EventListener) tornWriteTruncated(info TornWriteTruncatedInfo) {
+	if l.OnTornWriteTruncated != nil {
+		l.OnTornWriteTruncated(info)
+	}
+}
+
+func (l *EventListener) syncError(info SyncErrorInfo) {
+	if l.OnSyncError != nil {
+		l.OnSyncError(info)
+	}
+}
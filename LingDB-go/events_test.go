@@ -0,0 +1,143 @@
+package LingDB_go
+
+import (
+	"LingDB/LingDB-go/data"
+	"LingDB/LingDB-go/utils"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDB_EventListener(t *testing.T) {
+	var mu sync.Mutex
+	var rotated []FileRotatedInfo
+	var mergeBegin, mergeEnd []MergeInfo
+	var installed []MergeInstalledInfo
+
+	var logs bytes.Buffer
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-events")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.Logger = NewWriterLogger(&logs)
+	opts.EventListener = EventListener{
+		OnFileRotated: func(info FileRotatedInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			rotated = append(rotated, info)
+		},
+		OnMergeBegin: func(info MergeInfo) {
+			mergeBegin = append(mergeBegin, info)
+		},
+		OnMergeEnd: func(info MergeInfo) {
+			mergeEnd = append(mergeEnd, info)
+		},
+		OnMergeInstalled: func(info MergeInstalledInfo) {
+			installed = append(installed, info)
+		},
+	}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	mu.Lock()
+	assert.True(t, len(rotated) > 0)
+	for _, info := range rotated {
+		assert.Equal(t, info.SealedFileId+1, info.NewFileId)
+		assert.True(t, info.SealedSize > 0)
+	}
+	rotations := len(rotated)
+	mu.Unlock()
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(mergeBegin))
+	assert.Equal(t, 1, len(mergeEnd))
+	assert.Equal(t, mergeBegin[0].NonMergeFileId, mergeEnd[0].NonMergeFileId)
+	assert.Nil(t, mergeEnd[0].Err)
+	assert.True(t, mergeEnd[0].Duration > 0)
+	// merge 会先封存当前活跃文件
+	assert.Equal(t, rotations+1, len(rotated))
+
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	db = db2
+	assert.Equal(t, 1, len(installed))
+	assert.Equal(t, mergeEnd[0].NonMergeFileId, installed[0].NonMergeFileId)
+	assert.Equal(t, 1000, len(db2.ListKeys()))
+
+	assert.True(t, strings.Contains(logs.String(), "merge finished"))
+	assert.True(t, strings.Contains(logs.String(), "merge files installed"))
+}
+
+func TestDB_TornWriteTruncated(t *testing.T) {
+	var truncated []TornWriteTruncatedInfo
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-torn-write")
+	opts.DirPath = dir
+	opts.EventListener = EventListener{
+		OnTornWriteTruncated: func(info TornWriteTruncatedInfo) {
+			truncated = append(truncated, info)
+		},
+	}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	fid, writeOff := db.activeFile.FileId, db.activeFile.WriteOff
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 模拟崩溃时写了一半的记录：截掉最后一条记录的末尾
+	fileName := data.GetDataFileName(dir, fid)
+	stat, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	err = os.Truncate(fileName, stat.Size()-3)
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	db = db2
+	assert.Equal(t, 1, len(truncated))
+	assert.Equal(t, fid, truncated[0].FileId)
+	assert.Equal(t, db2.activeFile.WriteOff, truncated[0].Offset)
+	assert.Equal(t, writeOff-3-truncated[0].Offset, truncated[0].DroppedBytes)
+
+	// 最后一条记录丢失，其余数据正常
+	_, err = db2.Get(utils.GetTestKey(99))
+	assert.Equal(t, ErrKeyNotFound, err)
+	for i := 0; i < 99; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+
+	// 截断之后可以继续写入，重启后不再截断
+	err = db2.Put(utils.GetTestKey(99), []byte("new-value"))
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	db = db3
+	assert.Equal(t, 1, len(truncated))
+	val, err := db3.Get(utils.GetTestKey(99))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new-value"), val)
+}
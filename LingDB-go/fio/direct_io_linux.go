@@ -0,0 +1,180 @@
+//go:build linux
+
+package fio
+
+import (
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// O_DIRECT 要求写入的缓冲区地址、文件位置以及长度都按照块对齐
+const directIOBlockSize = 4096
+
+// DirectIO 使用 O_DIRECT 绕过页缓存写入，持久化时使用 fdatasync
+// 不完整的块在写入时补齐后整块写入，最后写入的块缓存在内存中，追加写入时不需要从磁盘读取
+// 读取、截断等操作使用普通的文件描述符，O_DIRECT 写入时内核会使页缓存中对应的数据失效
+type DirectIO struct {
+	*FileIO
+	direct   *os.File // 以 O_DIRECT 打开的文件描述符，只用于写入
+	size     int64    // 文件的逻辑长度，不包括对齐时在末尾补齐的 0
+	written  int64    // 可能写入过数据的长度，之后的空间全为 0，补齐时不需要读取
+	block    []byte   // 最后写入的块
+	blockOff int64    // 最后写入的块在文件中的位置，没有缓存时为 -1
+	scratch  []byte   // 写入时复用的对齐的缓冲区
+}
+
+// NewDirectIOManager 以 O_DIRECT 打开文件，文件系统不支持 O_DIRECT 时返回错误
+func NewDirectIOManager(fileName string) (IOManager, error) {
+	fileIO, err := newFileIO(fileName, 0, true)
+	if err != nil {
+		return nil, err
+	}
+	direct, err := os.OpenFile(fileName, os.O_RDWR|syscall.O_DIRECT, DataFilePerm)
+	if err != nil {
+		_ = fileIO.Close()
+		return nil, err
+	}
+	size, err := fileIO.Size()
+	if err != nil {
+		_ = fileIO.Close()
+		_ = direct.Close()
+		return nil, err
+	}
+	return &DirectIO{
+		FileIO:   fileIO,
+		direct:   direct,
+		size:     size,
+		written:  size,
+		block:    alignedBlock(directIOBlockSize),
+		blockOff: -1,
+	}, nil
+}
+
+// 分配起始地址按块对齐的缓冲区
+func alignedBlock(n int) []byte {
+	buf := make([]byte, n+directIOBlockSize)
+	off := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) & (directIOBlockSize - 1)); rem != 0 {
+		off = directIOBlockSize - rem
+	}
+	return buf[off : off+n]
+}
+
+// 复用对齐的缓冲区，返回的缓冲区全为 0
+func (d *DirectIO) alignedBuf(n int) []byte {
+	if cap(d.scratch) < n {
+		d.scratch = alignedBlock(n)
+	}
+	buf := d.scratch[:n]
+	for i := range buf {
+		buf[i] = 0
+	}
+	return buf
+}
+
+// Write 写入字节数组到文件末尾
+func (d *DirectIO) Write(b []byte) (int, error) {
+	return d.WriteAt(b, d.size)
+}
+
+// WriteAt 将 b 所在的块补齐之后整块写入
+func (d *DirectIO) WriteAt(b []byte, offset int64) (int, error) {
+	start := offset &^ (directIOBlockSize - 1)
+	end := offset + int64(len(b))
+	alignedEnd := (end + directIOBlockSize - 1) &^ (directIOBlockSize - 1)
+	lastOff := alignedEnd - directIOBlockSize
+	buf := d.alignedBuf(int(alignedEnd - start))
+
+	// 第一个块中 offset 之前以及最后一个块中 end 之后的数据需要保持不变
+	if offset > start {
+		if err := d.readBlock(buf[:directIOBlockSize], start); err != nil {
+			return 0, err
+		}
+	}
+	if end < alignedEnd && (lastOff > start || offset == start) {
+		if err := d.readBlock(buf[lastOff-start:], lastOff); err != nil {
+			return 0, err
+		}
+	}
+	copy(buf[offset-start:], b)
+	if _, err := d.direct.WriteAt(buf, start); err != nil {
+		d.blockOff = -1
+		return 0, err
+	}
+
+	copy(d.block, buf[lastOff-start:])
+	d.blockOff = lastOff
+	if end > d.size {
+		d.size = end
+	}
+	if end > d.written {
+		d.written = end
+	}
+	return len(b), nil
+}
+
+// 读取 offset 处的一个块，优先使用缓存的最后写入的块
+func (d *DirectIO) readBlock(buf []byte, offset int64) error {
+	if offset == d.blockOff {
+		copy(buf, d.block)
+		return nil
+	}
+	if offset >= d.written {
+		return nil
+	}
+	n := d.written - offset
+	if n > int64(len(buf)) {
+		n = int64(len(buf))
+	}
+	_, err := d.FileIO.Read(buf[:n], offset)
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+// Size 文件的逻辑长度
+func (d *DirectIO) Size() (int64, error) {
+	return d.size, nil
+}
+
+// Truncate 将文件截断到指定的大小，丢弃缓存的块
+func (d *DirectIO) Truncate(size int64) error {
+	if err := d.FileIO.Truncate(size); err != nil {
+		return err
+	}
+	d.size = size
+	if d.written > size {
+		d.written = size
+	}
+	d.blockOff = -1
+	return nil
+}
+
+// Preallocate 预先分配 size 大小的磁盘空间，预先分配的空间属于文件的逻辑长度
+func (d *DirectIO) Preallocate(size int64) error {
+	if err := d.FileIO.Preallocate(size); err != nil {
+		return err
+	}
+	if size > d.size {
+		d.size = size
+	}
+	return nil
+}
+
+// Close 截断对齐时在末尾补齐的 0，然后关闭文件
+func (d *DirectIO) Close() error {
+	physical, err := d.FileIO.Size()
+	if err == nil && physical > d.size {
+		err = d.FileIO.Truncate(d.size)
+	}
+	if closeErr := d.direct.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := d.FileIO.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package fio
+
+// NewDirectIOManager 当前平台不支持 O_DIRECT，使用 fdatasync 持久化的标准文件 IO
+func NewDirectIOManager(fileName string) (IOManager, error) {
+	return NewDataSyncIOManager(fileName)
+}
@@ -0,0 +1,112 @@
+package fio
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testIOTypes = map[string]FileIOType{
+	"standard": StandardFIO,
+	"datasync": DataSyncFIO,
+	"dsync":    DSyncFIO,
+	"direct":   DirectFIO,
+}
+
+// 打开指定类型的文件，文件系统不支持 O_DIRECT 时跳过测试
+func openTestIOManager(tb testing.TB, fileName string, ioType FileIOType) IOManager {
+	ioManager, err := NewIOManager(fileName, ioType)
+	if err != nil && ioType == DirectFIO {
+		tb.Skipf("direct io is not supported: %v", err)
+	}
+	assert.Nil(tb, err)
+	return ioManager
+}
+
+func TestIOManager_WriteAt(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-io-type")
+	defer os.RemoveAll(dir)
+
+	for name, ioType := range testIOTypes {
+		t.Run(name, func(t *testing.T) {
+			fileName := filepath.Join(dir, name+".data")
+			ioManager := openTestIOManager(t, fileName, ioType)
+
+			// 追加写入不对齐的数据，并覆盖中间的部分
+			var expected []byte
+			for i := 0; i < 200; i++ {
+				buf := bytes.Repeat([]byte{byte(i + 1)}, rand.Intn(100)+1)
+				n, err := ioManager.Write(buf)
+				assert.Nil(t, err)
+				assert.Equal(t, len(buf), n)
+				expected = append(expected, buf...)
+			}
+			_, err := ioManager.WriteAt([]byte("overwrite"), 5000)
+			assert.Nil(t, err)
+			copy(expected[5000:], "overwrite")
+			assert.Nil(t, ioManager.Sync())
+
+			size, err := ioManager.Size()
+			assert.Nil(t, err)
+			assert.Equal(t, int64(len(expected)), size)
+			buf := make([]byte, len(expected))
+			_, err = ioManager.Read(buf, 0)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, buf)
+
+			// 截断之后继续写入
+			assert.Nil(t, ioManager.Truncate(4100))
+			expected = expected[:4100]
+			_, err = ioManager.Write([]byte("after truncate"))
+			assert.Nil(t, err)
+			expected = append(expected, "after truncate"...)
+			assert.Nil(t, ioManager.Close())
+
+			// 关闭之后文件中只有写入的数据
+			content, err := os.ReadFile(fileName)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, content)
+
+			ioManager = openTestIOManager(t, fileName, ioType)
+			assert.Nil(t, ioManager.Preallocate(64*1024))
+			_, err = ioManager.WriteAt([]byte("reopen"), int64(len(expected)))
+			assert.Nil(t, err)
+			expected = append(expected, "reopen"...)
+			buf = make([]byte, len(expected))
+			_, err = ioManager.Read(buf, 0)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, buf)
+			assert.Nil(t, ioManager.Close())
+		})
+	}
+}
+
+func BenchmarkIOManager_AppendSync(b *testing.B) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-io-bench")
+	defer os.RemoveAll(dir)
+
+	record := bytes.Repeat([]byte("a"), 128)
+	for _, name := range []string{"standard", "datasync", "dsync", "direct"} {
+		b.Run(name, func(b *testing.B) {
+			ioManager := openTestIOManager(b, filepath.Join(dir, name+".data"), testIOTypes[name])
+			defer ioManager.Close()
+			assert.Nil(b, ioManager.Preallocate(int64(b.N*len(record))))
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			var offset int64
+			for i := 0; i < b.N; i++ {
+				if _, err := ioManager.WriteAt(record, offset); err != nil {
+					b.Fatal(err)
+				}
+				if err := ioManager.Sync(); err != nil {
+					b.Fatal(err)
+				}
+				offset += int64(len(record))
+			}
+		})
+	}
+}
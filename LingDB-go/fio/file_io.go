@@ -1,22 +1,40 @@
 package fio
 
-import "os"
+import (
+	"io"
+	"os"
+)
 
 // FileIO 标准系统文件，IO；这里是io_manager的一种实现，其他还有如MMap等多种实现
 type FileIO struct {
-	fd *os.File //系统文件描述符
+	fd       *os.File //系统文件描述符
+	dataSync bool     //持久化时是否使用 fdatasync，只同步读取数据必需的元数据
 }
 
 func NewFileIOManager(fileName string) (*FileIO, error) {
+	return newFileIO(fileName, 0, false)
+}
+
+// NewDataSyncIOManager 持久化时使用 fdatasync 的标准文件 IO，不会同步修改时间等元数据
+func NewDataSyncIOManager(fileName string) (*FileIO, error) {
+	return newFileIO(fileName, 0, true)
+}
+
+// NewDSyncIOManager 以 O_DSYNC 打开的文件，每次写入返回时数据都已经持久化
+func NewDSyncIOManager(fileName string) (*FileIO, error) {
+	return newFileIO(fileName, oDSync, true)
+}
+
+func newFileIO(fileName string, flag int, dataSync bool) (*FileIO, error) {
 	file, err := os.OpenFile(
 		fileName,
-		os.O_CREATE|os.O_RDWR|os.O_APPEND,
+		os.O_CREATE|os.O_RDWR|flag,
 		DataFilePerm,
 	)
 	if err != nil {
 		return nil, err
 	}
-	return &FileIO{fd: file}, nil
+	return &FileIO{fd: file, dataSync: dataSync}, nil
 }
 
 // Read 从文件的给定位置读取对应的数据z
@@ -24,13 +42,24 @@ func (fio *FileIO) Read(b []byte, offset int64) (int, error) {
 	return fio.fd.ReadAt(b, offset)
 }
 
-// Write 写入字节数组到文件中
+// Write 写入字节数组到文件末尾
 func (fio *FileIO) Write(b []byte) (int, error) {
+	if _, err := fio.fd.Seek(0, io.SeekEnd); err != nil {
+		return 0, err
+	}
 	return fio.fd.Write(b)
 }
 
+// WriteAt 写入字节数组到文件的给定位置
+func (fio *FileIO) WriteAt(b []byte, offset int64) (int, error) {
+	return fio.fd.WriteAt(b, offset)
+}
+
 // Sync 持久化数据
 func (fio *FileIO) Sync() error {
+	if fio.dataSync {
+		return fdatasync(fio.fd)
+	}
 	return fio.fd.Sync()
 }
 
@@ -47,3 +76,8 @@ func (fio *FileIO) Size() (int64, error) {
 	}
 	return stat.Size(), nil
 }
+
+// Truncate 将文件截断到指定的大小
+func (fio *FileIO) Truncate(size int64) error {
+	return fio.fd.Truncate(size)
+}
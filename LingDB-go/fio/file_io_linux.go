@@ -0,0 +1,30 @@
+//go:build linux
+
+package fio
+
+import (
+	"os"
+	"syscall"
+)
+
+// 每次写入都等待数据持久化，不等待修改时间等元数据
+const oDSync = syscall.O_DSYNC
+
+// Preallocate 使用 fallocate 预先分配磁盘空间，文件长度不足 size 时扩展到 size，扩展的部分全为 0
+func (fio *FileIO) Preallocate(size int64) error {
+	fileSize, err := fio.Size()
+	if err != nil || fileSize >= size {
+		return err
+	}
+	err = syscall.Fallocate(int(fio.fd.Fd()), 0, 0, size)
+	if err == syscall.EOPNOTSUPP || err == syscall.ENOSYS {
+		// 文件系统不支持 fallocate 时直接扩展文件的长度
+		return fio.fd.Truncate(size)
+	}
+	return err
+}
+
+// 只持久化数据以及读取数据必需的元数据，比 fsync 少一次元数据的写入
+func fdatasync(f *os.File) error {
+	return syscall.Fdatasync(int(f.Fd()))
+}
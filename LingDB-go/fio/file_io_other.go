@@ -0,0 +1,18 @@
+//go:build !linux
+
+package fio
+
+import "os"
+
+// 不支持 O_DSYNC 的平台上使用 O_SYNC
+const oDSync = os.O_SYNC
+
+// Preallocate 当前平台不支持预先分配磁盘空间，不做任何处理
+func (fio *FileIO) Preallocate(size int64) error {
+	return nil
+}
+
+// 不支持 fdatasync 的平台上使用 fsync
+func fdatasync(f *os.File) error {
+	return f.Sync()
+}
@@ -14,7 +14,7 @@ func destroyFile(name string) {
 }
 
 func TestNewFileIOManager(t *testing.T) {
-	path := filepath.Join("C:\\go-pj\\LingDB\\db_data", "0001.data")
+	path := filepath.Join(os.TempDir(), "0001.data")
 	fio, err := NewFileIOManager(path)
 	defer destroyFile(path)
 
@@ -26,7 +26,7 @@ func TestNewFileIOManager(t *testing.T) {
 }
 
 func TestFileIO_Write(t *testing.T) {
-	path := filepath.Join("C:\\go-pj\\LingDB\\db_data", "a.data")
+	path := filepath.Join(os.TempDir(), "a.data")
 	fio, err := NewFileIOManager(path)
 	defer destroyFile(path)
 	assert.Nil(t, err)
@@ -51,7 +51,7 @@ func TestFileIO_Write(t *testing.T) {
 }
 
 func TestFileIO_Read(t *testing.T) {
-	path := filepath.Join("C:\\go-pj\\LingDB\\db_data", "a.data")
+	path := filepath.Join(os.TempDir(), "a.data")
 	fio, err := NewFileIOManager(path)
 	//从0开始读取到b中
 	defer destroyFile(path)
@@ -78,7 +78,7 @@ func TestFileIO_Read(t *testing.T) {
 }
 
 func TestFileIO_Sync(t *testing.T) {
-	path := filepath.Join("C:\\go-pj\\LingDB\\db_data", "a.data")
+	path := filepath.Join(os.TempDir(), "a.data")
 	fio, err := NewFileIOManager(path)
 	defer destroyFile(path)
 	assert.Nil(t, err)
@@ -92,7 +92,7 @@ func TestFileIO_Sync(t *testing.T) {
 }
 
 func TestFileIO_Close(t *testing.T) {
-	path := filepath.Join("C:\\go-pj\\LingDB\\db_data", "a.data")
+	path := filepath.Join(os.TempDir(), "a.data")
 	fio, err := NewFileIOManager(path)
 	defer destroyFile(path)
 	assert.Nil(t, err)
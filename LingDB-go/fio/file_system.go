@@ -0,0 +1,60 @@
+package fio
+
+import "os"
+
+// FileSystem 文件系统，数据目录中的文件以及目录都通过文件系统访问，方法的语义和 os 包中的同名函数一致
+type FileSystem interface {
+	// OpenFile 打开文件，文件不存在时创建，ioType 为打开文件使用的 IO 类型
+	OpenFile(fileName string, ioType FileIOType) (IOManager, error)
+
+	// Stat 获取文件或者目录的信息，不存在时返回的错误满足 os.IsNotExist
+	Stat(name string) (os.FileInfo, error)
+
+	// ReadDir 读取目录中的所有文件以及子目录，按照名称排序
+	ReadDir(dirPath string) ([]os.DirEntry, error)
+
+	// MkdirAll 创建目录以及不存在的父目录
+	MkdirAll(dirPath string) error
+
+	// Remove 删除文件或者空目录
+	Remove(name string) error
+
+	// RemoveAll 删除文件或者目录以及其中的所有内容，不存在时不返回错误
+	RemoveAll(name string) error
+
+	// Rename 重命名文件或者目录，目标文件已经存在时将其替换
+	Rename(oldName, newName string) error
+}
+
+// OS 操作系统的文件系统
+var OS FileSystem = osFileSystem{}
+
+type osFileSystem struct{}
+
+func (osFileSystem) OpenFile(fileName string, ioType FileIOType) (IOManager, error) {
+	return NewIOManager(fileName, ioType)
+}
+
+func (osFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFileSystem) ReadDir(dirPath string) ([]os.DirEntry, error) {
+	return os.ReadDir(dirPath)
+}
+
+func (osFileSystem) MkdirAll(dirPath string) error {
+	return os.MkdirAll(dirPath, os.ModePerm)
+}
+
+func (osFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFileSystem) RemoveAll(name string) error {
+	return os.RemoveAll(name)
+}
+
+func (osFileSystem) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
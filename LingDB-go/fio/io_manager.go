@@ -8,9 +8,12 @@ type IOManager interface {
 	//Read 从文件的给定位置读取对应的数据
 	Read([]byte, int64) (int, error)
 
-	//Write 写入字节数组到文件中
+	//Write 写入字节数组到文件末尾
 	Write([]byte) (int, error)
 
+	// WriteAt 写入字节数组到文件的给定位置
+	WriteAt([]byte, int64) (int, error)
+
 	//Sync 持久化数据
 	Sync() error
 
@@ -19,10 +22,38 @@ type IOManager interface {
 
 	// Size 获取文件大小
 	Size() (int64, error)
+
+	// Truncate 将文件截断到指定的大小
+	Truncate(size int64) error
+
+	// Preallocate 预先分配 size 大小的磁盘空间，不改变已有的数据，不支持的平台上不做任何处理
+	Preallocate(size int64) error
 }
 
-// NewIOManager 初始化IOManager，目前只支持FileIO
-func NewIOManager(fileName string) (IOManager, error) {
-	//目前方法直接写死获取文件id，未来拓展io渠道时会新增枚举->对应io管理器
-	return NewFileIOManager(fileName)
+// FileIOType 文件 IO 的类型
+type FileIOType = int8
+
+const (
+	// StandardFIO 标准文件 IO，写入页缓存，使用 fsync 持久化
+	StandardFIO FileIOType = iota
+	// DataSyncFIO 标准文件 IO，使用 fdatasync 持久化
+	DataSyncFIO
+	// DSyncFIO 以 O_DSYNC 打开文件，每次写入都会等待数据持久化
+	DSyncFIO
+	// DirectFIO 以 O_DIRECT 打开文件，绕过页缓存写入，使用 fdatasync 持久化
+	DirectFIO
+)
+
+// NewIOManager 按照 IO 类型初始化IOManager
+func NewIOManager(fileName string, ioType FileIOType) (IOManager, error) {
+	switch ioType {
+	case DataSyncFIO:
+		return NewDataSyncIOManager(fileName)
+	case DSyncFIO:
+		return NewDSyncIOManager(fileName)
+	case DirectFIO:
+		return NewDirectIOManager(fileName)
+	default:
+		return NewFileIOManager(fileName)
+	}
 }
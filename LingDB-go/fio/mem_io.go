@@ -0,0 +1,311 @@
+package fio
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MemIO 内存中的文件，同一个文件的多个 MemIO 共享数据，持久化不做任何处理
+type MemIO struct {
+	file *memFile
+}
+
+// 内存中一个文件的数据
+type memFile struct {
+	mu      *sync.RWMutex
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemIOManager 创建一个不属于任何文件系统的内存文件
+func NewMemIOManager() *MemIO {
+	return &MemIO{file: newMemFile()}
+}
+
+func newMemFile() *memFile {
+	return &memFile{mu: new(sync.RWMutex), modTime: time.Now()}
+}
+
+// Read 从文件的给定位置读取对应的数据，读取到文件末尾时返回 io.EOF
+func (m *MemIO) Read(b []byte, offset int64) (int, error) {
+	m.file.mu.RLock()
+	defer m.file.mu.RUnlock()
+	var n int
+	if offset < int64(len(m.file.data)) {
+		n = copy(b, m.file.data[offset:])
+	}
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Write 写入字节数组到文件末尾
+func (m *MemIO) Write(b []byte) (int, error) {
+	m.file.mu.Lock()
+	defer m.file.mu.Unlock()
+	return m.file.writeAt(b, int64(len(m.file.data))), nil
+}
+
+// WriteAt 写入字节数组到文件的给定位置，超出文件末尾时扩展文件
+func (m *MemIO) WriteAt(b []byte, offset int64) (int, error) {
+	m.file.mu.Lock()
+	defer m.file.mu.Unlock()
+	return m.file.writeAt(b, offset), nil
+}
+
+// 写入数据，调用方需要持有文件的锁，数据会被复制，调用方可以复用 b
+func (f *memFile) writeAt(b []byte, offset int64) int {
+	if end := offset + int64(len(b)); end > int64(len(f.data)) {
+		f.resize(end)
+	}
+	f.modTime = time.Now()
+	return copy(f.data[offset:], b)
+}
+
+// 调整文件的长度，扩展的部分全为 0
+func (f *memFile) resize(size int64) {
+	if size <= int64(cap(f.data)) {
+		old := len(f.data)
+		f.data = f.data[:size]
+		for i := old; i < len(f.data); i++ {
+			f.data[i] = 0
+		}
+		return
+	}
+	data := make([]byte, size, size+size/4)
+	copy(data, f.data)
+	f.data = data
+}
+
+// Sync 内存中的文件不需要持久化
+func (m *MemIO) Sync() error {
+	return nil
+}
+
+// Close 内存中的文件关闭之后数据仍然保留在文件系统中
+func (m *MemIO) Close() error {
+	return nil
+}
+
+// Size 获取文件大小
+func (m *MemIO) Size() (int64, error) {
+	m.file.mu.RLock()
+	defer m.file.mu.RUnlock()
+	return int64(len(m.file.data)), nil
+}
+
+// Truncate 将文件截断到指定的大小
+func (m *MemIO) Truncate(size int64) error {
+	m.file.mu.Lock()
+	defer m.file.mu.Unlock()
+	m.file.resize(size)
+	m.file.modTime = time.Now()
+	return nil
+}
+
+// Preallocate 内存中的文件不需要预先分配空间
+func (m *MemIO) Preallocate(size int64) error {
+	return nil
+}
+
+// MemFileSystem 内存中的文件系统，文件关闭之后数据仍然保留，直到被删除
+type MemFileSystem struct {
+	mu    *sync.RWMutex
+	files map[string]*memFile // 文件路径到文件的映射
+	dirs  map[string]struct{} // 所有的目录
+}
+
+// NewMemFileSystem 创建一个空的内存文件系统
+func NewMemFileSystem() *MemFileSystem {
+	return &MemFileSystem{
+		mu:    new(sync.RWMutex),
+		files: make(map[string]*memFile),
+		dirs:  make(map[string]struct{}),
+	}
+}
+
+// OpenFile 打开文件，文件不存在时创建，内存中的文件忽略 IO 类型
+func (m *MemFileSystem) OpenFile(fileName string, ioType FileIOType) (IOManager, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fileName = filepath.Clean(fileName)
+	if _, ok := m.dirs[filepath.Dir(fileName)]; !ok {
+		return nil, &fs.PathError{Op: "open", Path: fileName, Err: fs.ErrNotExist}
+	}
+	if _, ok := m.dirs[fileName]; ok {
+		return nil, &fs.PathError{Op: "open", Path: fileName, Err: syscall.EISDIR}
+	}
+	file, ok := m.files[fileName]
+	if !ok {
+		file = newMemFile()
+		m.files[fileName] = file
+	}
+	return &MemIO{file: file}, nil
+}
+
+// Stat 获取文件或者目录的信息
+func (m *MemFileSystem) Stat(name string) (os.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	name = filepath.Clean(name)
+	if info, ok := m.stat(name); ok {
+		return info, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *MemFileSystem) stat(name string) (*memFileInfo, bool) {
+	if file, ok := m.files[name]; ok {
+		file.mu.RLock()
+		defer file.mu.RUnlock()
+		return &memFileInfo{name: filepath.Base(name), size: int64(len(file.data)), modTime: file.modTime}, true
+	}
+	if _, ok := m.dirs[name]; ok {
+		return &memFileInfo{name: filepath.Base(name), dir: true}, true
+	}
+	return nil, false
+}
+
+// ReadDir 读取目录中的所有文件以及子目录，按照名称排序
+func (m *MemFileSystem) ReadDir(dirPath string) ([]os.DirEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	dirPath = filepath.Clean(dirPath)
+	if _, ok := m.dirs[dirPath]; !ok {
+		return nil, &fs.PathError{Op: "open", Path: dirPath, Err: fs.ErrNotExist}
+	}
+	var entries []os.DirEntry
+	for name := range m.files {
+		if filepath.Dir(name) == dirPath {
+			info, _ := m.stat(name)
+			entries = append(entries, fs.FileInfoToDirEntry(info))
+		}
+	}
+	for name := range m.dirs {
+		if name != dirPath && filepath.Dir(name) == dirPath {
+			info, _ := m.stat(name)
+			entries = append(entries, fs.FileInfoToDirEntry(info))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// MkdirAll 创建目录以及不存在的父目录
+func (m *MemFileSystem) MkdirAll(dirPath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for dir := filepath.Clean(dirPath); ; dir = filepath.Dir(dir) {
+		if _, ok := m.files[dir]; ok {
+			return &fs.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+		}
+		m.dirs[dir] = struct{}{}
+		if parent := filepath.Dir(dir); parent == dir {
+			return nil
+		}
+	}
+}
+
+// Remove 删除文件或者空目录
+func (m *MemFileSystem) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = filepath.Clean(name)
+	if _, ok := m.files[name]; ok {
+		delete(m.files, name)
+		return nil
+	}
+	if _, ok := m.dirs[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	for _, child := range m.children(name) {
+		if child != name {
+			return &fs.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+		}
+	}
+	delete(m.dirs, name)
+	return nil
+}
+
+// RemoveAll 删除文件或者目录以及其中的所有内容
+func (m *MemFileSystem) RemoveAll(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, child := range m.children(filepath.Clean(name)) {
+		delete(m.files, child)
+		delete(m.dirs, child)
+	}
+	return nil
+}
+
+// Rename 重命名文件或者目录，目标文件已经存在时将其替换
+func (m *MemFileSystem) Rename(oldName, newName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oldName, newName = filepath.Clean(oldName), filepath.Clean(newName)
+	if _, ok := m.stat(oldName); !ok {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: fs.ErrNotExist}
+	}
+	if _, ok := m.dirs[filepath.Dir(newName)]; !ok {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: fs.ErrNotExist}
+	}
+	for _, child := range m.children(oldName) {
+		moved := newName + strings.TrimPrefix(child, oldName)
+		if file, ok := m.files[child]; ok {
+			delete(m.files, child)
+			m.files[moved] = file
+		} else {
+			delete(m.dirs, child)
+			m.dirs[moved] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// name 本身以及目录 name 中的所有文件和子目录
+func (m *MemFileSystem) children(name string) []string {
+	prefix := name + string(filepath.Separator)
+	var names []string
+	for child := range m.dirs {
+		if child == name || strings.HasPrefix(child, prefix) {
+			names = append(names, child)
+		}
+	}
+	for child := range m.files {
+		if child == name || strings.HasPrefix(child, prefix) {
+			names = append(names, child)
+		}
+	}
+	return names
+}
+
+// 内存中文件或者目录的信息
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *memFileInfo) IsDir() bool        { return fi.dir }
+func (fi *memFileInfo) Sys() interface{}   { return nil }
+
+func (fi *memFileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | os.ModePerm
+	}
+	return DataFilePerm
+}
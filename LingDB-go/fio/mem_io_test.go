@@ -0,0 +1,112 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemIO_ReadWrite(t *testing.T) {
+	memIO := NewMemIOManager()
+
+	n, err := memIO.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	_, err = memIO.Write([]byte("key-b"))
+	assert.Nil(t, err)
+
+	b := make([]byte, 5)
+	n, err = memIO.Read(b, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, []byte("key-b"), b)
+
+	// 读取到文件末尾，空的读取和 os.File 一样不返回错误
+	n, err = memIO.Read(nil, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	n, err = memIO.Read(b, 8)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 2, n)
+
+	// 超出文件末尾的写入使用 0 填充中间的部分
+	_, err = memIO.WriteAt([]byte("end"), 12)
+	assert.Nil(t, err)
+	size, err := memIO.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(15), size)
+	n, err = memIO.Read(b, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0, 0, 'e', 'n', 'd'}, b)
+
+	// 截断之后再扩展，扩展的部分不能残留截断之前的数据
+	assert.Nil(t, memIO.Truncate(5))
+	assert.Nil(t, memIO.Truncate(10))
+	n, err = memIO.Read(b, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, make([]byte, 5), b)
+
+	assert.Nil(t, memIO.Sync())
+	assert.Nil(t, memIO.Close())
+}
+
+func TestMemFileSystem(t *testing.T) {
+	memFS := NewMemFileSystem()
+	dir := filepath.Join("db", "data")
+
+	// 目录不存在时不能创建文件
+	_, err := memFS.OpenFile(filepath.Join(dir, "0001.data"), StandardFIO)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Nil(t, memFS.MkdirAll(dir))
+	file, err := memFS.OpenFile(filepath.Join(dir, "0001.data"), DirectFIO)
+	assert.Nil(t, err)
+	_, err = file.Write([]byte("bitcask"))
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	// 关闭之后重新打开，数据仍然存在
+	file, err = memFS.OpenFile(filepath.Join(dir, "0001.data"), StandardFIO)
+	assert.Nil(t, err)
+	size, err := file.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), size)
+
+	info, err := memFS.Stat(filepath.Join(dir, "0001.data"))
+	assert.Nil(t, err)
+	assert.Equal(t, "0001.data", info.Name())
+	assert.Equal(t, int64(7), info.Size())
+	assert.False(t, info.IsDir())
+
+	_, err = memFS.OpenFile(filepath.Join(dir, "0002.data"), StandardFIO)
+	assert.Nil(t, err)
+	assert.Nil(t, memFS.MkdirAll(filepath.Join(dir, "sub")))
+	entries, err := memFS.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, "0001.data", entries[0].Name())
+	assert.Equal(t, "0002.data", entries[1].Name())
+	assert.Equal(t, "sub", entries[2].Name())
+	assert.True(t, entries[2].IsDir())
+
+	// 非空目录不能直接删除
+	assert.NotNil(t, memFS.Remove(dir))
+	assert.Nil(t, memFS.Remove(filepath.Join(dir, "0002.data")))
+	_, err = memFS.Stat(filepath.Join(dir, "0002.data"))
+	assert.True(t, os.IsNotExist(err))
+
+	// 重命名目录时其中的文件一起移动
+	assert.Nil(t, memFS.Rename(dir, filepath.Join("db", "moved")))
+	_, err = memFS.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+	info, err = memFS.Stat(filepath.Join("db", "moved", "0001.data"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), info.Size())
+
+	assert.Nil(t, memFS.RemoveAll("db"))
+	_, err = memFS.Stat(filepath.Join("db", "moved", "0001.data"))
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, memFS.RemoveAll("db"))
+}
@@ -0,0 +1,226 @@
+package LingDB_go
+
+import (
+	"LingDB/LingDB-go/data"
+	"LingDB/LingDB-go/fio"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+)
+
+var errInvalidDataHintFile = errors.New("the hint file of data file is invalid")
+
+// 启动时从数据文件或 hint 文件中解码出的一条记录，不包含 value
+type replayEntry struct {
+	key        []byte             // 编码了序列号的 key
+	typ        data.LogRecordType // 记录的类型
+	pos        *data.LogRecordPos // 记录在数据文件中的位置
+	bucketId   uint32             // 记录所属的 bucket
+	autoCommit bool               // 记录是否写入即提交
+}
+
+// 读取一个数据文件中从 startOffset 开始的所有记录，返回解码出的记录以及文件的有效长度
+// 封存的数据文件优先从其 hint 文件中读取，hint 文件缺失或者损坏时退化为扫描数据文件，并在后台重新生成 hint 文件
+func (db *DB) readReplayEntries(dataFile *data.DataFile, sealed bool, startOffset int64) ([]*replayEntry, int64, error) {
+	if sealed {
+		entries, size, err := readDataHintFile(db.options.DirPath, db.cipher, db.fs, dataFile)
+		if err == nil {
+			for len(entries) > 0 && entries[0].pos.Offset < startOffset {
+				entries = entries[1:]
+			}
+			return entries, size, nil
+		}
+	}
+
+	entries, size, err := scanDataFile(dataFile, startOffset)
+	if err != nil && (sealed || !isTornWrite(err)) {
+		return nil, 0, err
+	}
+	if !sealed {
+		// 活跃文件末尾可能残留崩溃时写了一半的数据，截断到最后一条完整记录的结束位置
+		if err := db.truncateTornWrite(dataFile, size, err); err != nil {
+			return nil, 0, err
+		}
+	}
+	if sealed && startOffset == 0 {
+		db.writeDataHintFileInBackground(dataFile.FileId, entries, size)
+	}
+	return entries, size, nil
+}
+
+// 读取活跃文件时遇到的错误是否可能由写了一半的记录导致
+func isTornWrite(err error) bool {
+	return err == data.ErrInvalidCRC || err == io.ErrUnexpectedEOF
+}
+
+// 将活跃文件截断到 size，文件长度不大于 size 时不做任何处理
+func (db *DB) truncateTornWrite(dataFile *data.DataFile, size int64, readErr error) error {
+	fileSize, err := dataFile.Size()
+	if err != nil {
+		return err
+	}
+	if fileSize <= size {
+		return nil
+	}
+	// 日志结束标识或者预先分配的空间不是写了一半的数据，直接截断，之后会重新预先分配
+	unwritten, err := dataFile.Unwritten(size)
+	if err != nil {
+		return err
+	}
+	if err := dataFile.Truncate(size); err != nil {
+		db.logger.Error("failed to truncate torn write", "fid", dataFile.FileId, "offset", size, "err", err)
+		return err
+	}
+	if unwritten {
+		return nil
+	}
+	db.logger.Warn("truncated torn write at the end of active file",
+		"fid", dataFile.FileId, "offset", size, "dropped", fileSize-size, "err", readErr)
+	db.events.tornWriteTruncated(TornWriteTruncatedInfo{
+		FileId:       dataFile.FileId,
+		Offset:       size,
+		DroppedBytes: fileSize - size,
+		Err:          readErr,
+	})
+	return nil
+}
+
+// 活跃文件封存后，在后台扫描该文件并生成 hint 文件
+func (db *DB) writeHintForSealedFile(dataFile *data.DataFile) {
+	db.hintWg.Add(1)
+	go func() {
+		defer db.hintWg.Done()
+		entries, size, err := scanDataFile(dataFile, 0)
+		if err == nil {
+			err = writeDataHintFile(db.options.DirPath, db.cipher, db.fs, dataFile.FileId, entries, size)
+		}
+		if err != nil {
+			db.logger.Warn("failed to write hint file", "fid", dataFile.FileId, "err", err)
+		}
+	}()
+}
+
+// 在后台将已经解码好的记录写入到 hint 文件中
+func (db *DB) writeDataHintFileInBackground(fileId uint32, entries []*replayEntry, size int64) {
+	db.hintWg.Add(1)
+	go func() {
+		defer db.hintWg.Done()
+		if err := writeDataHintFile(db.options.DirPath, db.cipher, db.fs, fileId, entries, size); err != nil {
+			db.logger.Warn("failed to write hint file", "fid", fileId, "err", err)
+		}
+	}()
+}
+
+// 从 offset 开始顺序扫描数据文件，解码出所有的记录
+// 遇到错误时仍然返回已经解码出的记录以及最后一条完整记录的结束位置
+func scanDataFile(dataFile *data.DataFile, offset int64) ([]*replayEntry, int64, error) {
+	var entries []*replayEntry
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			//如果是io问题，那么退出循环
+			if err == io.EOF {
+				break
+			}
+			return entries, offset, err
+		}
+		entries = append(entries, &replayEntry{
+			key:        logRecord.Key,
+			typ:        logRecord.Type,
+			pos:        &data.LogRecordPos{Fid: dataFile.FileId, Offset: offset},
+			bucketId:   logRecord.BucketId,
+			autoCommit: logRecord.AutoCommit,
+		})
+		offset += size
+	}
+	return entries, offset, nil
+}
+
+// 将数据文件中所有记录的 key、类型以及位置写入到 hint 文件中
+// 最后写入一条 key 为空的完成标识，记录对应数据文件的长度，没有完成标识或者长度不一致的 hint 文件视为无效
+// 数据文件中记录的 key 都带有事务序列号前缀，不会为空，因此不会和完成标识冲突
+func writeDataHintFile(dirPath string, c *data.Cipher, fs fio.FileSystem, fileId uint32, entries []*replayEntry, size int64) error {
+	fileName := data.GetDataHintFileName(dirPath, fileId)
+	if err := fs.Remove(fileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	hintFile, err := data.OpenDataHintFile(dirPath, fileId, c, fs)
+	if err != nil {
+		return err
+	}
+	defer hintFile.Close()
+
+	for _, entry := range entries {
+		encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+			Key:        entry.key,
+			Value:      data.EncodeLogRecordPos(entry.pos),
+			Type:       entry.typ,
+			BucketId:   entry.bucketId,
+			AutoCommit: entry.autoCommit,
+		})
+		if err := hintFile.Write(encRecord); err != nil {
+			return err
+		}
+	}
+
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Value: []byte(strconv.FormatInt(size, 10)),
+	})
+	if err := hintFile.Write(encRecord); err != nil {
+		return err
+	}
+	return hintFile.Sync()
+}
+
+// 从数据文件对应的 hint 文件中读取所有的记录
+func readDataHintFile(dirPath string, c *data.Cipher, fs fio.FileSystem, dataFile *data.DataFile) ([]*replayEntry, int64, error) {
+	fileName := data.GetDataHintFileName(dirPath, dataFile.FileId)
+	if _, err := fs.Stat(fileName); err != nil {
+		return nil, 0, err
+	}
+
+	hintFile, err := data.OpenDataHintFile(dirPath, dataFile.FileId, c, fs)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer hintFile.Close()
+
+	var entries []*replayEntry
+	var offset int64 = 0
+	for {
+		logRecord, size, err := hintFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				// 没有读取到完成标识
+				return nil, 0, errInvalidDataHintFile
+			}
+			return nil, 0, err
+		}
+		offset += size
+
+		if len(logRecord.Key) == 0 {
+			dataSize, err := strconv.ParseInt(string(logRecord.Value), 10, 64)
+			if err != nil {
+				return nil, 0, errInvalidDataHintFile
+			}
+			fileSize, err := dataFile.Size()
+			if err != nil {
+				return nil, 0, err
+			}
+			if dataSize != fileSize {
+				return nil, 0, errInvalidDataHintFile
+			}
+			return entries, dataSize, nil
+		}
+
+		entries = append(entries, &replayEntry{
+			key:        logRecord.Key,
+			typ:        logRecord.Type,
+			pos:        data.DecodeLogRecordPos(logRecord.Value),
+			bucketId:   logRecord.BucketId,
+			autoCommit: logRecord.AutoCommit,
+		})
+	}
+}
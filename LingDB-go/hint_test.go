@@ -0,0 +1,94 @@
+package LingDB_go
+
+import (
+	"LingDB/LingDB-go/data"
+	"LingDB/LingDB-go/fio"
+	"LingDB/LingDB-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_DataHintFile(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-hint")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 100; i < 300; i++ {
+		err := wb.Put(utils.GetTestKey(i), []byte("batch"))
+		assert.Nil(t, err)
+	}
+	err = wb.Commit()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	check := func(db *DB) {
+		assert.Equal(t, 900, len(db.ListKeys()))
+		for i := 0; i < 1000; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			if i < 100 {
+				assert.Equal(t, ErrKeyNotFound, err)
+			} else if i < 300 {
+				assert.Nil(t, err)
+				assert.Equal(t, []byte("batch"), val)
+			} else {
+				assert.Nil(t, err)
+			}
+		}
+	}
+
+	// 重启后从 hint 文件中加载索引
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	check(db2)
+
+	// 每个封存的数据文件都有对应的 hint 文件
+	assert.True(t, len(db2.olderFiles) > 0)
+	for fid, dataFile := range db2.olderFiles {
+		_, err := os.Stat(data.GetDataHintFileName(dir, fid))
+		assert.Nil(t, err)
+
+		hintEntries, hintSize, err := readDataHintFile(dir, nil, fio.OS, dataFile)
+		assert.Nil(t, err)
+		scanEntries, scanSize, err := scanDataFile(dataFile, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, scanSize, hintSize)
+		assert.Equal(t, scanEntries, hintEntries)
+	}
+
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	// hint 文件损坏或者缺失时退化为扫描数据文件
+	err = os.WriteFile(data.GetDataHintFileName(dir, 0), []byte("corrupted"), 0644)
+	assert.Nil(t, err)
+	err = os.Remove(data.GetDataHintFileName(dir, 1))
+	assert.Nil(t, err)
+
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	db = db3
+	check(db3)
+
+	// 退化扫描之后会在后台重新生成 hint 文件
+	db3.hintWg.Wait()
+	_, _, err = readDataHintFile(dir, nil, fio.OS, db3.olderFiles[0])
+	assert.Nil(t, err)
+	_, _, err = readDataHintFile(dir, nil, fio.OS, db3.olderFiles[1])
+	assert.Nil(t, err)
+}
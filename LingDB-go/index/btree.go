@@ -36,7 +36,9 @@ func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) bool {
 
 func (bt *BTree) Get(key []byte) *data.LogRecordPos {
 	item := &Item{key: key}
+	bt.lock.RLock()
 	btreeItem := bt.tree.Get(item)
+	bt.lock.RUnlock()
 	if btreeItem == nil {
 		return nil
 	}
@@ -55,9 +57,18 @@ func (bt *BTree) Delete(key []byte) bool {
 }
 
 func (bt *BTree) Size() int {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
 	return bt.tree.Len()
 }
 
+func (bt *BTree) Clone() Indexer {
+	//google的btree的Clone使用写时复制，只会标记共享的节点，但会修改原树，需要加写锁
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	return &BTree{tree: bt.tree.Clone(), lock: new(sync.RWMutex)}
+}
+
 func (bt *BTree) Iterator(reverse bool) Iterator {
 	if bt.tree == nil {
 		return nil
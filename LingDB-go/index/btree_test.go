@@ -68,6 +68,25 @@ func TestBTree_Delete(t *testing.T) {
 
 }
 
+func TestBTree_Clone(t *testing.T) {
+	bt := NewBTree()
+	bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	bt.Put([]byte("b"), &data.LogRecordPos{Fid: 1, Offset: 20})
+
+	clone := bt.Clone()
+	bt.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 30})
+	bt.Delete([]byte("b"))
+	bt.Put([]byte("c"), &data.LogRecordPos{Fid: 2, Offset: 40})
+
+	// 原索引的修改不影响复制出的索引
+	assert.Equal(t, 2, clone.Size())
+	assert.Equal(t, uint32(1), clone.Get([]byte("a")).Fid)
+	assert.NotNil(t, clone.Get([]byte("b")))
+	assert.Nil(t, clone.Get([]byte("c")))
+	assert.Equal(t, uint32(2), bt.Get([]byte("a")).Fid)
+	assert.Equal(t, 2, bt.Size())
+}
+
 func TestBTree_Iterator(t *testing.T) {
 	bt1 := NewBTree()
 	// 1.BTree 为空的情况
@@ -22,6 +22,9 @@ type Indexer interface {
 
 	// Iterator 索引迭代器
 	Iterator(reverse bool) Iterator
+
+	// Clone 复制当前的索引，之后对原索引的修改不会影响复制出的索引
+	Clone() Indexer
 }
 
 type IndexType = int8
@@ -1,8 +1,10 @@
 package LingDB_go
 
 import (
+	"LingDB/LingDB-go/data"
 	"LingDB/LingDB-go/index"
 	"bytes"
+	"context"
 )
 
 // Iterator 迭代器
@@ -10,70 +12,227 @@ type Iterator struct {
 	indexIter index.Iterator // 索引迭代器
 	db        *DB
 	options   IteratorOptions
+	exhausted bool // 是否已经越过了遍历范围的边界
+	ctx       context.Context
+	err       error // 遍历被取消的原因
+
+	prefetched  []*prefetchEntry // 预读模式下已经读取好的一批数据
+	prefetchIdx int              // 当前遍历到的预读数据下标
+}
+
+// 预读的单条数据
+type prefetchEntry struct {
+	key   []byte
+	value []byte
+	err   error
 }
 
 // NewIterator 初始化迭代器
 func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
-	indexIter := db.index.Iterator(opts.Reverse)
-	return &Iterator{
+	return db.NewIteratorContext(context.Background(), opts)
+}
+
+// NewIteratorContext 初始化迭代器，ctx 被取消后迭代器失效，可以通过 Err 获取取消的原因
+func (db *DB) NewIteratorContext(ctx context.Context, opts IteratorOptions) *Iterator {
+	return db.newIterator(ctx, db.defaultBucket, opts)
+}
+
+// 初始化遍历指定 bucket 的迭代器
+func (db *DB) newIterator(ctx context.Context, b *Bucket, opts IteratorOptions) *Iterator {
+	indexIter := b.index.Iterator(opts.Reverse)
+	it := &Iterator{
 		db:        db,
 		indexIter: indexIter,
 		options:   opts,
+		ctx:       ctx,
 	}
+	it.Rewind()
+	return it
 }
 
 // Rewind 重新回到迭代器的起点，即第一个数据
+// 如果指定了遍历范围，则起点为范围的起始边界
 func (it *Iterator) Rewind() {
+	it.exhausted = false
 	it.indexIter.Rewind()
+	if start := it.startBound(); start != nil {
+		it.indexIter.Seek(start)
+	}
 	it.skipToNext()
+	it.prefetch()
 }
 
 // Seek 根据传入的 key 查找到第一个大于（或小于）等于的目标 key，根据从这个 key 开始遍历
+// 如果 key 在遍历范围之外，则会被限制到范围的起始边界
 func (it *Iterator) Seek(key []byte) {
+	it.exhausted = false
+	if start := it.startBound(); start != nil && it.beforeStart(key) {
+		key = start
+	}
 	it.indexIter.Seek(key)
 	it.skipToNext()
+	it.prefetch()
 }
 
 // Next 跳转到下一个 key
 func (it *Iterator) Next() {
+	if it.prefetchEnabled() {
+		it.prefetchIdx++
+		if it.prefetchIdx >= len(it.prefetched) {
+			it.prefetch()
+		}
+		return
+	}
 	it.indexIter.Next()
 	it.skipToNext()
 }
 
 // Valid 是否有效，即是否已经遍历完了所有的 key，用于退出遍历
 func (it *Iterator) Valid() bool {
-	return it.indexIter.Valid()
+	if it.canceled() {
+		return false
+	}
+	if it.prefetchEnabled() {
+		return it.prefetchIdx < len(it.prefetched)
+	}
+	return !it.exhausted && it.indexIter.Valid()
 }
 
 // Key 当前遍历位置的 Key 数据
 func (it *Iterator) Key() []byte {
+	if it.prefetchEnabled() {
+		return it.prefetched[it.prefetchIdx].key
+	}
 	return it.indexIter.Key()
 }
 
 // Value 当前遍历位置的 Value 数据
 func (it *Iterator) Value() ([]byte, error) {
+	if it.options.KeyOnly {
+		return nil, ErrIteratorKeyOnly
+	}
+	if it.prefetchEnabled() {
+		entry := it.prefetched[it.prefetchIdx]
+		return entry.value, entry.err
+	}
 	logRecordPos := it.indexIter.Value()
 	it.db.mu.RLock()
 	defer it.db.mu.RUnlock()
 	return it.db.getValueByPosition(logRecordPos)
 }
 
+// Err 返回遍历被取消的原因，没有被取消时返回 nil
+func (it *Iterator) Err() error {
+	return it.err
+}
+
 // Close 关闭迭代器，释放相应资源
 func (it *Iterator) Close() {
+	it.prefetched = nil
 	it.indexIter.Close()
 }
 
-// 如果用户指定了key的前缀，那么需要跳到下一个key
+// ctx 是否已经被取消，被取消时记录取消的原因
+func (it *Iterator) canceled() bool {
+	if it.err != nil {
+		return true
+	}
+	it.err = it.ctx.Err()
+	return it.err != nil
+}
+
+// 是否开启了预读，只遍历 key 时没有必要预读
+func (it *Iterator) prefetchEnabled() bool {
+	return it.options.PrefetchSize > 0 && !it.options.KeyOnly
+}
+
+// 从索引迭代器中取出接下来的一批位置，按照 (Fid, Offset) 排序后并发批量读取 value
+func (it *Iterator) prefetch() {
+	if !it.prefetchEnabled() {
+		return
+	}
+	it.prefetched = it.prefetched[:0]
+	it.prefetchIdx = 0
+
+	var positions []*data.LogRecordPos
+	for len(it.prefetched) < it.options.PrefetchSize && !it.exhausted && it.indexIter.Valid() && !it.canceled() {
+		it.prefetched = append(it.prefetched, &prefetchEntry{key: it.indexIter.Key()})
+		positions = append(positions, it.indexIter.Value())
+		it.indexIter.Next()
+		it.skipToNext()
+	}
+	if len(positions) == 0 {
+		return
+	}
+
+	it.db.mu.RLock()
+	values, errs := it.db.getValuesByPositions(positions, it.options.PrefetchWorkers)
+	it.db.mu.RUnlock()
+	for i, entry := range it.prefetched {
+		entry.value, entry.err = values[i], errs[i]
+	}
+}
+
+// 跳过不满足前缀以及遍历范围的 key，一旦越过了范围的结束边界则立即停止遍历
 func (it *Iterator) skipToNext() {
 	prefixLen := len(it.options.Prefix)
-	if prefixLen == 0 {
+	if prefixLen == 0 && it.options.LowerBound == nil && it.options.UpperBound == nil {
 		return
 	}
 
-	for ; it.indexIter.Valid(); it.indexIter.Next() {
+	for ; it.indexIter.Valid() && !it.canceled(); it.indexIter.Next() {
 		key := it.indexIter.Key()
+		if it.pastEnd(key) {
+			it.exhausted = true
+			return
+		}
+		if it.beforeStart(key) {
+			continue
+		}
 		if prefixLen <= len(key) && bytes.Compare(it.options.Prefix, key[:prefixLen]) == 0 {
 			break
 		}
 	}
 }
+
+// 遍历的起始边界，正向遍历为下界，反向遍历为上界
+func (it *Iterator) startBound() []byte {
+	if it.options.Reverse {
+		return it.options.UpperBound
+	}
+	return it.options.LowerBound
+}
+
+// key 是否还没有到达遍历范围的起始边界
+func (it *Iterator) beforeStart(key []byte) bool {
+	if it.options.Reverse {
+		return it.aboveUpper(key)
+	}
+	return it.belowLower(key)
+}
+
+// key 是否已经越过了遍历范围的结束边界
+func (it *Iterator) pastEnd(key []byte) bool {
+	if it.options.Reverse {
+		return it.belowLower(key)
+	}
+	return it.aboveUpper(key)
+}
+
+// key 是否在下界之外
+func (it *Iterator) belowLower(key []byte) bool {
+	if it.options.LowerBound == nil {
+		return false
+	}
+	cmp := bytes.Compare(key, it.options.LowerBound)
+	return cmp < 0 || (cmp == 0 && it.options.LowerExclusive)
+}
+
+// key 是否在上界之外
+func (it *Iterator) aboveUpper(key []byte) bool {
+	if it.options.UpperBound == nil {
+		return false
+	}
+	cmp := bytes.Compare(key, it.options.UpperBound)
+	return cmp > 0 || (cmp == 0 && it.options.UpperExclusive)
+}
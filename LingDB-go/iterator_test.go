@@ -2,6 +2,7 @@ package LingDB_go
 
 import (
 	"LingDB/LingDB-go/utils"
+	"context"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
@@ -92,3 +93,171 @@ func TestDB_Iterator_Multi_Values(t *testing.T) {
 		assert.NotNil(t, iter3.Key())
 	}
 }
+
+func TestDB_Iterator_Bounds(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-iterator-4")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 10; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(10))
+		assert.Nil(t, err)
+	}
+
+	collect := func(iter *Iterator) [][]byte {
+		var keys [][]byte
+		for ; iter.Valid(); iter.Next() {
+			keys = append(keys, iter.Key())
+		}
+		return keys
+	}
+
+	// 闭区间正向遍历
+	iterOpts1 := DefaultIteratorOptions
+	iterOpts1.LowerBound = utils.GetTestKey(3)
+	iterOpts1.UpperBound = utils.GetTestKey(6)
+	iter1 := db.NewIterator(iterOpts1)
+	keys1 := collect(iter1)
+	assert.Equal(t, 4, len(keys1))
+	assert.Equal(t, utils.GetTestKey(3), keys1[0])
+	assert.Equal(t, utils.GetTestKey(6), keys1[3])
+
+	// 开区间正向遍历
+	iterOpts2 := iterOpts1
+	iterOpts2.LowerExclusive = true
+	iterOpts2.UpperExclusive = true
+	iter2 := db.NewIterator(iterOpts2)
+	keys2 := collect(iter2)
+	assert.Equal(t, 2, len(keys2))
+	assert.Equal(t, utils.GetTestKey(4), keys2[0])
+	assert.Equal(t, utils.GetTestKey(5), keys2[1])
+
+	// 反向遍历
+	iterOpts3 := iterOpts1
+	iterOpts3.Reverse = true
+	iterOpts3.UpperExclusive = true
+	iter3 := db.NewIterator(iterOpts3)
+	keys3 := collect(iter3)
+	assert.Equal(t, 3, len(keys3))
+	assert.Equal(t, utils.GetTestKey(5), keys3[0])
+	assert.Equal(t, utils.GetTestKey(3), keys3[2])
+
+	// Seek 会被限制在范围之内
+	iter1.Seek(utils.GetTestKey(0))
+	assert.True(t, iter1.Valid())
+	assert.Equal(t, utils.GetTestKey(3), iter1.Key())
+	iter1.Seek(utils.GetTestKey(8))
+	assert.False(t, iter1.Valid())
+	iter3.Seek(utils.GetTestKey(9))
+	assert.True(t, iter3.Valid())
+	assert.Equal(t, utils.GetTestKey(5), iter3.Key())
+
+	// 与前缀一起使用
+	iterOpts4 := DefaultIteratorOptions
+	iterOpts4.Prefix = []byte("bitcask-go-key-00000000")
+	iterOpts4.UpperBound = utils.GetTestKey(2)
+	iter4 := db.NewIterator(iterOpts4)
+	assert.Equal(t, 3, len(collect(iter4)))
+}
+
+func TestDB_Iterator_KeyOnly_Prefetch(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-iterator-5")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	values := make(map[string][]byte)
+	for i := 0; i < 1000; i++ {
+		val := utils.RandomValue(64)
+		err := db.Put(utils.GetTestKey(i), val)
+		assert.Nil(t, err)
+		values[string(utils.GetTestKey(i))] = val
+	}
+	assert.True(t, len(db.olderFiles) > 0)
+
+	// 只遍历 key
+	iterOpts1 := DefaultIteratorOptions
+	iterOpts1.KeyOnly = true
+	iter1 := db.NewIterator(iterOpts1)
+	var count int
+	for ; iter1.Valid(); iter1.Next() {
+		_, err := iter1.Value()
+		assert.Equal(t, ErrIteratorKeyOnly, err)
+		count++
+	}
+	assert.Equal(t, 1000, count)
+
+	// 预读 value，反向遍历并指定范围
+	iterOpts2 := DefaultIteratorOptions
+	iterOpts2.PrefetchSize = 64
+	iterOpts2.PrefetchWorkers = 4
+	iterOpts2.Reverse = true
+	iterOpts2.LowerBound = utils.GetTestKey(100)
+	iter2 := db.NewIterator(iterOpts2)
+	count = 0
+	for ; iter2.Valid(); iter2.Next() {
+		val, err := iter2.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, values[string(iter2.Key())], val)
+		count++
+	}
+	assert.Equal(t, 900, count)
+
+	iter2.Seek(utils.GetTestKey(150))
+	assert.True(t, iter2.Valid())
+	assert.Equal(t, utils.GetTestKey(150), iter2.Key())
+
+	// Fold 使用预读
+	count = 0
+	err = db.Fold(func(key []byte, value []byte) bool {
+		assert.Equal(t, values[string(key)], value)
+		count++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, count)
+}
+
+func TestDB_IteratorContext(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-iterator-6")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(10))
+		assert.Nil(t, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	iter := db.NewIteratorContext(ctx, DefaultIteratorOptions)
+	defer iter.Close()
+	var count int
+	for ; iter.Valid(); iter.Next() {
+		count++
+		if count == 10 {
+			cancel()
+		}
+	}
+	assert.Equal(t, 10, count)
+	assert.Equal(t, context.Canceled, iter.Err())
+
+	// 没有被取消的迭代器 Err 为 nil
+	iter2 := db.NewIteratorContext(context.Background(), DefaultIteratorOptions)
+	defer iter2.Close()
+	for ; iter2.Valid(); iter2.Next() {
+	}
+	assert.Nil(t, iter2.Err())
+}
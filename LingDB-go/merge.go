@@ -2,58 +2,109 @@ package LingDB_go
 
 import (
 	"LingDB/LingDB-go/data"
+	"LingDB/LingDB-go/index"
+	"context"
 	"io"
 	"os"
 	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"sync/atomic"
+	"time"
 )
 
 const (
-	mergeDirName     = "-merge"
-	mergeFinishedKey = "merge.finished"
+	mergeDirName         = "-merge"
+	mergeFinishedKey     = "merge.finished"
+	mergeSeqNoKey        = "merge.seqno"
+	mergeChangesFloorKey = "merge.changes.floor"
 )
 
+// mergeGeneration 记录在 merge 完成文件中的 merge 信息，副本据此判断本地的数据文件是否和主库一致
+type mergeGeneration struct {
+	nonMergeFileId uint32 // 没有参与 merge 的最小文件 id
+	seqNo          uint64 // merge 时的序列号
+	changesFloor   uint64 // 序列号不大于该值的变更历史已经被清理
+}
+
+// merge 时从待 merge 文件中读取的一条记录
+type mergeRecord struct {
+	record  *data.LogRecord
+	realKey []byte
+	seqNo   uint64
+	livePos *data.LogRecordPos // 记录仍然有效时为其在内存索引中的位置，否则为 nil
+}
+
 // Merge 清理无效数据，生成 Hint 文件
 // merge会将当前所有的数据文件重写到新的merge目录，但是merge完成后当前数据库实例无法改变
 // 只有merge完成后重启数据库，db才能使用新的索引和数据
 func (db *DB) Merge() error {
+	return db.MergeContext(context.Background())
+}
+
+// MergeContext 同 Merge，ctx 被取消时停止 merge，清理 merge 目录并返回 ctx.Err()
+// 被取消的 merge 不会影响现有的数据
+func (db *DB) MergeContext(ctx context.Context) error {
 	// 如果数据库为空，则直接返回
 	if db.activeFile == nil {
 		return nil
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// 副本的数据文件需要和主库保持一致，不能自行 merge
+	if db.isReplica() {
+		return ErrReadOnlyReplica
+	}
+	_, err := db.mergeTo(ctx, db.getMergePath(), false)
+	return err
+}
+
+// 将当前所有的旧数据文件 merge 到 mergePath 目录中，返回没有参与 merge 的第一个文件 id
+// snapshot 为 true 时 mergePath 中需要包含所有的数据，使用复制的索引判断记录是否有效
+func (db *DB) mergeTo(ctx context.Context, mergePath string, snapshot bool) (uint32, error) {
 	db.mu.Lock()
 	// 如果 merge 正在进行当中，则直接返回
 	if db.isMerging {
 		db.mu.Unlock()
-		return ErrMergeIsProgress
+		return 0, ErrMergeIsProgress
 	}
 	db.isMerging = true
 	defer func() {
+		db.mu.Lock()
 		db.isMerging = false
+		db.mu.Unlock()
 	}()
+	defer db.metrics.mergeDuration.observeSince(time.Now())
+	atomic.AddUint64(&db.metrics.merges, 1)
 
-	// 持久化当前活跃文件
-	if err := db.activeFile.Sync(); err != nil {
+	// 持久化当前活跃文件，将其转换为旧的数据文件，并打开新的活跃文件
+	if err := db.rotateActiveFile(); err != nil {
 		db.mu.Unlock()
-		return err
-	}
-	// 将当前活跃文件转换为旧的数据文件
-	db.olderFiles[db.activeFile.FileId] = db.activeFile
-	// 打开新的活跃文件
-	if err := db.setActiveDataFile(); err != nil {
-		db.mu.Unlock()
-		return nil
+		return 0, err
 	}
 	// 记录最近没有参与 merge 的文件 id
 	nonMergeFileId := db.activeFile.FileId
+	// 待 merge 文件中的记录序列号都不大于当前序列号，只保留最近 ChangeRetention 个序列号的变更历史
+	mergeSeqNo := db.seqNo
+	changesFloor := db.changesFloor
+	if mergeSeqNo > db.options.ChangeRetention && mergeSeqNo-db.options.ChangeRetention > changesFloor {
+		changesFloor = mergeSeqNo - db.options.ChangeRetention
+	}
 
 	// 取出所有需要 merge 的文件
 	var mergeFiles []*data.DataFile
 	for _, file := range db.olderFiles {
 		mergeFiles = append(mergeFiles, file)
 	}
+	// 之后创建的 bucket 不会有数据在待 merge 的文件中
+	indexes := db.bucketIndexes()
+	if snapshot {
+		// 快照期间被覆盖的 key 在内存索引中指向没有参与 merge 的文件，而快照中不包含这些文件
+		// 因此使用此时复制的索引，保留每个 key 在待 merge 文件中的位置
+		indexes = db.cloneBucketIndexes()
+	}
 	db.mu.Unlock()
 
 	// 将待 merge 的文件从小到大进行排序，依次 merge
@@ -61,17 +112,37 @@ func (db *DB) Merge() error {
 		return mergeFiles[i].FileId < mergeFiles[j].FileId
 	})
 
-	mergePath := db.getMergePath()
+	start := time.Now()
+	db.logger.Info("merge started", "nonMergeFileId", nonMergeFileId, "files", len(mergeFiles))
+	db.events.mergeBegin(MergeInfo{NonMergeFileId: nonMergeFileId})
+
+	err := db.writeMergeFiles(ctx, mergePath, mergeFiles, indexes, nonMergeFileId, mergeSeqNo, changesFloor)
+	if err != nil {
+		// 清理未完成的 merge 目录，重启时无需再处理
+		if rmErr := db.fs.RemoveAll(mergePath); rmErr != nil {
+			db.logger.Warn("failed to remove merge dir", "path", mergePath, "err", rmErr)
+		}
+		db.logger.Error("merge failed", "nonMergeFileId", nonMergeFileId, "err", err)
+	} else {
+		db.logger.Info("merge finished", "nonMergeFileId", nonMergeFileId, "duration", time.Since(start))
+	}
+	db.events.mergeEnd(MergeInfo{NonMergeFileId: nonMergeFileId, Duration: time.Since(start), Err: err})
+	return nonMergeFileId, err
+}
+
+// 将待 merge 文件中的有效数据以及序列号大于 changesFloor 的变更历史重写到 merge 目录中，并生成 hint 文件以及 merge 完成标识
+func (db *DB) writeMergeFiles(ctx context.Context, mergePath string, mergeFiles []*data.DataFile,
+	indexes map[uint32]index.Indexer, nonMergeFileId uint32, mergeSeqNo, changesFloor uint64) error {
 	// 如果目录存在，说明发生过 merge，将其删除掉
-	if _, err := os.Stat(mergePath); err == nil {
-		if err := os.RemoveAll(mergePath); err != nil {
+	if _, err := db.fs.Stat(mergePath); err == nil {
+		if err := db.fs.RemoveAll(mergePath); err != nil {
 			return err
 		}
 	}
 	// 新建一个 merge path 的目录，表示开始一个merge操作，当merge失败时该目录下没有表示完成的目标志文件
 	// 当重启数据库的时候，会检测merge目录下有无完成标识，如果没有，那么是失败的merge，全部整个删掉；如果有，那么替换文件，删除merge目录
 	// 如果merge完毕，那么所有的旧数据文件都可以通过hint索引文件进行加载，而再merge过程中的活跃文件不行
-	if err := os.MkdirAll(mergePath, os.ModePerm); err != nil {
+	if err := db.fs.MkdirAll(mergePath); err != nil {
 		return err
 	}
 
@@ -79,21 +150,40 @@ func (db *DB) Merge() error {
 	mergeOptions := db.options
 	mergeOptions.DirPath = mergePath
 	mergeOptions.SyncWrites = false
+	// merge 产生的文件都是封存的文件，不需要预先分配空间
+	mergeOptions.Preallocate = false
+	// merge 在后台批量写入，使用标准文件 IO
+	mergeOptions.IOType = StandardFIO
+	mergeOptions.CheckpointInterval = 0
+	mergeOptions.EventListener = EventListener{}
 	mergeDB, err := Open(mergeOptions)
 	if err != nil {
 		return err
 	}
+	// 中途失败时关闭临时实例，正常结束时会在下面显式关闭
+	defer func() {
+		if mergeDB != nil {
+			_ = mergeDB.Close()
+		}
+	}()
 
 	// 打开 hint 文件存储索引
-	hintFile, err := data.OpenHintFile(mergePath)
+	hintFile, err := data.OpenHintFile(mergePath, db.cipher, db.fs)
 	if err != nil {
 		return err
 	}
+	defer hintFile.Close()
+
+	// 事务中的记录在读到事务完成标识之后才能确定已经提交
+	transactionRecords := make(map[uint64][]*mergeRecord)
 
 	// 遍历处理每个数据文件
 	for _, dataFile := range mergeFiles {
 		var offset int64 = 0
 		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			logRecord, size, err := dataFile.ReadLogRecord(offset)
 			if err != nil {
 				if err == io.EOF {
@@ -101,24 +191,42 @@ func (db *DB) Merge() error {
 				}
 				return err
 			}
-			// 解析拿到实际的 key
-			realKey, _ := parseLogRecordKey(logRecord.Key)
-			logRecordPos := db.index.Get(realKey)
-			// 和内存中的索引位置进行比较，如果有效则重写
-			if logRecordPos != nil && logRecordPos.Fid == dataFile.FileId && logRecordPos.Offset == offset {
-				// 清除事务标记
-				logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
-				pos, err := mergeDB.appendLogRecord(logRecord)
-				if err != nil {
-					return err
+			// 增加 offset
+			recordOffset := offset
+			offset += size
+
+			// 解析拿到实际的 key 以及序列号
+			realKey, seqNo := parseLogRecordKey(logRecord.Key)
+			if logRecord.Type == data.LogRecordTxnFinished {
+				for _, r := range transactionRecords[seqNo] {
+					if err := db.rewriteMergeRecord(mergeDB, hintFile, r, changesFloor); err != nil {
+						return err
+					}
 				}
-				// 将当前位置索引写到 Hint 文件当中
-				if err := hintFile.WriteHintRecord(realKey, pos); err != nil {
+				delete(transactionRecords, seqNo)
+				continue
+			}
+
+			var logRecordPos *data.LogRecordPos
+			if idx, ok := indexes[logRecord.BucketId]; ok {
+				logRecordPos = idx.Get(realKey)
+			}
+			// merge 期间写入的合并操作数依赖之前的记录，找到链表中位于待 merge 文件中的最新位置
+			for logRecordPos != nil && logRecordPos.Fid >= nonMergeFileId {
+				logRecordPos = logRecordPos.Prev
+			}
+			// 和内存中的索引位置进行比较，判断记录是否有效
+			if logRecordPos == nil || logRecordPos.Fid != dataFile.FileId || logRecordPos.Offset != recordOffset {
+				logRecordPos = nil
+			}
+			r := &mergeRecord{record: logRecord, realKey: realKey, seqNo: seqNo, livePos: logRecordPos}
+			if seqNo == nonTransactionSeqNo || logRecord.AutoCommit {
+				if err := db.rewriteMergeRecord(mergeDB, hintFile, r, changesFloor); err != nil {
 					return err
 				}
+			} else {
+				transactionRecords[seqNo] = append(transactionRecords[seqNo], r)
 			}
-			// 增加 offset
-			offset += size
 		}
 	}
 	// sync 保证hint持久化
@@ -128,26 +236,93 @@ func (db *DB) Merge() error {
 	if err := mergeDB.Sync(); err != nil {
 		return err
 	}
+	// merge 产生的最后一个数据文件也需要构造布隆过滤器
+	if mergeDB.bloomEnabled() && mergeDB.activeFile != nil {
+		if err := mergeDB.sealBloomFilter(mergeDB.activeFile.FileId); err != nil {
+			return err
+		}
+	}
+	// 关闭临时实例，等待其后台生成 hint 文件的任务完成
+	closeErr := mergeDB.Close()
+	mergeDB = nil
+	if closeErr != nil {
+		return closeErr
+	}
 
 	// 写标识 merge 完成的文件
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath, db.cipher, db.fs)
 	if err != nil {
 		return err
 	}
-	//merge完成文件中记录merge到的下一个最新活跃文件（merge时的活跃文件）的id
-	mergeFinRecord := &data.LogRecord{
-		Key:   []byte(mergeFinishedKey),
-		Value: []byte(strconv.Itoa(int(nonMergeFileId))),
+	defer mergeFinishedFile.Close()
+	return writeMergeGeneration(mergeFinishedFile, &mergeGeneration{
+		nonMergeFileId: nonMergeFileId,
+		seqNo:          mergeSeqNo,
+		changesFloor:   changesFloor,
+	})
+}
+
+// 将 merge 信息写入到 merge 完成文件中
+// merge 完成文件中首先记录 merge 到的下一个最新活跃文件（merge时的活跃文件）的id
+// 之后记录 merge 时的序列号以及保留的变更历史的范围，merge 文件中的记录不再参与启动时的序列号恢复
+func writeMergeGeneration(file *data.DataFile, gen *mergeGeneration) error {
+	for _, record := range []*data.LogRecord{
+		{Key: []byte(mergeFinishedKey), Value: []byte(strconv.Itoa(int(gen.nonMergeFileId)))},
+		{Key: []byte(mergeSeqNoKey), Value: []byte(strconv.FormatUint(gen.seqNo, 10))},
+		{Key: []byte(mergeChangesFloorKey), Value: []byte(strconv.FormatUint(gen.changesFloor, 10))},
+	} {
+		encRecord, _ := data.EncodeLogRecord(record)
+		if err := file.Write(encRecord); err != nil {
+			return err
+		}
+	}
+	return file.Sync()
+}
+
+// 将一条已经提交的记录重写到 merge 文件中
+// 序列号大于 changesFloor 的记录作为变更历史原样保留，不论其是否有效；有效的记录还需要写入 hint 文件
+func (db *DB) rewriteMergeRecord(mergeDB *DB, hintFile *data.DataFile, r *mergeRecord, changesFloor uint64) error {
+	logRecord := r.record
+	if r.seqNo > changesFloor {
+		pos, err := mergeDB.appendLogRecord(&data.LogRecord{
+			Key:        logRecord.Key,
+			Value:      logRecord.Value,
+			Type:       logRecord.Type,
+			BucketId:   logRecord.BucketId,
+			AutoCommit: true,
+		})
+		if err != nil {
+			return err
+		}
+		// 有效的普通记录本身就是完整的值，无需再写一份
+		if r.livePos != nil && !r.livePos.Operand {
+			return hintFile.WriteBucketHintRecord(logRecord.BucketId, r.realKey, pos)
+		}
 	}
-	encRecord, _ := data.EncodeLogRecord(mergeFinRecord)
-	if err := mergeFinishedFile.Write(encRecord); err != nil {
-		return err
+	if r.livePos == nil {
+		return nil
+	}
+
+	// 将合并操作数以及之前的记录合并为完整的值
+	if r.livePos.Operand {
+		db.mu.RLock()
+		value, err := db.foldMergeOperands(r.livePos)
+		db.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+		logRecord.Value = value
+		logRecord.Type = data.LogRecordNormal
 	}
-	if err := mergeFinishedFile.Sync(); err != nil {
+	// 清除序列号以及事务标记
+	logRecord.Key = logRecordKeyWithSeq(r.realKey, nonTransactionSeqNo)
+	logRecord.AutoCommit = false
+	pos, err := mergeDB.appendLogRecord(logRecord)
+	if err != nil {
 		return err
 	}
-
-	return nil
+	// 将当前位置索引写到 Hint 文件当中
+	return hintFile.WriteBucketHintRecord(logRecord.BucketId, r.realKey, pos)
 }
 
 func (db *DB) getMergePath() string {
@@ -160,14 +335,14 @@ func (db *DB) getMergePath() string {
 func (db *DB) loadMergeFiles() error {
 	mergePath := db.getMergePath()
 	// merge 目录不存在的话直接返回
-	if _, err := os.Stat(mergePath); os.IsNotExist(err) {
+	if _, err := db.fs.Stat(mergePath); os.IsNotExist(err) {
 		return nil
 	}
 	defer func() {
-		_ = os.RemoveAll(mergePath)
+		_ = db.fs.RemoveAll(mergePath)
 	}()
 
-	dirEntries, err := os.ReadDir(mergePath)
+	dirEntries, err := db.fs.ReadDir(mergePath)
 	if err != nil {
 		return err
 	}
@@ -184,20 +359,36 @@ func (db *DB) loadMergeFiles() error {
 
 	// 没有 merge 完成则直接返回
 	if !mergeFinished {
+		db.logger.Warn("discard unfinished merge", "path", mergePath)
 		return nil
 	}
 
 	nonMergeFileId, err := db.getNonMergeFileId(mergePath)
 	if err != nil {
+		db.logger.Warn("discard merge with invalid finished file", "path", mergePath, "err", err)
 		return nil
 	}
 
+	// 索引快照中的位置指向的是 merge 之前的数据文件，已经失效
+	checkpointFileName := filepath.Join(db.options.DirPath, data.CheckpointFileName)
+	if err := db.fs.Remove(checkpointFileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
 	// 删除旧的数据文件
 	var fileId uint32 = 0
 	for ; fileId < nonMergeFileId; fileId++ {
 		fileName := data.GetDataFileName(db.options.DirPath, fileId)
-		if _, err := os.Stat(fileName); err == nil {
-			if err := os.Remove(fileName); err != nil {
+		if _, err := db.fs.Stat(fileName); err == nil {
+			if err := db.fs.Remove(fileName); err != nil {
+				return err
+			}
+		}
+		for _, name := range []string{
+			data.GetBloomFileName(db.options.DirPath, fileId),
+			data.GetDataHintFileName(db.options.DirPath, fileId),
+		} {
+			if err := db.fs.Remove(name); err != nil && !os.IsNotExist(err) {
 				return err
 			}
 		}
@@ -207,15 +398,17 @@ func (db *DB) loadMergeFiles() error {
 	for _, fileName := range mergeFileNames {
 		srcPath := filepath.Join(mergePath, fileName)
 		destPath := filepath.Join(db.options.DirPath, fileName)
-		if err := os.Rename(srcPath, destPath); err != nil {
+		if err := db.fs.Rename(srcPath, destPath); err != nil {
 			return err
 		}
 	}
+	db.logger.Info("merge files installed", "nonMergeFileId", nonMergeFileId, "files", len(mergeFileNames))
+	db.events.mergeInstalled(MergeInstalledInfo{NonMergeFileId: nonMergeFileId, Files: len(mergeFileNames)})
 	return nil
 }
 
 func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath, db.cipher, db.fs)
 	if err != nil {
 		return 0, err
 	}
@@ -231,23 +424,68 @@ func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 	return uint32(nonMergeFileId), nil
 }
 
+// 读取 merge 完成文件中记录的 merge 信息
+func (db *DB) getMergeGeneration(dirPath string) (*mergeGeneration, error) {
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath, db.cipher, db.fs)
+	if err != nil {
+		return nil, err
+	}
+	defer mergeFinishedFile.Close()
+	return readMergeGeneration(mergeFinishedFile)
+}
+
+// 读取文件中记录的 merge 信息，旧版本的文件中没有记录序列号时都为 0
+func readMergeGeneration(file *data.DataFile) (*mergeGeneration, error) {
+	gen := &mergeGeneration{}
+	var offset int64 = 0
+	for {
+		record, size, err := file.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		offset += size
+
+		var value uint64
+		value, err = strconv.ParseUint(string(record.Value), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		switch string(record.Key) {
+		case mergeFinishedKey:
+			gen.nonMergeFileId = uint32(value)
+		case mergeSeqNoKey:
+			gen.seqNo = value
+		case mergeChangesFloorKey:
+			gen.changesFloor = value
+		}
+	}
+	return gen, nil
+}
+
 // 从 hint 文件中加载索引
-func (db *DB) loadIndexFromHintFile() error {
+func (db *DB) loadIndexFromHintFile(ctx context.Context) error {
 	// 查看 hint 索引文件是否存在
 	hintFileName := filepath.Join(db.options.DirPath, data.HintFileName)
-	if _, err := os.Stat(hintFileName); os.IsNotExist(err) {
+	if _, err := db.fs.Stat(hintFileName); os.IsNotExist(err) {
 		return nil
 	}
 
 	//	打开 hint 索引文件
-	hintFile, err := data.OpenHintFile(db.options.DirPath)
+	hintFile, err := data.OpenHintFile(db.options.DirPath, db.cipher, db.fs)
 	if err != nil {
 		return err
 	}
+	defer hintFile.Close()
 
 	// 读取文件中的索引
 	var offset int64 = 0
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		logRecord, size, err := hintFile.ReadLogRecord(offset)
 		if err != nil {
 			if err == io.EOF {
@@ -258,7 +496,8 @@ func (db *DB) loadIndexFromHintFile() error {
 
 		// 解码拿到实际的位置索引
 		pos := data.DecodeLogRecordPos(logRecord.Value)
-		db.index.Put(logRecord.Key, pos)
+		db.bucketById(logRecord.BucketId).index.Put(logRecord.Key, pos)
+		db.collectBloomKey(pos.Fid, logRecord.Key)
 		offset += size
 	}
 	return nil
@@ -0,0 +1,154 @@
+package LingDB_go
+
+import (
+	"LingDB/LingDB-go/data"
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+)
+
+// MergeOperator 合并操作，将 key 已有的值和之后通过 MergeValue 写入的操作数合并为完整的值
+// 读取时以及 merge 清理数据文件时都会调用，同样的输入必须得到同样的结果
+type MergeOperator interface {
+	// Merge existing 为 key 已有的值，不存在时为 nil，operands 按照写入的先后顺序排列
+	// 实现不能修改 existing 和 operands 的内容
+	Merge(key []byte, existing []byte, operands [][]byte) ([]byte, error)
+}
+
+// Int64AddOperator 将值和操作数都视为 8 字节大端编码的 int64，合并结果为它们的和
+// key 不存在时从 0 开始累加，可以使用 EncodeInt64 和 DecodeInt64 编解码
+type Int64AddOperator struct{}
+
+// Merge 实现 MergeOperator 接口
+func (Int64AddOperator) Merge(key []byte, existing []byte, operands [][]byte) ([]byte, error) {
+	var sum int64
+	if existing != nil {
+		n, err := DecodeInt64(existing)
+		if err != nil {
+			return nil, err
+		}
+		sum = n
+	}
+	for _, operand := range operands {
+		n, err := DecodeInt64(operand)
+		if err != nil {
+			return nil, err
+		}
+		sum += n
+	}
+	return EncodeInt64(sum), nil
+}
+
+// ByteAppendOperator 将操作数依次追加到已有的值之后，Separator 不为空时插入到相邻的两段数据之间
+type ByteAppendOperator struct {
+	Separator []byte
+}
+
+// Merge 实现 MergeOperator 接口
+func (o ByteAppendOperator) Merge(key []byte, existing []byte, operands [][]byte) ([]byte, error) {
+	size := len(existing)
+	for _, operand := range operands {
+		size += len(o.Separator) + len(operand)
+	}
+	result := make([]byte, 0, size)
+	result = append(result, existing...)
+	for i, operand := range operands {
+		if existing != nil || i > 0 {
+			result = append(result, o.Separator...)
+		}
+		result = append(result, operand...)
+	}
+	return result, nil
+}
+
+// EncodeInt64 将 int64 编码为 Int64AddOperator 使用的 8 字节大端格式
+func EncodeInt64(n int64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(n))
+	return buf
+}
+
+// DecodeInt64 解码 Int64AddOperator 使用的 8 字节大端格式
+func DecodeInt64(buf []byte) (int64, error) {
+	if len(buf) != 8 {
+		return 0, ErrInvalidMergeOperand
+	}
+	return int64(binary.BigEndian.Uint64(buf)), nil
+}
+
+// MergeValue 追加一个合并操作数，读取时由 Options.MergeOperator 将已有的值和操作数合并
+// 写入时无需读取已有的值，适合计数器以及追加写等场景
+func (db *DB) MergeValue(key []byte, operand []byte) error {
+	return db.mergeValue(db.defaultBucket, key, operand)
+}
+
+// MergeValue 同 DB.MergeValue，作用于 bucket 中的数据
+func (b *Bucket) MergeValue(key []byte, operand []byte) error {
+	return b.db.mergeValue(b, key, operand)
+}
+
+func (db *DB) mergeValue(b *Bucket, key []byte, operand []byte) error {
+	defer db.metrics.putLatency.observeSince(time.Now())
+	atomic.AddUint64(&db.metrics.puts, 1)
+
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if db.options.MergeOperator == nil {
+		return ErrMergeOperatorNotSet
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	seqNo := atomic.AddUint64(&db.seqNo, 1)
+	pos, err := db.appendLogRecord(&data.LogRecord{
+		Key:        logRecordKeyWithSeq(key, seqNo),
+		Value:      operand,
+		Type:       data.LogRecordMergeOperand,
+		BucketId:   b.id,
+		AutoCommit: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	// 新的操作数指向 key 之前的记录，读取时沿着链表找到所有的操作数以及基础值
+	pos.Operand = true
+	pos.Prev = b.index.Get(key)
+	if ok := b.index.Put(key, pos); !ok {
+		return ErrIndexUpdateFailed
+	}
+
+	db.notifyWatchers(b.id, ChangeMerge, key, operand, seqNo)
+	return nil
+}
+
+// 从最新的操作数开始沿着链表向前读取，直到遇到基础值或者链表结束，再按照写入的先后顺序合并
+// 调用方需要持有读锁
+func (db *DB) foldMergeOperands(logRecordPos *data.LogRecordPos) ([]byte, error) {
+	if db.options.MergeOperator == nil {
+		return nil, ErrMergeOperatorNotSet
+	}
+
+	var key, existing []byte
+	var operands [][]byte
+	for pos := logRecordPos; pos != nil; pos = pos.Prev {
+		logRecord, err := db.readLogRecordAt(pos)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			key, _ = parseLogRecordKey(logRecord.Key)
+		}
+		if !pos.Operand {
+			existing = logRecord.Value
+			break
+		}
+		operands = append(operands, logRecord.Value)
+	}
+
+	for i, j := 0, len(operands)-1; i < j; i, j = i+1, j-1 {
+		operands[i], operands[j] = operands[j], operands[i]
+	}
+	return db.options.MergeOperator.Merge(key, existing, operands)
+}
@@ -0,0 +1,166 @@
+package LingDB_go
+
+import (
+	"LingDB/LingDB-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestInt64AddOperator(t *testing.T) {
+	op := Int64AddOperator{}
+	val, err := op.Merge([]byte("key"), nil, [][]byte{EncodeInt64(1), EncodeInt64(-3)})
+	assert.Nil(t, err)
+	n, err := DecodeInt64(val)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-2), n)
+
+	val, err = op.Merge([]byte("key"), EncodeInt64(10), [][]byte{EncodeInt64(5)})
+	assert.Nil(t, err)
+	n, _ = DecodeInt64(val)
+	assert.Equal(t, int64(15), n)
+
+	_, err = op.Merge([]byte("key"), []byte("abc"), nil)
+	assert.Equal(t, ErrInvalidMergeOperand, err)
+	_, err = op.Merge([]byte("key"), nil, [][]byte{[]byte("abc")})
+	assert.Equal(t, ErrInvalidMergeOperand, err)
+}
+
+func TestByteAppendOperator(t *testing.T) {
+	op := ByteAppendOperator{Separator: []byte(",")}
+	val, err := op.Merge([]byte("key"), nil, [][]byte{[]byte("a"), []byte("b")})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a,b"), val)
+
+	existing := []byte("x")
+	val, err = op.Merge([]byte("key"), existing, [][]byte{[]byte("y")})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("x,y"), val)
+	assert.Equal(t, []byte("x"), existing)
+
+	val, err = ByteAppendOperator{}.Merge([]byte("key"), []byte("x"), [][]byte{[]byte("y"), []byte("z")})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("xyz"), val)
+}
+
+func TestDB_MergeValue(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-value")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.MergeOperator = Int64AddOperator{}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	counter := []byte("counter")
+	fresh := []byte("fresh")
+	assert.Nil(t, db.Put(counter, EncodeInt64(100)))
+	// 操作数分布在多个数据文件中，中间穿插其他数据
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.MergeValue(counter, EncodeInt64(1)))
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(32)))
+	}
+	assert.True(t, len(db.olderFiles) > 0)
+	assert.Nil(t, db.MergeValue(fresh, EncodeInt64(7)))
+	assert.Nil(t, db.MergeValue(fresh, EncodeInt64(-2)))
+
+	// 删除之后重新从 0 开始累加
+	deleted := []byte("deleted")
+	assert.Nil(t, db.MergeValue(deleted, EncodeInt64(7)))
+	assert.Nil(t, db.Delete(deleted))
+	assert.Nil(t, db.MergeValue(deleted, EncodeInt64(3)))
+
+	check := func(db *DB, counterValue int64) {
+		for key, expected := range map[string]int64{"counter": counterValue, "fresh": 5, "deleted": 3} {
+			val, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+			n, err := DecodeInt64(val)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, n, key)
+		}
+
+		iter := db.NewIterator(IteratorOptions{Prefix: []byte("counter")})
+		defer iter.Close()
+		assert.True(t, iter.Valid())
+		val, err := iter.Value()
+		assert.Nil(t, err)
+		n, _ := DecodeInt64(val)
+		assert.Equal(t, counterValue, n)
+	}
+	check(db, 1100)
+
+	// 重启后从数据文件和 hint 文件中恢复操作数链表
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	db = db2
+	check(db2, 1100)
+
+	// 从索引快照中恢复操作数链表，快照之后写入的操作数也能正确合并
+	assert.Nil(t, db2.Checkpoint())
+	assert.Nil(t, db2.MergeValue(counter, EncodeInt64(10)))
+	assert.Nil(t, db2.Close())
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	db = db3
+	check(db3, 1110)
+
+	// merge 将操作数合并为完整的值，merge 之后写入的操作数基于合并后的值
+	assert.Nil(t, db3.Merge())
+	assert.Nil(t, db3.MergeValue(counter, EncodeInt64(1)))
+	check(db3, 1111)
+	assert.Nil(t, db3.Close())
+	db4, err := Open(opts)
+	assert.Nil(t, err)
+	db = db4
+	check(db4, 1111)
+	pos := db4.index.Get(counter)
+	assert.True(t, pos.Operand)
+	assert.NotNil(t, pos.Prev)
+	assert.False(t, pos.Prev.Operand)
+	assert.Nil(t, pos.Prev.Prev)
+}
+
+func TestDB_MergeValue_NoOperator(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-value-2")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	err = db.MergeValue([]byte("key"), []byte("value"))
+	assert.Equal(t, ErrMergeOperatorNotSet, err)
+	err = db.MergeValue(nil, []byte("value"))
+	assert.Equal(t, ErrKeyIsEmpty, err)
+}
+
+func TestDB_MergeValue_Bucket(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-value-3")
+	opts.DirPath = dir
+	opts.MergeOperator = ByteAppendOperator{Separator: []byte("|")}
+	opts.ReadCacheSize = 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	logs, err := db.Bucket("logs")
+	assert.Nil(t, err)
+	assert.Nil(t, logs.MergeValue([]byte("events"), []byte("a")))
+	assert.Nil(t, logs.MergeValue([]byte("events"), []byte("b")))
+	assert.Nil(t, db.MergeValue([]byte("events"), []byte("c")))
+
+	// 第二次读取命中读缓存
+	for i := 0; i < 2; i++ {
+		val, err := logs.Get([]byte("events"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("a|b"), val)
+		val, err = db.Get([]byte("events"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("c"), val)
+	}
+	assert.True(t, db.ReadCacheStats().Hits > 0)
+}
@@ -0,0 +1,219 @@
+package LingDB_go
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// 延迟直方图的桶上界（秒），从 10 微秒到 10 秒
+var latencyBuckets = []float64{
+	0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10,
+}
+
+// 数据库运行时的统计信息，所有字段都使用原子操作更新
+type metrics struct {
+	puts          uint64 // Put 次数
+	gets          uint64 // Get 次数
+	deletes       uint64 // Delete 次数
+	batchCommits  uint64 // WriteBatch 提交次数
+	merges        uint64 // Merge 次数
+	syncs         uint64 // 数据文件持久化次数
+	bytesWritten  uint64 // 写入数据文件的字节数
+	fileRotations uint64 // 活跃文件写满后切换的次数
+
+	putLatency         *histogram
+	getLatency         *histogram
+	deleteLatency      *histogram
+	batchCommitLatency *histogram
+	mergeDuration      *histogram
+	syncLatency        *histogram
+	openDuration       *histogram
+}
+
+// 延迟直方图
+type histogram struct {
+	counts []uint64 // 每个桶中的数量，最后一个桶对应 +Inf
+	count  uint64   // 总数量
+	sum    uint64   // 总耗时（纳秒）
+}
+
+// MetricsSnapshot 数据库统计信息的快照
+type MetricsSnapshot struct {
+	Puts          uint64 // Put 次数
+	Gets          uint64 // Get 次数
+	Deletes       uint64 // Delete 次数
+	BatchCommits  uint64 // WriteBatch 提交次数
+	Merges        uint64 // Merge 次数
+	Syncs         uint64 // 数据文件持久化次数
+	BytesWritten  uint64 // 写入数据文件的字节数
+	FileRotations uint64 // 活跃文件写满后切换的次数
+	Keys          int    // 当前所有 bucket 中的 key 数量
+	CacheHits     uint64 // 读缓存命中次数
+	CacheMisses   uint64 // 读缓存未命中次数
+
+	PutLatency         HistogramSnapshot // Put 延迟
+	GetLatency         HistogramSnapshot // Get 延迟
+	DeleteLatency      HistogramSnapshot // Delete 延迟
+	BatchCommitLatency HistogramSnapshot // WriteBatch 提交延迟
+	MergeDuration      HistogramSnapshot // Merge 耗时
+	SyncLatency        HistogramSnapshot // 数据文件持久化延迟
+	OpenDuration       HistogramSnapshot // 打开数据库以及加载索引的耗时
+}
+
+// HistogramSnapshot 延迟直方图的快照
+type HistogramSnapshot struct {
+	Buckets []float64 // 每个桶的上界（秒）
+	Counts  []uint64  // 每个桶中的数量（非累计），比 Buckets 多一个 +Inf 桶
+	Count   uint64    // 总数量
+	Sum     float64   // 总耗时（秒）
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		putLatency:         newHistogram(),
+		getLatency:         newHistogram(),
+		deleteLatency:      newHistogram(),
+		batchCommitLatency: newHistogram(),
+		mergeDuration:      newHistogram(),
+		syncLatency:        newHistogram(),
+		openDuration:       newHistogram(),
+	}
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(latencyBuckets)+1)}
+}
+
+// 记录从 start 开始到现在的耗时
+func (h *histogram) observeSince(start time.Time) {
+	d := time.Since(start)
+	seconds := d.Seconds()
+	idx := len(latencyBuckets)
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			idx = i
+			break
+		}
+	}
+	atomic.AddUint64(&h.counts[idx], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sum, uint64(d.Nanoseconds()))
+}
+
+func (h *histogram) snapshot() HistogramSnapshot {
+	counts := make([]uint64, len(h.counts))
+	for i := range h.counts {
+		counts[i] = atomic.LoadUint64(&h.counts[i])
+	}
+	return HistogramSnapshot{
+		Buckets: latencyBuckets,
+		Counts:  counts,
+		Count:   atomic.LoadUint64(&h.count),
+		Sum:     time.Duration(atomic.LoadUint64(&h.sum)).Seconds(),
+	}
+}
+
+// Metrics 获取数据库统计信息的快照
+func (db *DB) Metrics() MetricsSnapshot {
+	m := db.metrics
+	cacheStats := db.ReadCacheStats()
+	db.mu.RLock()
+	var keys int
+	for _, b := range db.buckets {
+		keys += b.index.Size()
+	}
+	db.mu.RUnlock()
+	return MetricsSnapshot{
+		Puts:          atomic.LoadUint64(&m.puts),
+		Gets:          atomic.LoadUint64(&m.gets),
+		Deletes:       atomic.LoadUint64(&m.deletes),
+		BatchCommits:  atomic.LoadUint64(&m.batchCommits),
+		Merges:        atomic.LoadUint64(&m.merges),
+		Syncs:         atomic.LoadUint64(&m.syncs),
+		BytesWritten:  atomic.LoadUint64(&m.bytesWritten),
+		FileRotations: atomic.LoadUint64(&m.fileRotations),
+		Keys:          keys,
+		CacheHits:     cacheStats.Hits,
+		CacheMisses:   cacheStats.Misses,
+
+		PutLatency:         m.putLatency.snapshot(),
+		GetLatency:         m.getLatency.snapshot(),
+		DeleteLatency:      m.deleteLatency.snapshot(),
+		BatchCommitLatency: m.batchCommitLatency.snapshot(),
+		MergeDuration:      m.mergeDuration.snapshot(),
+		SyncLatency:        m.syncLatency.snapshot(),
+		OpenDuration:       m.openDuration.snapshot(),
+	}
+}
+
+// MetricsHandler 以 Prometheus 文本格式输出数据库统计信息的 http.Handler
+func (db *DB) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		snapshot := db.Metrics()
+		_ = snapshot.WritePrometheus(w)
+	})
+}
+
+// WritePrometheus 以 Prometheus 文本格式输出统计信息
+func (s *MetricsSnapshot) WritePrometheus(w io.Writer) error {
+	pw := &prometheusWriter{w: w}
+	pw.counter("lingdb_puts_total", "Total number of Put calls.", s.Puts)
+	pw.counter("lingdb_gets_total", "Total number of Get calls.", s.Gets)
+	pw.counter("lingdb_deletes_total", "Total number of Delete calls.", s.Deletes)
+	pw.counter("lingdb_batch_commits_total", "Total number of WriteBatch commits.", s.BatchCommits)
+	pw.counter("lingdb_merges_total", "Total number of merges.", s.Merges)
+	pw.counter("lingdb_syncs_total", "Total number of data file syncs.", s.Syncs)
+	pw.counter("lingdb_bytes_written_total", "Total bytes written to data files.", s.BytesWritten)
+	pw.counter("lingdb_file_rotations_total", "Total number of active file rotations.", s.FileRotations)
+	pw.counter("lingdb_read_cache_hits_total", "Total number of read cache hits.", s.CacheHits)
+	pw.counter("lingdb_read_cache_misses_total", "Total number of read cache misses.", s.CacheMisses)
+	pw.gauge("lingdb_keys", "Number of keys in the index.", int64(s.Keys))
+
+	pw.histogram("lingdb_put_duration_seconds", "Latency of Put calls.", s.PutLatency)
+	pw.histogram("lingdb_get_duration_seconds", "Latency of Get calls.", s.GetLatency)
+	pw.histogram("lingdb_delete_duration_seconds", "Latency of Delete calls.", s.DeleteLatency)
+	pw.histogram("lingdb_batch_commit_duration_seconds", "Latency of WriteBatch commits.", s.BatchCommitLatency)
+	pw.histogram("lingdb_merge_duration_seconds", "Duration of merges.", s.MergeDuration)
+	pw.histogram("lingdb_sync_duration_seconds", "Latency of data file syncs.", s.SyncLatency)
+	pw.histogram("lingdb_open_duration_seconds", "Duration of opening the database and loading the index.", s.OpenDuration)
+	return pw.err
+}
+
+// 输出 Prometheus 文本格式，记录第一个写入错误
+type prometheusWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (pw *prometheusWriter) printf(format string, args ...interface{}) {
+	if pw.err != nil {
+		return
+	}
+	_, pw.err = fmt.Fprintf(pw.w, format, args...)
+}
+
+func (pw *prometheusWriter) counter(name, help string, value uint64) {
+	pw.printf("# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+}
+
+func (pw *prometheusWriter) gauge(name, help string, value int64) {
+	pw.printf("# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, value)
+}
+
+func (pw *prometheusWriter) histogram(name, help string, h HistogramSnapshot) {
+	pw.printf("# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	var cumulative uint64
+	for i, bound := range h.Buckets {
+		cumulative += h.Counts[i]
+		pw.printf("%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	cumulative += h.Counts[len(h.Buckets)]
+	pw.printf("%s_bucket{le=\"+Inf\"} %d\n", name, cumulative)
+	pw.printf("%s_sum %s\n", name, strconv.FormatFloat(h.Sum, 'g', -1, 64))
+	pw.printf("%s_count %d\n", name, h.Count)
+}
@@ -0,0 +1,69 @@
+package LingDB_go
+
+import (
+	"LingDB/LingDB-go/utils"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDB_Metrics(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-metrics")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 10; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		err = db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.Put(utils.GetTestKey(1), utils.RandomValue(64))
+	assert.Nil(t, err)
+	err = wb.Commit()
+	assert.Nil(t, err)
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	m := db.Metrics()
+	assert.Equal(t, uint64(1000), m.Puts)
+	assert.Equal(t, uint64(10), m.Gets)
+	assert.Equal(t, uint64(10), m.Deletes)
+	assert.Equal(t, uint64(1), m.BatchCommits)
+	assert.Equal(t, uint64(1), m.Merges)
+	assert.Equal(t, 991, m.Keys)
+	assert.True(t, m.BytesWritten > 1000*64)
+	assert.True(t, m.FileRotations > 0)
+	assert.True(t, m.Syncs > 0)
+	assert.Equal(t, uint64(1000), m.PutLatency.Count)
+	assert.Equal(t, uint64(1), m.OpenDuration.Count)
+	var total uint64
+	for _, c := range m.PutLatency.Counts {
+		total += c
+	}
+	assert.Equal(t, m.PutLatency.Count, total)
+
+	// Prometheus 文本格式
+	rec := httptest.NewRecorder()
+	db.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	assert.Nil(t, err)
+	text := string(body)
+	assert.True(t, strings.Contains(text, "# TYPE lingdb_puts_total counter\nlingdb_puts_total 1000\n"))
+	assert.True(t, strings.Contains(text, "lingdb_put_duration_seconds_bucket{le=\"+Inf\"} 1000\n"))
+	assert.True(t, strings.Contains(text, "lingdb_put_duration_seconds_count 1000\n"))
+	assert.True(t, strings.Contains(text, "lingdb_keys 991\n"))
+}
@@ -1,16 +1,89 @@
 package LingDB_go
 
+import (
+	"LingDB/LingDB-go/fio"
+	"runtime"
+	"time"
+)
+
 type Options struct {
-	DirPath      string      //数据库的数据存储目录
-	DataFileSize int64       //数据文件的大小限制
-	SyncWrites   bool        //每次写数据是否持久化
-	IndexType    IndexerType //数据索引类型
+	DirPath       string      //数据库的数据存储目录
+	DataFileSize  int64       //数据文件的大小限制
+	SyncWrites    bool        //每次写数据是否持久化
+	IOType        FileIOType  //活跃文件使用的文件 IO 类型，默认为标准文件 IO
+	IndexType     IndexerType //数据索引类型
+	ReadCacheSize int64       //读缓存的容量（字节），缓存热点数据避免重复读盘，默认为 0 表示不开启
+	//是否使用 fallocate 为活跃文件预先分配 DataFileSize 大小的空间，减少追加写入时更新文件元数据的开销
+	//默认为 false；封存时截断没有使用的空间，只在支持 fallocate 的平台上生效
+	Preallocate bool
+	//活跃文件写缓冲区的容量（字节），写入先追加到缓冲区，写满或者 Sync 时才写入文件，减少小数据写入的系统调用
+	//默认为 0 表示不开启；没有 Sync 的写入在进程崩溃时会丢失，SyncWrites 为 true 时每次写入都会刷新缓冲区
+	WriteBufferSize int
+	//每个 key 在布隆过滤器中占用的位数，开启后为每个封存的数据文件构造布隆过滤器，默认为 0 表示不开启
+	//索引完整保存在内存中时（BTREE、ART）直接查询索引更快，不会构造布隆过滤器
+	BloomFilterBitsPerKey int
+
+	LoadConcurrency int //启动时并发解码数据文件的协程数量，小于等于 1 时按顺序逐个加载
+
+	CheckpointInterval time.Duration //定期生成索引快照的间隔，默认为 0 表示不定期生成，只能手动调用 Checkpoint
+
+	Logger        Logger        //存储引擎的日志输出，默认为 nil 表示不输出日志
+	EventListener EventListener //存储引擎事件的回调，默认不设置任何回调
+
+	MergeOperator MergeOperator //合并操作，使用 MergeValue 前必须设置，默认为 nil
+
+	//merge 时保留最近多少个序列号的变更历史，供 ChangesSince 重放，默认为 0 表示不保留
+	ChangeRetention uint64
+
+	//数据文件以及 hint、merge 完成等文件的加密 key，长度为 16、24 或 32 字节，分别对应 AES-128、AES-192 和 AES-256
+	//默认为 nil 表示不加密；开启之前写入的明文文件在 merge 之后被加密
+	EncryptionKey []byte
+	//轮换之前使用的加密 key，只用于读取旧的文件，merge 会使用 EncryptionKey 重写所有的旧数据文件
+	//merge 并重启之后 OutdatedFiles 为空，旧的 key 就可以移除了
+	OldEncryptionKeys [][]byte
+
+	//是否将数据文件以及 hint、merge 等文件都保存在内存中，不访问磁盘，主要用于测试，默认为 false
+	//没有设置 FileSystem 时每次 Open 都使用一个新的内存文件系统，得到一个空的数据库，DirPath 可以为空
+	InMemory bool
+	//数据目录所在的文件系统，默认为 nil 表示操作系统的文件系统，开启 InMemory 时为新的内存文件系统
+	//需要重新打开内存中的数据库时，可以传入同一个 fio.NewMemFileSystem()，Close 之后数据仍然保留在其中
+	FileSystem fio.FileSystem
 }
 
 // IteratorOptions 索引迭代器配置项
 type IteratorOptions struct {
-	Prefix  []byte // 遍历前缀为指定值的 Key，默认为空
-	Reverse bool   // 是否反向遍历，默认 false 是正向
+	Prefix          []byte // 遍历前缀为指定值的 Key，默认为空
+	Reverse         bool   // 是否反向遍历，默认 false 是正向
+	LowerBound      []byte // 遍历范围的下界，默认为空表示不限制
+	UpperBound      []byte // 遍历范围的上界，默认为空表示不限制
+	LowerExclusive  bool   // 是否排除下界本身，默认 false 表示包含下界
+	UpperExclusive  bool   // 是否排除上界本身，默认 false 表示包含上界
+	KeyOnly         bool   // 是否只遍历 key，开启后不会访问磁盘，也无法获取 value
+	PrefetchSize    int    // 批量预读 value 的数量，大于 0 时开启预读，默认为 0 不预读
+	PrefetchWorkers int    // 预读 value 时并发读取的协程数量，默认为 1
+}
+
+// WatchOptions 订阅数据变更的配置项
+type WatchOptions struct {
+	BufferSize     int                 // 缓冲的变更数量
+	OverflowPolicy WatchOverflowPolicy // 缓冲区满时的处理方式，默认关闭订阅
+}
+
+type WatchOverflowPolicy = int8
+
+const (
+	// WatchCloseOnOverflow 缓冲区满时关闭订阅，Err 返回 ErrWatchOverflow，订阅方需要重新同步
+	WatchCloseOnOverflow WatchOverflowPolicy = iota
+	// WatchDropNewest 缓冲区满时丢弃新的变更
+	WatchDropNewest
+	// WatchDropOldest 缓冲区满时丢弃最旧的变更
+	WatchDropOldest
+)
+
+// ShardedOptions 分片数据库的配置项
+type ShardedOptions struct {
+	Options    Options // 每个分片使用的配置项，DirPath 为所有分片的父目录，每个分片位于其中的子目录
+	ShardCount int     // 分片的数量，打开已有的分片数据库时需要和创建时保持一致
 }
 
 // WriteBatchOptions 批量写配置项
@@ -19,6 +92,19 @@ type WriteBatchOptions struct {
 	SyncWrites  bool // 提交时是否 sync 持久化
 }
 
+type FileIOType = int8
+
+const (
+	// StandardFIO 标准文件 IO，写入页缓存，使用 fsync 持久化
+	StandardFIO FileIOType = iota
+	// DataSyncFIO 使用 fdatasync 持久化，不同步修改时间等元数据，尾延迟更低
+	DataSyncFIO
+	// DSyncFIO 以 O_DSYNC 打开文件，每次写入都会等待数据持久化
+	DSyncFIO
+	// DirectFIO 以 O_DIRECT 打开文件，绕过页缓存写入，使用 fdatasync 持久化，只在 linux 上生效
+	DirectFIO
+)
+
 type IndexerType = int8
 
 const (
@@ -29,15 +115,40 @@ const (
 )
 
 var DefaultOptions = Options{
-	DirPath:      "./db-data",
-	DataFileSize: 256 * 1024 * 1024, // 256MB
-	SyncWrites:   false,
-	IndexType:    BTREE,
+	DirPath:       "./db-data",
+	DataFileSize:  256 * 1024 * 1024, // 256MB
+	SyncWrites:    false,
+	IOType:        StandardFIO,
+	IndexType:     BTREE,
+	ReadCacheSize: 0,
+	Preallocate:   false,
+
+	WriteBufferSize: 0,
+
+	BloomFilterBitsPerKey: 0,
+
+	LoadConcurrency: runtime.NumCPU(),
+
+	CheckpointInterval: 0,
+
+	ChangeRetention: 0,
+}
+
+var DefaultShardedOptions = ShardedOptions{
+	Options:    DefaultOptions,
+	ShardCount: 8,
 }
 
 var DefaultIteratorOptions = IteratorOptions{
-	Prefix:  nil,
-	Reverse: false,
+	Prefix:     nil,
+	Reverse:    false,
+	LowerBound: nil,
+	UpperBound: nil,
+}
+
+var DefaultWatchOptions = WatchOptions{
+	BufferSize:     1024,
+	OverflowPolicy: WatchCloseOnOverflow,
 }
 
 var DefaultWriteBatchOptions = WriteBatchOptions{
@@ -0,0 +1,115 @@
+package raft
+
+import (
+	lingDB "LingDB/LingDB-go"
+	"encoding/binary"
+)
+
+const (
+	opPut byte = iota
+	opDelete
+)
+
+// Batch 一组需要原子写入的操作，作为一条日志提交到集群中
+type Batch struct {
+	ops []batchOp
+}
+
+type batchOp struct {
+	typ   byte
+	key   []byte
+	value []byte
+}
+
+// NewBatch 创建一个空的批次
+func NewBatch() *Batch {
+	return &Batch{}
+}
+
+// Put 写入数据
+func (b *Batch) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return lingDB.ErrKeyIsEmpty
+	}
+	b.ops = append(b.ops, batchOp{typ: opPut, key: key, value: value})
+	return nil
+}
+
+// Delete 删除数据
+func (b *Batch) Delete(key []byte) error {
+	if len(key) == 0 {
+		return lingDB.ErrKeyIsEmpty
+	}
+	b.ops = append(b.ops, batchOp{typ: opDelete, key: key})
+	return nil
+}
+
+// Len 批次中操作的数量
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// 批次编码为 操作数量 | 类型 key长度 key [value长度 value] ...
+func (b *Batch) encode() []byte {
+	size := binary.MaxVarintLen64
+	for _, op := range b.ops {
+		size += 1 + binary.MaxVarintLen64*2 + len(op.key) + len(op.value)
+	}
+	buf := make([]byte, size)
+	n := binary.PutUvarint(buf, uint64(len(b.ops)))
+	for _, op := range b.ops {
+		buf[n] = op.typ
+		n++
+		n += binary.PutUvarint(buf[n:], uint64(len(op.key)))
+		n += copy(buf[n:], op.key)
+		if op.typ == opPut {
+			n += binary.PutUvarint(buf[n:], uint64(len(op.value)))
+			n += copy(buf[n:], op.value)
+		}
+	}
+	return buf[:n]
+}
+
+func decodeBatch(buf []byte) (*Batch, error) {
+	count, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return nil, ErrInvalidCommand
+	}
+	buf = buf[n:]
+	readBytes := func() ([]byte, bool) {
+		size, n := binary.Uvarint(buf)
+		if n <= 0 || uint64(len(buf)-n) < size {
+			return nil, false
+		}
+		value := buf[n : n+int(size)]
+		buf = buf[n+int(size):]
+		return value, true
+	}
+
+	b := &Batch{}
+	for i := uint64(0); i < count; i++ {
+		if len(buf) == 0 {
+			return nil, ErrInvalidCommand
+		}
+		op := batchOp{typ: buf[0]}
+		buf = buf[1:]
+		var ok bool
+		if op.key, ok = readBytes(); !ok {
+			return nil, ErrInvalidCommand
+		}
+		switch op.typ {
+		case opPut:
+			if op.value, ok = readBytes(); !ok {
+				return nil, ErrInvalidCommand
+			}
+		case opDelete:
+		default:
+			return nil, ErrInvalidCommand
+		}
+		b.ops = append(b.ops, op)
+	}
+	if len(buf) != 0 {
+		return nil, ErrInvalidCommand
+	}
+	return b, nil
+}
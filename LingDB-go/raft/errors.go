@@ -0,0 +1,16 @@
+package raft
+
+import "errors"
+
+var (
+	ErrInvalidConfig    = errors.New("invalid raft config")
+	ErrNotLeader        = errors.New("the node is not the leader")
+	ErrNodeClosed       = errors.New("the raft node is closed")
+	ErrProposalDropped  = errors.New("the proposal is overwritten by a new leader")
+	ErrProposalUnknown  = errors.New("the result of the proposal is unknown because of a snapshot installation")
+	ErrPeerUnreachable  = errors.New("the peer is unreachable")
+	ErrLogCorrupted     = errors.New("the raft log is corrupted")
+	ErrInvalidCommand   = errors.New("the raft command is invalid")
+	ErrInvalidSnapshot  = errors.New("the raft snapshot is invalid")
+	ErrSnapshotNotFound = errors.New("the raft snapshot is not found")
+)
@@ -0,0 +1,500 @@
+package raft
+
+import (
+	lingDB "LingDB/LingDB-go"
+	"LingDB/LingDB-go/fio"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	dataDirName     = "data"
+	logDirName      = "raft"
+	snapshotDirName = "snapshot"
+
+	// 记录已经应用到数据库中的日志位置，和日志中的写入在同一个事务中提交
+	metaBucketName = "__raft"
+	appliedKey     = "applied"
+)
+
+// Config raft 节点的配置项
+type Config struct {
+	ID                string         // 当前节点的 id
+	Peers             []string       // 集群中所有节点的 id，包括当前节点
+	DirPath           string         // 数据目录，其中分别保存数据库、raft 日志以及快照
+	DBOptions         lingDB.Options // 数据库的配置项，其中的 DirPath 会被替换为数据目录下的 data 目录，不支持 InMemory 以及其他文件系统
+	Transport         Transport      // 节点之间的通信方式
+	ElectionTimeout   time.Duration  // 选举超时时间，实际的超时时间在 [ElectionTimeout, 2*ElectionTimeout) 之间随机
+	HeartbeatInterval time.Duration  // leader 发送心跳的间隔，需要小于 ElectionTimeout
+	SnapshotThreshold uint64         // 快照之后应用了多少条日志时生成新的快照并压缩日志，为 0 时不生成快照
+	MaxAppendEntries  int            // 一次复制请求中最多包含的日志数量
+}
+
+var DefaultConfig = Config{
+	DBOptions:         lingDB.DefaultOptions,
+	ElectionTimeout:   500 * time.Millisecond,
+	HeartbeatInterval: 100 * time.Millisecond,
+	SnapshotThreshold: 10000,
+	MaxAppendEntries:  256,
+}
+
+type nodeState int
+
+const (
+	stateFollower nodeState = iota
+	stateCandidate
+	stateLeader
+)
+
+// Node raft 集群中的一个节点
+// Put、Delete 以及 Apply 作为日志复制到集群中，提交之后应用到每个节点本地的数据库中
+type Node struct {
+	config Config
+	peers  []string
+
+	mu          *sync.Mutex   // 保护 raft 的状态
+	applyMu     *sync.Mutex   // 保证日志的应用以及快照的生成、安装串行执行
+	dbMu        *sync.RWMutex // 安装快照时需要替换数据库实例
+	snapshotMu  *sync.RWMutex // 保护快照目录
+	handlerMu   *sync.RWMutex // 关闭时等待正在处理的请求结束
+	db          *lingDB.DB
+	meta        *lingDB.Bucket
+	store       *logStore
+	state       nodeState
+	currentTerm uint64
+	votedFor    string
+	leaderID    string
+	commitIndex uint64
+	lastApplied uint64
+	nextIndex   map[string]uint64
+	matchIndex  map[string]uint64
+	lastAck     map[string]time.Time // leader 最近一次收到各个节点响应的时间
+	deadline    time.Time            // 选举超时的时间点
+	lastContact time.Time            // 最近一次收到 leader 请求的时间
+	pending     map[uint64]*proposal // 等待应用的提议
+	err         error                // 无法恢复的错误，出现之后节点不再参与集群
+
+	applied     *notifier                // 日志应用之后通知等待的读写请求
+	commitCh    chan struct{}            // 提交新的日志之后唤醒应用日志的任务
+	replicateCh map[string]chan struct{} // 有新的日志时唤醒向对应节点复制日志的任务
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wg          *sync.WaitGroup
+	closed      bool
+}
+
+type proposal struct {
+	term uint64
+	done chan error
+}
+
+// Open 打开一个 raft 节点，节点会从数据目录中恢复之前的状态，并开始参与选举
+func Open(config Config) (*Node, error) {
+	if err := checkConfig(config); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(config.DirPath, os.ModePerm); err != nil {
+		return nil, err
+	}
+	// 替换数据目录或者快照目录时可能发生了崩溃
+	for _, name := range []string{dataDirName, snapshotDirName} {
+		if err := recoverDir(filepath.Join(config.DirPath, name)); err != nil {
+			return nil, err
+		}
+	}
+
+	store, err := openLogStore(filepath.Join(config.DirPath, logDirName), config.DBOptions)
+	if err != nil {
+		return nil, err
+	}
+	n := &Node{
+		config:      config,
+		mu:          new(sync.Mutex),
+		applyMu:     new(sync.Mutex),
+		dbMu:        new(sync.RWMutex),
+		snapshotMu:  new(sync.RWMutex),
+		handlerMu:   new(sync.RWMutex),
+		store:       store,
+		currentTerm: store.term,
+		votedFor:    store.votedFor,
+		nextIndex:   make(map[string]uint64),
+		matchIndex:  make(map[string]uint64),
+		lastAck:     make(map[string]time.Time),
+		pending:     make(map[uint64]*proposal),
+		applied:     newNotifier(),
+		commitCh:    make(chan struct{}, 1),
+		replicateCh: make(map[string]chan struct{}),
+		wg:          new(sync.WaitGroup),
+	}
+	for _, peer := range config.Peers {
+		if peer != config.ID {
+			n.peers = append(n.peers, peer)
+			n.replicateCh[peer] = make(chan struct{}, 1)
+		}
+	}
+	if err := n.openDB(); err != nil {
+		_ = store.close()
+		return nil, err
+	}
+	if err := n.recoverApplied(); err != nil {
+		_ = n.db.Close()
+		_ = store.close()
+		return nil, err
+	}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	n.resetElectionDeadline()
+	if err := config.Transport.Listen(n); err != nil {
+		n.cancel()
+		_ = n.db.Close()
+		_ = store.close()
+		return nil, err
+	}
+	n.wg.Add(2 + len(n.peers))
+	go n.runTicker()
+	go n.runApplier()
+	for _, peer := range n.peers {
+		go n.runReplicator(peer)
+	}
+	return n, nil
+}
+
+func checkConfig(config Config) error {
+	if config.ID == "" || config.DirPath == "" || config.Transport == nil {
+		return ErrInvalidConfig
+	}
+	if config.ElectionTimeout <= 0 || config.HeartbeatInterval <= 0 || config.HeartbeatInterval >= config.ElectionTimeout {
+		return ErrInvalidConfig
+	}
+	if config.MaxAppendEntries <= 0 {
+		return ErrInvalidConfig
+	}
+	// 快照以及 raft 日志都直接读写磁盘上的目录，数据库也必须保存在磁盘上
+	if config.DBOptions.InMemory || (config.DBOptions.FileSystem != nil && config.DBOptions.FileSystem != fio.OS) {
+		return ErrInvalidConfig
+	}
+	ids := make(map[string]bool, len(config.Peers))
+	for _, peer := range config.Peers {
+		if peer == "" || ids[peer] {
+			return ErrInvalidConfig
+		}
+		ids[peer] = true
+	}
+	if !ids[config.ID] {
+		return ErrInvalidConfig
+	}
+	return nil
+}
+
+// 打开数据目录中的数据库
+func (n *Node) openDB() error {
+	opts := n.config.DBOptions
+	opts.DirPath = filepath.Join(n.config.DirPath, dataDirName)
+	db, err := lingDB.Open(opts)
+	if err != nil {
+		return err
+	}
+	meta, err := db.Bucket(metaBucketName)
+	if err != nil {
+		_ = db.Close()
+		return err
+	}
+	n.db, n.meta = db, meta
+	return nil
+}
+
+// 从数据库中恢复已经应用的日志位置，已经应用的日志一定已经提交
+// 安装快照时在替换数据库之后、压缩日志之前崩溃，日志中还保留着和快照冲突的内容，需要重新压缩
+func (n *Node) recoverApplied() error {
+	value, err := n.meta.Get([]byte(appliedKey))
+	if err == lingDB.ErrKeyNotFound {
+		n.lastApplied, n.commitIndex = n.store.snapshotIndex(), n.store.snapshotIndex()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(value) != 16 {
+		return ErrLogCorrupted
+	}
+	index, term := binary.BigEndian.Uint64(value), binary.BigEndian.Uint64(value[8:])
+	if t, ok := n.store.termAt(index); !ok || t != term {
+		if err := n.store.compact(index, term); err != nil {
+			return err
+		}
+	}
+	// 快照中最后的几条日志可能是没有写入数据库的空日志
+	if index < n.store.snapshotIndex() {
+		index = n.store.snapshotIndex()
+	}
+	n.lastApplied, n.commitIndex = index, index
+	return nil
+}
+
+// Close 关闭节点，未完成的请求返回 ErrNodeClosed
+func (n *Node) Close() error {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return nil
+	}
+	n.closed = true
+	n.mu.Unlock()
+
+	n.cancel()
+	_ = n.config.Transport.Close()
+	// 等待正在处理的请求以及后台任务结束
+	n.handlerMu.Lock()
+	n.handlerMu.Unlock()
+	n.wg.Wait()
+
+	n.mu.Lock()
+	for index, p := range n.pending {
+		p.done <- ErrNodeClosed
+		delete(n.pending, index)
+	}
+	n.mu.Unlock()
+
+	err := n.store.close()
+	if dbErr := n.db.Close(); err == nil {
+		err = dbErr
+	}
+	return err
+}
+
+// ID 当前节点的 id
+func (n *Node) ID() string {
+	return n.config.ID
+}
+
+// Leader 当前节点已知的 leader，未知时为空
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leaderID
+}
+
+// IsLeader 当前节点是否为 leader
+func (n *Node) IsLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.state == stateLeader
+}
+
+// Put 写入数据，在集群中提交并应用到本地数据库之后返回，只能在 leader 上调用
+func (n *Node) Put(ctx context.Context, key []byte, value []byte) error {
+	b := NewBatch()
+	if err := b.Put(key, value); err != nil {
+		return err
+	}
+	return n.Apply(ctx, b)
+}
+
+// Delete 删除数据，只能在 leader 上调用
+func (n *Node) Delete(ctx context.Context, key []byte) error {
+	b := NewBatch()
+	if err := b.Delete(key); err != nil {
+		return err
+	}
+	return n.Apply(ctx, b)
+}
+
+// Apply 原子地提交一个批次中的所有写入，只能在 leader 上调用
+// ctx 被取消时返回 ctx.Err()，此时写入仍然可能在之后被提交
+func (n *Node) Apply(ctx context.Context, b *Batch) error {
+	if b.Len() == 0 {
+		return nil
+	}
+	return n.propose(ctx, EntryCommand, b.encode())
+}
+
+// Get 线性一致地读取数据，能够读到调用之前所有已经提交的写入，只能在 leader 上调用
+// 通过 read index 确认当前节点仍然是 leader，并等待之前提交的日志应用到本地之后再读取
+func (n *Node) Get(ctx context.Context, key []byte) ([]byte, error) {
+	readIndex, err := n.readIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := n.waitApplied(ctx, readIndex); err != nil {
+		return nil, err
+	}
+	n.dbMu.RLock()
+	defer n.dbMu.RUnlock()
+	return n.db.Get(key)
+}
+
+// 等待 index 之前的日志都应用到本地数据库
+func (n *Node) waitApplied(ctx context.Context, index uint64) error {
+	for {
+		n.mu.Lock()
+		if err := n.checkLocked(); err != nil {
+			n.mu.Unlock()
+			return err
+		}
+		if n.lastApplied >= index {
+			n.mu.Unlock()
+			return nil
+		}
+		wait := n.applied.wait()
+		n.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-n.ctx.Done():
+			return ErrNodeClosed
+		}
+	}
+}
+
+// 节点关闭或者出现无法恢复的错误时返回对应的错误
+func (n *Node) checkLocked() error {
+	if n.closed {
+		return ErrNodeClosed
+	}
+	return n.err
+}
+
+// 出现无法恢复的错误，节点不再参与选举和日志复制
+func (n *Node) failLocked(err error) {
+	if n.err == nil {
+		n.err = err
+	}
+	n.state = stateFollower
+	n.leaderID = ""
+}
+
+// 持续应用已经提交的日志
+func (n *Node) runApplier() {
+	defer n.wg.Done()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-n.commitCh:
+		}
+		for {
+			more, err := n.applyCommitted()
+			if err != nil {
+				n.mu.Lock()
+				n.failLocked(err)
+				n.mu.Unlock()
+				n.applied.notify()
+				return
+			}
+			if !more {
+				break
+			}
+		}
+	}
+}
+
+// 应用一部分已经提交但是还没有应用的日志，返回是否还有需要应用的日志
+func (n *Node) applyCommitted() (bool, error) {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	n.mu.Lock()
+	if n.lastApplied >= n.commitIndex || n.closed {
+		n.mu.Unlock()
+		return false, nil
+	}
+	lo := n.lastApplied + 1
+	hi := n.commitIndex + 1
+	if hi-lo > uint64(n.config.MaxAppendEntries) {
+		hi = lo + uint64(n.config.MaxAppendEntries)
+	}
+	entries := n.store.slice(lo, hi)
+	n.mu.Unlock()
+
+	for i := range entries {
+		entry := &entries[i]
+		err := n.applyEntry(entry)
+		if err != nil {
+			return false, err
+		}
+
+		n.mu.Lock()
+		n.lastApplied = entry.Index
+		if p, ok := n.pending[entry.Index]; ok {
+			// 同一个位置的日志被新的 leader 覆盖，提议没有被提交
+			if p.term != entry.Term {
+				p.done <- ErrProposalDropped
+			} else {
+				p.done <- nil
+			}
+			delete(n.pending, entry.Index)
+		}
+		n.mu.Unlock()
+	}
+	n.applied.notify()
+
+	if err := n.maybeSnapshot(); err != nil {
+		return false, err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.lastApplied < n.commitIndex, nil
+}
+
+// 将日志应用到数据库中，已经应用的位置和日志中的写入在同一个事务中提交
+// 数据库的写入无需同步持久化，崩溃之后可以重新应用 raft 日志中的内容
+func (n *Node) applyEntry(entry *Entry) error {
+	if entry.Type != EntryCommand {
+		return nil
+	}
+	b, err := decodeBatch(entry.Data)
+	if err != nil {
+		return err
+	}
+
+	n.dbMu.RLock()
+	defer n.dbMu.RUnlock()
+	wb := n.db.NewWriteBatch(lingDB.WriteBatchOptions{MaxBatchNum: uint(len(b.ops)) + 1})
+	for _, op := range b.ops {
+		switch op.typ {
+		case opPut:
+			err = wb.Put(op.key, op.value)
+		case opDelete:
+			err = wb.Delete(op.key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	applied := make([]byte, 16)
+	binary.BigEndian.PutUint64(applied, entry.Index)
+	binary.BigEndian.PutUint64(applied[8:], entry.Term)
+	if err := wb.BucketPut(n.meta, []byte(appliedKey), applied); err != nil {
+		return err
+	}
+	return wb.Commit()
+}
+
+// notifier 在状态变化时唤醒所有等待的协程
+type notifier struct {
+	mu *sync.Mutex
+	ch chan struct{}
+}
+
+func newNotifier() *notifier {
+	return &notifier{mu: new(sync.Mutex), ch: make(chan struct{})}
+}
+
+// 获取下一次状态变化时会被关闭的 channel
+func (nt *notifier) wait() <-chan struct{} {
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+	return nt.ch
+}
+
+func (nt *notifier) notify() {
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+	close(nt.ch)
+	nt.ch = make(chan struct{})
+}
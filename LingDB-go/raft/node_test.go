@@ -0,0 +1,330 @@
+package raft
+
+import (
+	lingDB "LingDB/LingDB-go"
+	"LingDB/LingDB-go/fio"
+	"LingDB/LingDB-go/utils"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCluster struct {
+	t       *testing.T
+	dir     string
+	ids     []string
+	network *MemoryNetwork
+	nodes   map[string]*Node
+	config  func(config *Config)
+}
+
+func newTestCluster(t *testing.T, size int, config func(config *Config)) *testCluster {
+	dir, _ := os.MkdirTemp("", "bitcask-go-raft")
+	c := &testCluster{t: t, dir: dir, network: NewMemoryNetwork(), nodes: make(map[string]*Node), config: config}
+	for i := 0; i < size; i++ {
+		c.ids = append(c.ids, fmt.Sprintf("node-%d", i))
+	}
+	for _, id := range c.ids {
+		c.start(id)
+	}
+	return c
+}
+
+func (c *testCluster) start(id string) *Node {
+	config := DefaultConfig
+	config.ID = id
+	config.Peers = c.ids
+	config.DirPath = filepath.Join(c.dir, id)
+	config.Transport = c.network.Transport(id)
+	config.ElectionTimeout = 150 * time.Millisecond
+	config.HeartbeatInterval = 30 * time.Millisecond
+	if c.config != nil {
+		c.config(&config)
+	}
+	node, err := Open(config)
+	assert.Nil(c.t, err)
+	c.nodes[id] = node
+	return node
+}
+
+func (c *testCluster) stop(id string) {
+	assert.Nil(c.t, c.nodes[id].Close())
+	delete(c.nodes, id)
+}
+
+func (c *testCluster) destroy() {
+	for id := range c.nodes {
+		c.stop(id)
+	}
+	_ = os.RemoveAll(c.dir)
+}
+
+// 等待除 excluded 之外的节点中选出 leader
+func (c *testCluster) waitLeader(excluded ...string) *Node {
+	var leader *Node
+	assert.Eventually(c.t, func() bool {
+		for id, node := range c.nodes {
+			if contains(excluded, id) {
+				continue
+			}
+			if node.IsLeader() {
+				leader = node
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+	return leader
+}
+
+// 等待所有节点都应用了 leader 已经应用的日志，并且数据库中的数据一致
+func (c *testCluster) waitConsistent(leader *Node) {
+	leader.mu.Lock()
+	applied := leader.lastApplied
+	leader.mu.Unlock()
+	for _, node := range c.nodes {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		assert.Nil(c.t, node.waitApplied(ctx, applied))
+		cancel()
+		assert.Equal(c.t, dumpNode(leader), dumpNode(node), node.ID())
+	}
+}
+
+func dumpNode(node *Node) map[string]string {
+	node.dbMu.RLock()
+	defer node.dbMu.RUnlock()
+	result := make(map[string]string)
+	_ = node.db.Fold(func(key []byte, value []byte) bool {
+		result[string(key)] = string(value)
+		return true
+	})
+	return result
+}
+
+func contains(ids []string, id string) bool {
+	for _, s := range ids {
+		if s == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestNode_SingleNode(t *testing.T) {
+	c := newTestCluster(t, 1, nil)
+	defer c.destroy()
+	ctx := context.Background()
+	node := c.waitLeader()
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, node.Put(ctx, utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, node.Delete(ctx, utils.GetTestKey(0)))
+	b := NewBatch()
+	assert.Nil(t, b.Put(utils.GetTestKey(1), []byte("batch")))
+	assert.Nil(t, b.Delete(utils.GetTestKey(2)))
+	assert.Nil(t, b.Put(utils.GetTestKey(100), []byte("batch")))
+	assert.Equal(t, lingDB.ErrKeyIsEmpty, b.Put(nil, nil))
+	assert.Nil(t, node.Apply(ctx, b))
+
+	_, err := node.Get(ctx, utils.GetTestKey(0))
+	assert.Equal(t, lingDB.ErrKeyNotFound, err)
+	value, err := node.Get(ctx, utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("batch"), value)
+	_, err = node.Get(ctx, utils.GetTestKey(2))
+	assert.Equal(t, lingDB.ErrKeyNotFound, err)
+
+	// 重启之后从数据库中恢复已经应用的位置，不会重复应用日志
+	expected := dumpNode(node)
+	node.mu.Lock()
+	applied := node.lastApplied
+	node.mu.Unlock()
+	c.stop(node.ID())
+	node = c.start(node.ID())
+	assert.Equal(t, expected, dumpNode(node))
+	node.mu.Lock()
+	assert.True(t, node.lastApplied >= applied-1)
+	node.mu.Unlock()
+
+	node = c.waitLeader()
+	value, err = node.Get(ctx, utils.GetTestKey(100))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("batch"), value)
+}
+
+func TestNode_Replication(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	defer c.destroy()
+	ctx := context.Background()
+	leader := c.waitLeader()
+
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, leader.Put(ctx, utils.GetTestKey(i), utils.RandomValue(32)))
+	}
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, leader.Delete(ctx, utils.GetTestKey(i)))
+	}
+	c.waitConsistent(leader)
+	assert.Equal(t, 150, len(dumpNode(leader)))
+
+	// 只有 leader 能够处理读写请求
+	for _, node := range c.nodes {
+		if node == leader {
+			continue
+		}
+		assert.Equal(t, leader.ID(), node.Leader())
+		assert.Equal(t, ErrNotLeader, node.Put(ctx, []byte("key"), []byte("value")))
+		_, err := node.Get(ctx, utils.GetTestKey(100))
+		assert.Equal(t, ErrNotLeader, err)
+	}
+}
+
+func TestNode_LeaderFailover(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	defer c.destroy()
+	ctx := context.Background()
+	oldLeader := c.waitLeader()
+	assert.Nil(t, oldLeader.Put(ctx, []byte("key"), []byte("v1")))
+
+	// 旧的 leader 被隔离之后，剩下的节点选出新的 leader 并继续提交
+	c.network.Disconnect(oldLeader.ID())
+	leader := c.waitLeader(oldLeader.ID())
+	assert.Nil(t, leader.Put(ctx, []byte("key"), []byte("v2")))
+	value, err := leader.Get(ctx, []byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), value)
+
+	// 旧的 leader 无法提交写入，也无法确认自己仍然是 leader
+	timeoutCtx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	err = oldLeader.Put(timeoutCtx, []byte("key"), []byte("stale"))
+	cancel()
+	assert.NotNil(t, err)
+	timeoutCtx, cancel = context.WithTimeout(ctx, 300*time.Millisecond)
+	_, err = oldLeader.Get(timeoutCtx, []byte("key"))
+	cancel()
+	assert.NotNil(t, err)
+
+	// 恢复连接之后旧的 leader 未提交的日志被覆盖
+	c.network.Connect(oldLeader.ID())
+	leader = c.waitLeader()
+	assert.Nil(t, leader.Put(ctx, []byte("other"), []byte("value")))
+	c.waitConsistent(leader)
+	assert.Equal(t, "v2", dumpNode(oldLeader)["key"])
+}
+
+func TestNode_Snapshot(t *testing.T) {
+	c := newTestCluster(t, 3, func(config *Config) {
+		config.SnapshotThreshold = 50
+		config.MaxAppendEntries = 16
+		config.DBOptions.DataFileSize = 16 * 1024
+	})
+	defer c.destroy()
+	ctx := context.Background()
+	leader := c.waitLeader()
+
+	// 落后的节点需要的日志已经被压缩，通过快照追上 leader
+	// 恢复连接时可能发生重新选举，所有节点都会生成快照，新的 leader 同样需要发送快照
+	var lagging *Node
+	for _, node := range c.nodes {
+		if node != leader {
+			lagging = node
+			break
+		}
+	}
+	c.network.Disconnect(lagging.ID())
+	for i := 0; i < 300; i++ {
+		assert.Nil(t, leader.Put(ctx, utils.GetTestKey(i%100), utils.RandomValue(64)))
+	}
+	leader.mu.Lock()
+	assert.True(t, leader.store.snapshotIndex() > 0)
+	leader.mu.Unlock()
+	_, err := os.Stat(filepath.Join(leader.config.DirPath, snapshotDirName))
+	assert.Nil(t, err)
+
+	c.network.Connect(lagging.ID())
+	assert.Nil(t, leader.Put(ctx, []byte("after-snapshot"), []byte("value")))
+	c.waitConsistent(leader)
+	lagging.mu.Lock()
+	assert.True(t, lagging.store.snapshotIndex() > 0)
+	lagging.mu.Unlock()
+
+	// 重启之后安装的快照依然有效
+	id := lagging.ID()
+	c.stop(id)
+	lagging = c.start(id)
+	leader = c.waitLeader()
+	assert.Nil(t, leader.Put(ctx, []byte("after-restart"), []byte("value")))
+	c.waitConsistent(leader)
+	assert.Equal(t, 102, len(dumpNode(lagging)))
+}
+
+func TestNode_Restart(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	defer c.destroy()
+	ctx := context.Background()
+	leader := c.waitLeader()
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, leader.Put(ctx, utils.GetTestKey(i), utils.RandomValue(32)))
+	}
+
+	// 重启整个集群之后，数据和日志都可以恢复
+	for _, id := range c.ids {
+		c.stop(id)
+	}
+	for _, id := range c.ids {
+		c.start(id)
+	}
+	leader = c.waitLeader()
+	assert.Nil(t, leader.Put(ctx, []byte("key"), []byte("value")))
+	c.waitConsistent(leader)
+	assert.Equal(t, 51, len(dumpNode(leader)))
+}
+
+func TestOpen_InvalidConfig(t *testing.T) {
+	network := NewMemoryNetwork()
+	config := DefaultConfig
+	config.ID = "a"
+	config.Peers = []string{"b", "c"}
+	config.DirPath = filepath.Join(os.TempDir(), "bitcask-go-raft-invalid")
+	config.Transport = network.Transport("a")
+	_, err := Open(config)
+	assert.Equal(t, ErrInvalidConfig, err)
+
+	config.Peers = []string{"a", "b"}
+	config.HeartbeatInterval = config.ElectionTimeout
+	_, err = Open(config)
+	assert.Equal(t, ErrInvalidConfig, err)
+
+	config.HeartbeatInterval = DefaultConfig.HeartbeatInterval
+	config.DBOptions.InMemory = true
+	_, err = Open(config)
+	assert.Equal(t, ErrInvalidConfig, err)
+
+	config.DBOptions.InMemory = false
+	config.DBOptions.FileSystem = fio.NewMemFileSystem()
+	_, err = Open(config)
+	assert.Equal(t, ErrInvalidConfig, err)
+}
+
+func TestBatch_Encode(t *testing.T) {
+	b := NewBatch()
+	assert.Nil(t, b.Put([]byte("key-1"), []byte("value-1")))
+	assert.Nil(t, b.Delete([]byte("key-2")))
+	assert.Nil(t, b.Put([]byte("key-3"), nil))
+
+	decoded, err := decodeBatch(b.encode())
+	assert.Nil(t, err)
+	assert.Equal(t, 3, decoded.Len())
+	assert.Equal(t, opDelete, decoded.ops[1].typ)
+	assert.Equal(t, []byte("key-2"), decoded.ops[1].key)
+	assert.Equal(t, []byte("value-1"), decoded.ops[0].value)
+
+	buf := b.encode()
+	_, err = decodeBatch(buf[:len(buf)-1])
+	assert.Equal(t, ErrInvalidCommand, err)
+}
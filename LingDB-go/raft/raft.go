@@ -0,0 +1,559 @@
+package raft
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// 重置选举超时的时间点，超时时间随机化以避免多个节点同时发起选举
+func (n *Node) resetElectionDeadline() {
+	timeout := n.config.ElectionTimeout + time.Duration(rand.Int63n(int64(n.config.ElectionTimeout)))
+	n.deadline = time.Now().Add(timeout)
+}
+
+// 集群中达成共识需要的节点数量
+func (n *Node) quorum() int {
+	return (len(n.peers)+1)/2 + 1
+}
+
+// 发送请求时使用的 context，节点关闭时取消
+func (n *Node) rpcContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(n.ctx, timeout)
+}
+
+// 定期检查选举是否超时
+func (n *Node) runTicker() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.config.HeartbeatInterval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		n.mu.Lock()
+		timeout := n.state != stateLeader && n.err == nil && time.Now().After(n.deadline)
+		if n.state == stateLeader && !n.checkQuorumLocked() {
+			// 无法和多数节点通信的 leader 主动退位，避免被隔离之后继续接受请求
+			n.stepDownLocked(n.currentTerm)
+			n.leaderID = ""
+		}
+		n.mu.Unlock()
+		if timeout {
+			n.startElection()
+		}
+	}
+}
+
+// leader 在选举超时时间内是否收到过多数节点的响应
+func (n *Node) checkQuorumLocked() bool {
+	active := 1
+	for _, peer := range n.peers {
+		if time.Since(n.lastAck[peer]) < n.config.ElectionTimeout {
+			active++
+		}
+	}
+	return active >= n.quorum()
+}
+
+// 选举超时后先发起预投票，多数节点同意之后才增加任期发起正式的选举
+// 被隔离的节点无法通过预投票，恢复连接之后不会因为任期更大而打断现有的 leader
+func (n *Node) startElection() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.state == stateLeader || n.checkLocked() != nil {
+		return
+	}
+	n.resetElectionDeadline()
+	term := n.currentTerm
+	n.requestVotesLocked(true, func() {
+		// 预投票期间任期发生了变化，放弃这次选举
+		if n.state == stateLeader || n.currentTerm != term {
+			return
+		}
+		n.becomeCandidateLocked()
+	})
+}
+
+// 成为候选人，增加任期并向其他节点请求投票
+func (n *Node) becomeCandidateLocked() {
+	if err := n.store.setHardState(n.currentTerm+1, n.config.ID); err != nil {
+		n.failLocked(err)
+		return
+	}
+	n.currentTerm, n.votedFor = n.currentTerm+1, n.config.ID
+	n.state = stateCandidate
+	n.leaderID = ""
+	n.resetElectionDeadline()
+	term := n.currentTerm
+	n.requestVotesLocked(false, func() {
+		if n.state == stateCandidate && n.currentTerm == term {
+			n.becomeLeaderLocked()
+		}
+	})
+}
+
+// 向其他节点请求投票，得到多数节点的投票之后持有锁调用 elected
+func (n *Node) requestVotesLocked(preVote bool, elected func()) {
+	req := &RequestVoteRequest{
+		Term:         n.currentTerm,
+		CandidateID:  n.config.ID,
+		LastLogIndex: n.store.lastIndex(),
+		LastLogTerm:  n.store.lastTerm(),
+		PreVote:      preVote,
+	}
+	if preVote {
+		req.Term++
+	}
+	votes := 1
+	if votes >= n.quorum() {
+		elected()
+		return
+	}
+	for _, peer := range n.peers {
+		n.wg.Add(1)
+		go func(peer string) {
+			defer n.wg.Done()
+			ctx, cancel := n.rpcContext(n.config.ElectionTimeout)
+			defer cancel()
+			resp, err := n.config.Transport.RequestVote(ctx, peer, req)
+			if err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if resp.Term > n.currentTerm {
+				n.stepDownLocked(resp.Term)
+				return
+			}
+			if !resp.VoteGranted {
+				return
+			}
+			votes++
+			if votes == n.quorum() {
+				elected()
+			}
+		}(peer)
+	}
+}
+
+// 当选为 leader，追加一条当前任期的空日志，提交之后就能确定之前任期的日志都已经提交
+func (n *Node) becomeLeaderLocked() {
+	n.state = stateLeader
+	n.leaderID = n.config.ID
+	for _, peer := range n.peers {
+		n.nextIndex[peer] = n.store.lastIndex() + 1
+		n.matchIndex[peer] = 0
+		n.lastAck[peer] = time.Now()
+	}
+	if _, err := n.appendLocked(EntryNoop, nil); err != nil {
+		n.failLocked(err)
+		return
+	}
+	n.triggerReplication()
+}
+
+// 发现更大的任期或者其他 leader 时转为 follower
+func (n *Node) stepDownLocked(term uint64) {
+	if term > n.currentTerm {
+		if err := n.store.setHardState(term, ""); err != nil {
+			n.failLocked(err)
+			return
+		}
+		n.currentTerm, n.votedFor = term, ""
+		n.leaderID = ""
+	}
+	if n.state == stateLeader {
+		n.resetElectionDeadline()
+	}
+	n.state = stateFollower
+}
+
+// leader 在日志末尾追加一条当前任期的日志
+func (n *Node) appendLocked(typ EntryType, data []byte) (uint64, error) {
+	entry := Entry{Index: n.store.lastIndex() + 1, Term: n.currentTerm, Type: typ, Data: data}
+	if err := n.store.append([]Entry{entry}); err != nil {
+		return 0, err
+	}
+	n.advanceCommitLocked()
+	return entry.Index, nil
+}
+
+// 提交一条日志，应用到本地数据库之后返回
+func (n *Node) propose(ctx context.Context, typ EntryType, data []byte) error {
+	n.mu.Lock()
+	if err := n.checkLocked(); err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	if n.state != stateLeader {
+		n.mu.Unlock()
+		return ErrNotLeader
+	}
+	index, err := n.appendLocked(typ, data)
+	if err != nil {
+		n.failLocked(err)
+		n.mu.Unlock()
+		return err
+	}
+	p := &proposal{term: n.currentTerm, done: make(chan error, 1)}
+	n.pending[index] = p
+	n.mu.Unlock()
+	n.triggerReplication()
+
+	select {
+	case err := <-p.done:
+		return err
+	case <-ctx.Done():
+		n.mu.Lock()
+		delete(n.pending, index)
+		n.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// leader 根据各个节点复制的进度推进提交位置，只能直接提交当前任期的日志
+func (n *Node) advanceCommitLocked() {
+	if n.state != stateLeader {
+		return
+	}
+	for index := n.store.lastIndex(); index > n.commitIndex; index-- {
+		if term, _ := n.store.termAt(index); term != n.currentTerm {
+			return
+		}
+		count := 1
+		for _, peer := range n.peers {
+			if n.matchIndex[peer] >= index {
+				count++
+			}
+		}
+		if count >= n.quorum() {
+			n.commitIndex = index
+			n.notifyCommit()
+			return
+		}
+	}
+}
+
+func (n *Node) notifyCommit() {
+	select {
+	case n.commitCh <- struct{}{}:
+	default:
+	}
+}
+
+func (n *Node) triggerReplication() {
+	for _, ch := range n.replicateCh {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// 向 peer 复制日志，没有新日志时定期发送心跳
+func (n *Node) runReplicator(peer string) {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.config.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-n.replicateCh[peer]:
+		case <-ticker.C:
+		}
+		n.replicateTo(peer)
+	}
+}
+
+// 发送 peer 还没有的日志，直到 peer 追上 leader 或者请求失败
+func (n *Node) replicateTo(peer string) {
+	for {
+		n.mu.Lock()
+		if n.state != stateLeader || n.checkLocked() != nil {
+			n.mu.Unlock()
+			return
+		}
+		next := n.nextIndex[peer]
+		// 需要的日志已经被压缩，只能发送快照
+		if next <= n.store.snapshotIndex() {
+			term := n.currentTerm
+			n.mu.Unlock()
+			n.sendSnapshot(peer, term)
+			return
+		}
+		prevTerm, _ := n.store.termAt(next - 1)
+		last := n.store.lastIndex()
+		if last-next+1 > uint64(n.config.MaxAppendEntries) {
+			last = next + uint64(n.config.MaxAppendEntries) - 1
+		}
+		req := &AppendEntriesRequest{
+			Term:         n.currentTerm,
+			LeaderID:     n.config.ID,
+			PrevLogIndex: next - 1,
+			PrevLogTerm:  prevTerm,
+			Entries:      n.store.slice(next, last+1),
+			LeaderCommit: n.commitIndex,
+		}
+		n.mu.Unlock()
+
+		ctx, cancel := n.rpcContext(n.config.ElectionTimeout)
+		resp, err := n.config.Transport.AppendEntries(ctx, peer, req)
+		cancel()
+		if err != nil {
+			return
+		}
+
+		n.mu.Lock()
+		if resp.Term > n.currentTerm {
+			n.stepDownLocked(resp.Term)
+			n.mu.Unlock()
+			return
+		}
+		if n.state != stateLeader || n.currentTerm != req.Term {
+			n.mu.Unlock()
+			return
+		}
+		n.lastAck[peer] = time.Now()
+		if resp.Success {
+			if resp.MatchIndex > n.matchIndex[peer] {
+				n.matchIndex[peer] = resp.MatchIndex
+				n.advanceCommitLocked()
+			}
+			n.nextIndex[peer] = n.matchIndex[peer] + 1
+		} else {
+			next = resp.ConflictIndex
+			if next <= n.matchIndex[peer] {
+				next = n.matchIndex[peer] + 1
+			}
+			n.nextIndex[peer] = next
+		}
+		done := resp.Success && n.nextIndex[peer] > n.store.lastIndex()
+		n.mu.Unlock()
+		if done {
+			return
+		}
+	}
+}
+
+// 获取 read index，并通过一轮心跳确认当前节点仍然是 leader
+// 返回的位置之前的日志应用之后，读取到的数据包含了调用之前所有已经提交的写入
+func (n *Node) readIndex(ctx context.Context) (uint64, error) {
+	var readIndex, term uint64
+	for {
+		n.mu.Lock()
+		if err := n.checkLocked(); err != nil {
+			n.mu.Unlock()
+			return 0, err
+		}
+		if n.state != stateLeader {
+			n.mu.Unlock()
+			return 0, ErrNotLeader
+		}
+		// 新的 leader 提交当前任期的日志之后才能确定之前任期的日志中哪些已经提交
+		if t, _ := n.store.termAt(n.commitIndex); t == n.currentTerm {
+			readIndex, term = n.commitIndex, n.currentTerm
+			n.mu.Unlock()
+			break
+		}
+		wait := n.applied.wait()
+		n.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-n.ctx.Done():
+			return 0, ErrNodeClosed
+		}
+	}
+
+	if err := n.confirmLeadership(ctx, term); err != nil {
+		return 0, err
+	}
+	return readIndex, nil
+}
+
+// 向其他节点发送心跳，多数节点仍然认可当前任期时说明没有更新的 leader
+func (n *Node) confirmLeadership(ctx context.Context, term uint64) error {
+	acks := 1
+	if acks >= n.quorum() {
+		return nil
+	}
+	// 不携带日志的心跳不会改变其他节点的日志以及提交位置
+	req := &AppendEntriesRequest{Term: term, LeaderID: n.config.ID}
+	results := make(chan bool, len(n.peers))
+	for _, peer := range n.peers {
+		n.wg.Add(1)
+		go func(peer string) {
+			defer n.wg.Done()
+			rpcCtx, cancel := n.rpcContext(n.config.ElectionTimeout)
+			defer cancel()
+			resp, err := n.config.Transport.AppendEntries(rpcCtx, peer, req)
+			if err != nil {
+				results <- false
+				return
+			}
+			if resp.Term > term {
+				n.mu.Lock()
+				n.stepDownLocked(resp.Term)
+				n.mu.Unlock()
+			}
+			results <- resp.Term == term && resp.Success
+		}(peer)
+	}
+
+	for range n.peers {
+		select {
+		case ok := <-results:
+			if ok {
+				acks++
+			}
+			if acks >= n.quorum() {
+				return nil
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return ErrNotLeader
+}
+
+// HandleRequestVote 处理候选人的投票请求
+func (n *Node) HandleRequestVote(req *RequestVoteRequest) (*RequestVoteResponse, error) {
+	n.handlerMu.RLock()
+	defer n.handlerMu.RUnlock()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := n.checkLocked(); err != nil {
+		return nil, err
+	}
+
+	// 候选人的日志至少和当前节点一样新时才能投票
+	upToDate := req.LastLogTerm > n.store.lastTerm() ||
+		(req.LastLogTerm == n.store.lastTerm() && req.LastLogIndex >= n.store.lastIndex())
+	if req.PreVote {
+		// 最近收到过 leader 的请求时，说明 leader 仍然有效，拒绝预投票
+		granted := req.Term > n.currentTerm && upToDate && !n.leaderActiveLocked()
+		return &RequestVoteResponse{Term: n.currentTerm, VoteGranted: granted}, nil
+	}
+
+	if req.Term > n.currentTerm {
+		n.stepDownLocked(req.Term)
+	}
+	resp := &RequestVoteResponse{Term: n.currentTerm}
+	if req.Term < n.currentTerm {
+		return resp, nil
+	}
+	if (n.votedFor == "" || n.votedFor == req.CandidateID) && upToDate {
+		if err := n.store.setHardState(n.currentTerm, req.CandidateID); err != nil {
+			n.failLocked(err)
+			return nil, err
+		}
+		n.votedFor = req.CandidateID
+		n.resetElectionDeadline()
+		resp.VoteGranted = true
+	}
+	return resp, nil
+}
+
+// HandleAppendEntries 处理 leader 的日志复制请求以及心跳
+func (n *Node) HandleAppendEntries(req *AppendEntriesRequest) (*AppendEntriesResponse, error) {
+	n.handlerMu.RLock()
+	defer n.handlerMu.RUnlock()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := n.checkLocked(); err != nil {
+		return nil, err
+	}
+
+	resp := &AppendEntriesResponse{Term: n.currentTerm}
+	if req.Term < n.currentTerm {
+		return resp, nil
+	}
+	n.acceptLeaderLocked(req.Term, req.LeaderID)
+	resp.Term = n.currentTerm
+
+	// 快照之前的日志都已经提交，跳过这部分日志
+	prevIndex, prevTerm, entries := req.PrevLogIndex, req.PrevLogTerm, req.Entries
+	if prevIndex < n.store.snapshotIndex() {
+		skip := n.store.snapshotIndex() - prevIndex
+		if uint64(len(entries)) <= skip {
+			entries = nil
+		} else {
+			entries = entries[skip:]
+		}
+		prevIndex, prevTerm = n.store.snapshotIndex(), n.store.snapshotTerm()
+	}
+	if prevIndex > n.store.lastIndex() {
+		resp.ConflictIndex = n.store.lastIndex() + 1
+		return resp, nil
+	}
+	if term, _ := n.store.termAt(prevIndex); term != prevTerm {
+		// 跳过冲突任期中的所有日志，leader 从这个任期的第一条日志开始重新发送
+		conflict := prevIndex
+		for conflict-1 > n.store.snapshotIndex() {
+			if t, _ := n.store.termAt(conflict - 1); t != term {
+				break
+			}
+			conflict--
+		}
+		resp.ConflictIndex = conflict
+		return resp, nil
+	}
+
+	// 跳过已经存在的日志，删除冲突的日志之后追加新的日志
+	for i, entry := range entries {
+		if entry.Index <= n.store.lastIndex() {
+			if term, _ := n.store.termAt(entry.Index); term == entry.Term {
+				continue
+			}
+			if err := n.store.truncate(entry.Index); err != nil {
+				n.failLocked(err)
+				return nil, err
+			}
+		}
+		if err := n.store.append(entries[i:]); err != nil {
+			n.failLocked(err)
+			return nil, err
+		}
+		break
+	}
+
+	lastNew := prevIndex + uint64(len(entries))
+	if req.LeaderCommit > n.commitIndex {
+		commitIndex := req.LeaderCommit
+		if commitIndex > lastNew {
+			commitIndex = lastNew
+		}
+		if commitIndex > n.commitIndex {
+			n.commitIndex = commitIndex
+			n.notifyCommit()
+		}
+	}
+	resp.Success = true
+	resp.MatchIndex = lastNew
+	return resp, nil
+}
+
+// 收到当前任期 leader 的请求，转为 follower 并重置选举超时
+func (n *Node) acceptLeaderLocked(term uint64, leaderID string) {
+	if term > n.currentTerm || n.state != stateFollower {
+		n.stepDownLocked(term)
+	}
+	n.leaderID = leaderID
+	n.lastContact = time.Now()
+	n.resetElectionDeadline()
+}
+
+// 当前节点是 leader 或者在选举超时时间内收到过 leader 的请求
+func (n *Node) leaderActiveLocked() bool {
+	if n.state == stateLeader {
+		return true
+	}
+	return n.leaderID != "" && time.Since(n.lastContact) < n.config.ElectionTimeout
+}
@@ -0,0 +1,327 @@
+package raft
+
+import (
+	lingDB "LingDB/LingDB-go"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	tempDirSuffix = ".tmp"
+	oldDirSuffix  = ".old"
+)
+
+// 快照之后应用的日志数量达到阈值时生成新的快照，并压缩快照之前的日志，调用方需要持有 applyMu
+// 快照复用数据库的 merge 流程，快照目录中保存 merge 之后的数据文件以及 hint 文件
+func (n *Node) maybeSnapshot() error {
+	n.mu.Lock()
+	index := n.lastApplied
+	term, _ := n.store.termAt(index)
+	needed := n.config.SnapshotThreshold > 0 && index-n.store.snapshotIndex() >= n.config.SnapshotThreshold
+	n.mu.Unlock()
+	if !needed {
+		return nil
+	}
+
+	// 持有 applyMu 时没有新的写入，快照中恰好包含 index 之前的所有日志
+	snapshotPath := filepath.Join(n.config.DirPath, snapshotDirName)
+	tempPath := snapshotPath + tempDirSuffix
+	if err := os.RemoveAll(tempPath); err != nil {
+		return err
+	}
+	n.dbMu.RLock()
+	err := n.db.Snapshot(n.ctx, tempPath)
+	n.dbMu.RUnlock()
+	if err == lingDB.ErrMergeIsProgress || (err != nil && n.ctx.Err() != nil) {
+		// 数据库正在 merge 或者节点正在关闭，之后再生成快照
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	n.snapshotMu.Lock()
+	defer n.snapshotMu.Unlock()
+	if err := replaceDir(tempPath, snapshotPath); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.store.compact(index, term)
+}
+
+// 向落后的 peer 发送快照，发送成功之后从快照之后的日志开始复制
+func (n *Node) sendSnapshot(peer string, term uint64) {
+	n.snapshotMu.RLock()
+	n.mu.Lock()
+	index, indexTerm := n.store.snapshotIndex(), n.store.snapshotTerm()
+	n.mu.Unlock()
+	files, err := readSnapshotFiles(filepath.Join(n.config.DirPath, snapshotDirName))
+	n.snapshotMu.RUnlock()
+	if err != nil {
+		return
+	}
+
+	req := &InstallSnapshotRequest{
+		Term:              term,
+		LeaderID:          n.config.ID,
+		LastIncludedIndex: index,
+		LastIncludedTerm:  indexTerm,
+		Files:             files,
+	}
+	// 快照可能比较大，给予更长的超时时间
+	ctx, cancel := n.rpcContext(10 * n.config.ElectionTimeout)
+	resp, err := n.config.Transport.InstallSnapshot(ctx, peer, req)
+	cancel()
+	if err != nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if resp.Term > n.currentTerm {
+		n.stepDownLocked(resp.Term)
+		return
+	}
+	if n.state != stateLeader || n.currentTerm != term {
+		return
+	}
+	n.lastAck[peer] = time.Now()
+	if index > n.matchIndex[peer] {
+		n.matchIndex[peer] = index
+	}
+	n.nextIndex[peer] = n.matchIndex[peer] + 1
+	n.triggerReplication()
+}
+
+// HandleInstallSnapshot 处理 leader 发送的快照，使用快照替换本地的数据库
+func (n *Node) HandleInstallSnapshot(req *InstallSnapshotRequest) (*InstallSnapshotResponse, error) {
+	n.handlerMu.RLock()
+	defer n.handlerMu.RUnlock()
+	n.mu.Lock()
+	if err := n.checkLocked(); err != nil {
+		n.mu.Unlock()
+		return nil, err
+	}
+	resp := &InstallSnapshotResponse{Term: n.currentTerm}
+	if req.Term < n.currentTerm {
+		n.mu.Unlock()
+		return resp, nil
+	}
+	n.acceptLeaderLocked(req.Term, req.LeaderID)
+	resp.Term = n.currentTerm
+	n.mu.Unlock()
+
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+	// 已经应用了快照中的所有日志，无需安装
+	n.mu.Lock()
+	installed := req.LastIncludedIndex <= n.lastApplied
+	n.mu.Unlock()
+	if installed {
+		return resp, nil
+	}
+
+	if err := n.installSnapshot(req); err != nil {
+		n.mu.Lock()
+		n.failLocked(err)
+		n.mu.Unlock()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// 保存快照并替换数据库，之后压缩日志，调用方需要持有 applyMu
+func (n *Node) installSnapshot(req *InstallSnapshotRequest) error {
+	n.snapshotMu.Lock()
+	defer n.snapshotMu.Unlock()
+
+	snapshotPath := filepath.Join(n.config.DirPath, snapshotDirName)
+	tempPath := snapshotPath + tempDirSuffix
+	if err := writeSnapshotFiles(tempPath, req.Files); err != nil {
+		return err
+	}
+	if err := replaceDir(tempPath, snapshotPath); err != nil {
+		return err
+	}
+
+	// 先复制一份快照，关闭数据库之后再替换数据目录
+	dataPath := filepath.Join(n.config.DirPath, dataDirName)
+	if err := os.RemoveAll(dataPath + tempDirSuffix); err != nil {
+		return err
+	}
+	if err := copyDir(snapshotPath, dataPath+tempDirSuffix); err != nil {
+		return err
+	}
+	n.dbMu.Lock()
+	defer n.dbMu.Unlock()
+	if err := n.db.Close(); err != nil {
+		return err
+	}
+	if err := replaceDir(dataPath+tempDirSuffix, dataPath); err != nil {
+		return err
+	}
+	// 数据库中未完成的 merge 针对的是替换之前的数据文件
+	if err := os.RemoveAll(dataPath + "-merge"); err != nil {
+		return err
+	}
+	if err := n.openDB(); err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := n.store.compact(req.LastIncludedIndex, req.LastIncludedTerm); err != nil {
+		return err
+	}
+	n.lastApplied = req.LastIncludedIndex
+	if n.commitIndex < n.lastApplied {
+		n.commitIndex = n.lastApplied
+	}
+	// 快照中的日志不会再逐条应用，无法确定等待中的提议是否被提交
+	for index, p := range n.pending {
+		if index <= n.lastApplied {
+			p.done <- ErrProposalUnknown
+			delete(n.pending, index)
+		}
+	}
+	n.applied.notify()
+	n.notifyCommit()
+	return nil
+}
+
+// 读取快照目录中的所有文件
+func readSnapshotFiles(dirPath string) ([]SnapshotFile, error) {
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrSnapshotNotFound
+		}
+		return nil, err
+	}
+	var files []SnapshotFile
+	for _, entry := range dirEntries {
+		if entry.IsDir() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dirPath, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, SnapshotFile{Name: entry.Name(), Data: content})
+	}
+	return files, nil
+}
+
+// 将快照文件写入到 dirPath 目录中，并持久化
+func writeSnapshotFiles(dirPath string, files []SnapshotFile) error {
+	if err := os.RemoveAll(dirPath); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dirPath, os.ModePerm); err != nil {
+		return err
+	}
+	for _, file := range files {
+		// 文件名不能包含路径，避免写到快照目录之外
+		if file.Name != filepath.Base(file.Name) || file.Name == "." || file.Name == ".." {
+			return ErrInvalidSnapshot
+		}
+		if err := writeFileSync(filepath.Join(dirPath, file.Name), file.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 复制 src 目录中的所有文件到 dst 目录
+func copyDir(src, dst string) error {
+	if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+		return err
+	}
+	dirEntries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range dirEntries {
+		if entry.IsDir() {
+			continue
+		}
+		if err := copyFile(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func writeFileSync(name string, content []byte) error {
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(content); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// 使用 src 目录替换 dst 目录，旧的目录先重命名，替换完成之后再删除
+// 中途崩溃时通过 recoverDir 恢复到替换之前或者之后的状态
+func replaceDir(src, dst string) error {
+	old := dst + oldDirSuffix
+	if err := os.RemoveAll(old); err != nil {
+		return err
+	}
+	if _, err := os.Stat(dst); err == nil {
+		if err := os.Rename(dst, old); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+// 恢复 replaceDir 中途崩溃的目录，并清理临时目录
+func recoverDir(dst string) error {
+	old := dst + oldDirSuffix
+	if _, err := os.Stat(dst); os.IsNotExist(err) {
+		if _, err := os.Stat(old); err == nil {
+			if err := os.Rename(old, dst); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.RemoveAll(old); err != nil {
+		return err
+	}
+	return os.RemoveAll(dst + tempDirSuffix)
+}
@@ -0,0 +1,242 @@
+package raft
+
+import (
+	lingDB "LingDB/LingDB-go"
+	"encoding/binary"
+)
+
+const (
+	hardStateKey   = "raft/hardstate"
+	snapshotKey    = "raft/snapshot"
+	entryKeyPrefix = "raft/log/"
+)
+
+// 持久化 raft 的任期、投票以及日志，底层使用一个独立的 LingDB 实例
+// 日志同时在内存中保存一份，entries[0] 为快照中的最后一条日志，只记录了 Index 和 Term
+// 调用方需要保证并发安全
+type logStore struct {
+	db       *lingDB.DB
+	entries  []Entry
+	term     uint64
+	votedFor string
+}
+
+// 打开 dirPath 目录中的 raft 日志，日志中包含写入的数据，使用和数据库相同的加密 key
+func openLogStore(dirPath string, dbOptions lingDB.Options) (*logStore, error) {
+	opts := lingDB.DefaultOptions
+	opts.DirPath = dirPath
+	opts.EncryptionKey = dbOptions.EncryptionKey
+	opts.OldEncryptionKeys = dbOptions.OldEncryptionKeys
+	opts.DataFileSize = 64 * 1024 * 1024
+	db, err := lingDB.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	s := &logStore{db: db, entries: []Entry{{}}}
+	if err := s.load(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *logStore) load() error {
+	if value, err := s.db.Get([]byte(hardStateKey)); err == nil {
+		term, n := binary.Uvarint(value)
+		if n <= 0 {
+			return ErrLogCorrupted
+		}
+		s.term, s.votedFor = term, string(value[n:])
+	} else if err != lingDB.ErrKeyNotFound {
+		return err
+	}
+	if value, err := s.db.Get([]byte(snapshotKey)); err == nil {
+		index, n := binary.Uvarint(value)
+		if n <= 0 {
+			return ErrLogCorrupted
+		}
+		term, m := binary.Uvarint(value[n:])
+		if m <= 0 {
+			return ErrLogCorrupted
+		}
+		s.entries[0] = Entry{Index: index, Term: term}
+	} else if err != lingDB.ErrKeyNotFound {
+		return err
+	}
+
+	// 按照 index 从小到大加载日志，压缩时崩溃可能留下快照之前的日志，直接忽略
+	iter := s.db.NewIterator(lingDB.IteratorOptions{Prefix: []byte(entryKeyPrefix)})
+	defer iter.Close()
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		index := binary.BigEndian.Uint64(iter.Key()[len(entryKeyPrefix):])
+		if index <= s.entries[0].Index {
+			continue
+		}
+		if index != s.lastIndex()+1 {
+			return ErrLogCorrupted
+		}
+		value, err := iter.Value()
+		if err != nil {
+			return err
+		}
+		entry, err := decodeEntry(index, value)
+		if err != nil {
+			return err
+		}
+		s.entries = append(s.entries, entry)
+	}
+	return iter.Err()
+}
+
+func (s *logStore) snapshotIndex() uint64 {
+	return s.entries[0].Index
+}
+
+func (s *logStore) snapshotTerm() uint64 {
+	return s.entries[0].Term
+}
+
+func (s *logStore) lastIndex() uint64 {
+	return s.entries[len(s.entries)-1].Index
+}
+
+func (s *logStore) lastTerm() uint64 {
+	return s.entries[len(s.entries)-1].Term
+}
+
+// 获取日志的任期，日志已经被压缩或者不存在时返回 false
+func (s *logStore) termAt(index uint64) (uint64, bool) {
+	if index < s.snapshotIndex() || index > s.lastIndex() {
+		return 0, false
+	}
+	return s.entries[index-s.snapshotIndex()].Term, true
+}
+
+// 获取 [lo, hi) 范围内的日志，lo 需要大于快照的位置
+func (s *logStore) slice(lo, hi uint64) []Entry {
+	offset := s.snapshotIndex()
+	entries := make([]Entry, hi-lo)
+	copy(entries, s.entries[lo-offset:hi-offset])
+	return entries
+}
+
+// 持久化当前任期以及投票给的节点
+func (s *logStore) setHardState(term uint64, votedFor string) error {
+	if term == s.term && votedFor == s.votedFor {
+		return nil
+	}
+	buf := make([]byte, binary.MaxVarintLen64+len(votedFor))
+	n := binary.PutUvarint(buf, term)
+	n += copy(buf[n:], votedFor)
+	wb := s.newWriteBatch(1)
+	_ = wb.Put([]byte(hardStateKey), buf[:n])
+	if err := wb.Commit(); err != nil {
+		return err
+	}
+	s.term, s.votedFor = term, votedFor
+	return nil
+}
+
+// 在日志末尾追加日志，日志的 index 需要是连续的
+func (s *logStore) append(entries []Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	if entries[0].Index != s.lastIndex()+1 {
+		return ErrLogCorrupted
+	}
+	wb := s.newWriteBatch(len(entries))
+	for i := range entries {
+		_ = wb.Put(entryKey(entries[i].Index), encodeEntry(&entries[i]))
+	}
+	if err := wb.Commit(); err != nil {
+		return err
+	}
+	s.entries = append(s.entries, entries...)
+	return nil
+}
+
+// 删除 index 以及之后的所有日志
+func (s *logStore) truncate(index uint64) error {
+	if index > s.lastIndex() {
+		return nil
+	}
+	if index <= s.snapshotIndex() {
+		return ErrLogCorrupted
+	}
+	wb := s.newWriteBatch(int(s.lastIndex() - index + 1))
+	for i := index; i <= s.lastIndex(); i++ {
+		_ = wb.Delete(entryKey(i))
+	}
+	if err := wb.Commit(); err != nil {
+		return err
+	}
+	s.entries = s.entries[:index-s.snapshotIndex()]
+	return nil
+}
+
+// 生成快照之后压缩日志，删除 index 以及之前的所有日志
+// 本地的日志和快照冲突时，快照之后的日志也全部丢弃
+func (s *logStore) compact(index, term uint64) error {
+	if index <= s.snapshotIndex() {
+		return nil
+	}
+	var remain []Entry
+	if t, ok := s.termAt(index); ok && t == term {
+		remain = s.entries[index-s.snapshotIndex()+1:]
+	}
+
+	deleteTo := s.lastIndex()
+	if len(remain) > 0 {
+		deleteTo = index
+	}
+	wb := s.newWriteBatch(int(deleteTo - s.snapshotIndex()))
+	buf := make([]byte, binary.MaxVarintLen64*2)
+	n := binary.PutUvarint(buf, index)
+	n += binary.PutUvarint(buf[n:], term)
+	_ = wb.Put([]byte(snapshotKey), buf[:n])
+	for i := s.snapshotIndex() + 1; i <= deleteTo; i++ {
+		_ = wb.Delete(entryKey(i))
+	}
+	if err := wb.Commit(); err != nil {
+		return err
+	}
+
+	entries := make([]Entry, 0, len(remain)+1)
+	entries = append(entries, Entry{Index: index, Term: term})
+	s.entries = append(entries, remain...)
+	return nil
+}
+
+func (s *logStore) close() error {
+	return s.db.Close()
+}
+
+func (s *logStore) newWriteBatch(n int) *lingDB.WriteBatch {
+	return s.db.NewWriteBatch(lingDB.WriteBatchOptions{MaxBatchNum: uint(n) + 1, SyncWrites: true})
+}
+
+func entryKey(index uint64) []byte {
+	key := make([]byte, len(entryKeyPrefix)+8)
+	copy(key, entryKeyPrefix)
+	binary.BigEndian.PutUint64(key[len(entryKeyPrefix):], index)
+	return key
+}
+
+// 日志编码为 任期 | 类型 | 数据
+func encodeEntry(entry *Entry) []byte {
+	buf := make([]byte, binary.MaxVarintLen64+1+len(entry.Data))
+	n := binary.PutUvarint(buf, entry.Term)
+	buf[n] = byte(entry.Type)
+	n++
+	n += copy(buf[n:], entry.Data)
+	return buf[:n]
+}
+
+func decodeEntry(index uint64, buf []byte) (Entry, error) {
+	term, n := binary.Uvarint(buf)
+	if n <= 0 || n >= len(buf) {
+		return Entry{}, ErrLogCorrupted
+	}
+	return Entry{Index: index, Term: term, Type: EntryType(buf[n]), Data: buf[n+1:]}, nil
+}
@@ -0,0 +1,53 @@
+package raft
+
+import (
+	lingDB "LingDB/LingDB-go"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestLogStore(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-raft-log")
+	defer os.RemoveAll(dir)
+	s, err := openLogStore(dir, lingDB.DefaultOptions)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), s.lastIndex())
+
+	assert.Nil(t, s.setHardState(3, "node-1"))
+	var entries []Entry
+	for i := uint64(1); i <= 10; i++ {
+		entries = append(entries, Entry{Index: i, Term: 1 + i/5, Type: EntryCommand, Data: []byte{byte(i)}})
+	}
+	assert.Nil(t, s.append(entries))
+	assert.Equal(t, ErrLogCorrupted, s.append([]Entry{{Index: 20, Term: 3}}))
+	assert.Nil(t, s.truncate(9))
+	assert.Equal(t, uint64(8), s.lastIndex())
+	assert.Nil(t, s.compact(4, 1))
+	assert.Equal(t, uint64(4), s.snapshotIndex())
+	_, ok := s.termAt(3)
+	assert.False(t, ok)
+	assert.Equal(t, []Entry{entries[4], entries[5]}, s.slice(5, 7))
+	assert.Nil(t, s.close())
+
+	// 重新打开之后恢复任期、投票以及快照之后的日志
+	s, err = openLogStore(dir, lingDB.DefaultOptions)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), s.term)
+	assert.Equal(t, "node-1", s.votedFor)
+	assert.Equal(t, uint64(4), s.snapshotIndex())
+	assert.Equal(t, uint64(1), s.snapshotTerm())
+	assert.Equal(t, uint64(8), s.lastIndex())
+	assert.Equal(t, entries[7], s.slice(8, 9)[0])
+
+	// 和快照冲突的日志全部丢弃
+	assert.Nil(t, s.compact(12, 5))
+	assert.Equal(t, uint64(12), s.lastIndex())
+	assert.Equal(t, uint64(5), s.lastTerm())
+	assert.Nil(t, s.close())
+
+	s, err = openLogStore(dir, lingDB.DefaultOptions)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(12), s.lastIndex())
+	assert.Nil(t, s.close())
+}
@@ -0,0 +1,227 @@
+package raft
+
+import (
+	"context"
+	"sync"
+)
+
+// EntryType 日志条目的类型
+type EntryType byte
+
+const (
+	// EntryNoop leader 在任期开始时追加的空日志，用于提交之前任期的日志
+	EntryNoop EntryType = iota
+	// EntryCommand 需要应用到数据库中的写入命令
+	EntryCommand
+)
+
+// Entry raft 日志条目
+type Entry struct {
+	Index uint64
+	Term  uint64
+	Type  EntryType
+	Data  []byte
+}
+
+// RequestVoteRequest 候选人发起的投票请求
+// PreVote 为 true 时为预投票，Term 为候选人准备使用的任期，接收方不会改变自身的状态
+type RequestVoteRequest struct {
+	Term         uint64
+	CandidateID  string
+	LastLogIndex uint64
+	LastLogTerm  uint64
+	PreVote      bool
+}
+
+// RequestVoteResponse 投票请求的结果
+type RequestVoteResponse struct {
+	Term        uint64
+	VoteGranted bool
+}
+
+// AppendEntriesRequest leader 发送的日志复制请求，没有日志时作为心跳
+type AppendEntriesRequest struct {
+	Term         uint64
+	LeaderID     string
+	PrevLogIndex uint64
+	PrevLogTerm  uint64
+	Entries      []Entry
+	LeaderCommit uint64
+}
+
+// AppendEntriesResponse 日志复制请求的结果
+// 失败时 ConflictIndex 为 leader 下一次需要发送的日志位置
+type AppendEntriesResponse struct {
+	Term          uint64
+	Success       bool
+	MatchIndex    uint64
+	ConflictIndex uint64
+}
+
+// SnapshotFile 快照目录中的一个文件
+type SnapshotFile struct {
+	Name string
+	Data []byte
+}
+
+// InstallSnapshotRequest leader 向落后的节点发送快照
+type InstallSnapshotRequest struct {
+	Term              uint64
+	LeaderID          string
+	LastIncludedIndex uint64
+	LastIncludedTerm  uint64
+	Files             []SnapshotFile
+}
+
+// InstallSnapshotResponse 安装快照的结果
+type InstallSnapshotResponse struct {
+	Term uint64
+}
+
+// Handler 处理其他节点发送过来的请求，由 Node 实现
+type Handler interface {
+	HandleRequestVote(req *RequestVoteRequest) (*RequestVoteResponse, error)
+	HandleAppendEntries(req *AppendEntriesRequest) (*AppendEntriesResponse, error)
+	HandleInstallSnapshot(req *InstallSnapshotRequest) (*InstallSnapshotResponse, error)
+}
+
+// Transport 节点之间的通信方式，可以基于任意的网络协议实现
+type Transport interface {
+	// Listen 开始接收其他节点的请求，并交给 handler 处理
+	Listen(handler Handler) error
+	// RequestVote 向 peer 发送投票请求
+	RequestVote(ctx context.Context, peer string, req *RequestVoteRequest) (*RequestVoteResponse, error)
+	// AppendEntries 向 peer 发送日志复制请求
+	AppendEntries(ctx context.Context, peer string, req *AppendEntriesRequest) (*AppendEntriesResponse, error)
+	// InstallSnapshot 向 peer 发送快照
+	InstallSnapshot(ctx context.Context, peer string, req *InstallSnapshotRequest) (*InstallSnapshotResponse, error)
+	// Close 停止接收请求
+	Close() error
+}
+
+// MemoryNetwork 在同一个进程中连接多个节点的内存网络，主要用于测试
+// 可以断开某个节点模拟网络分区
+type MemoryNetwork struct {
+	mu           *sync.RWMutex
+	handlers     map[string]Handler
+	disconnected map[string]bool
+}
+
+// NewMemoryNetwork 创建一个内存网络
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{
+		mu:           new(sync.RWMutex),
+		handlers:     make(map[string]Handler),
+		disconnected: make(map[string]bool),
+	}
+}
+
+// Transport 获取节点 id 在内存网络中使用的 Transport
+func (mn *MemoryNetwork) Transport(id string) Transport {
+	return &memoryTransport{network: mn, id: id}
+}
+
+// Disconnect 断开节点与其他所有节点之间的连接
+func (mn *MemoryNetwork) Disconnect(id string) {
+	mn.mu.Lock()
+	defer mn.mu.Unlock()
+	mn.disconnected[id] = true
+}
+
+// Connect 恢复节点与其他节点之间的连接
+func (mn *MemoryNetwork) Connect(id string) {
+	mn.mu.Lock()
+	defer mn.mu.Unlock()
+	delete(mn.disconnected, id)
+}
+
+// 获取 to 节点的 Handler，两个节点之间无法通信时返回 nil
+func (mn *MemoryNetwork) route(from, to string) Handler {
+	mn.mu.RLock()
+	defer mn.mu.RUnlock()
+	if mn.disconnected[from] || mn.disconnected[to] {
+		return nil
+	}
+	if _, ok := mn.handlers[from]; !ok {
+		return nil
+	}
+	return mn.handlers[to]
+}
+
+type memoryTransport struct {
+	network *MemoryNetwork
+	id      string
+}
+
+func (mt *memoryTransport) Listen(handler Handler) error {
+	mt.network.mu.Lock()
+	defer mt.network.mu.Unlock()
+	mt.network.handlers[mt.id] = handler
+	return nil
+}
+
+func (mt *memoryTransport) Close() error {
+	mt.network.mu.Lock()
+	defer mt.network.mu.Unlock()
+	delete(mt.network.handlers, mt.id)
+	return nil
+}
+
+func (mt *memoryTransport) RequestVote(ctx context.Context, peer string, req *RequestVoteRequest) (*RequestVoteResponse, error) {
+	handler, err := mt.handler(ctx, peer)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := handler.HandleRequestVote(req)
+	return resp, mt.checkResponse(ctx, peer, err)
+}
+
+func (mt *memoryTransport) AppendEntries(ctx context.Context, peer string, req *AppendEntriesRequest) (*AppendEntriesResponse, error) {
+	handler, err := mt.handler(ctx, peer)
+	if err != nil {
+		return nil, err
+	}
+	// 复制一份日志，模拟网络传输，接收方不会和发送方共享内存
+	copied := *req
+	copied.Entries = make([]Entry, len(req.Entries))
+	for i, entry := range req.Entries {
+		copied.Entries[i] = entry
+		copied.Entries[i].Data = append([]byte(nil), entry.Data...)
+	}
+	resp, err := handler.HandleAppendEntries(&copied)
+	return resp, mt.checkResponse(ctx, peer, err)
+}
+
+func (mt *memoryTransport) InstallSnapshot(ctx context.Context, peer string, req *InstallSnapshotRequest) (*InstallSnapshotResponse, error) {
+	handler, err := mt.handler(ctx, peer)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := handler.HandleInstallSnapshot(req)
+	return resp, mt.checkResponse(ctx, peer, err)
+}
+
+func (mt *memoryTransport) handler(ctx context.Context, peer string) (Handler, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	handler := mt.network.route(mt.id, peer)
+	if handler == nil {
+		return nil, ErrPeerUnreachable
+	}
+	return handler, nil
+}
+
+// 请求处理期间连接被断开时，响应同样会丢失
+func (mt *memoryTransport) checkResponse(ctx context.Context, peer string, err error) error {
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if mt.network.route(mt.id, peer) == nil {
+		return ErrPeerUnreachable
+	}
+	return nil
+}
@@ -0,0 +1,767 @@
+package LingDB_go
+
+import (
+	"LingDB/LingDB-go/data"
+	"LingDB/LingDB-go/index"
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// 主从复制协议的版本，副本连接时发送，版本不一致时主库断开连接
+const replicationVersion byte = 2
+
+// 主从复制的消息类型，每条消息编码为 type | uvarint(len) | payload
+const (
+	replMsgHello  byte = iota + 1 // 副本 -> 主库：协议版本、副本记录的主库 merge 信息以及本地日志末尾的位置
+	replMsgReset                  // 主库 -> 副本：副本的数据文件和主库不一致，清空之后从头同步
+	replMsgBucket                 // 主库 -> 副本：bucket 名称和 id 的对应关系
+	replMsgRecord                 // 主库 -> 副本：一条编码后的记录以及其在主库中的位置 (Fid, Offset)
+)
+
+// 日志中的位置，副本的数据文件和主库的 id 一致，offset 为文件中之前所有记录编码后的长度之和
+// 加密的文件中每条记录额外占用 RecordOverhead 的长度，不计入 offset，主库和副本可以使用不同的加密配置
+type replicaPosition struct {
+	fid    uint32
+	offset int64
+}
+
+// 副本连接主库时发送的握手消息
+type replicaHello struct {
+	synced bool            // 副本是否已经从主库同步过，没有同步过的副本需要从头同步
+	gen    mergeGeneration // 副本记录的主库 merge 信息
+	pos    replicaPosition // 副本本地日志末尾的位置
+}
+
+// 数据文件有新的写入时唤醒等待的发送任务
+type appendSignal struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+// 返回一个在下一次写入时被关闭的通道
+func (s *appendSignal) wait() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ch == nil {
+		s.ch = make(chan struct{})
+	}
+	return s.ch
+}
+
+// 唤醒所有等待的发送任务，没有等待者时无需分配通道
+func (s *appendSignal) notify() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ch != nil {
+		close(s.ch)
+		s.ch = nil
+	}
+}
+
+// ServeReplica 作为主库向连接另一端的副本发送日志，阻塞直到副本断开连接、ctx 被取消或者数据库关闭，返回时关闭连接
+// 副本从其本地日志的末尾开始追赶，先发送封存文件中的记录，之后持续发送新追加的记录
+// 副本的数据文件和主库不一致时（例如主库重启后安装了 merge 文件）通知副本清空数据后从头同步
+func (db *DB) ServeReplica(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+	stop := closeConnOnDone(ctx, db, conn)
+	defer close(stop)
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	typ, payload, err := readReplMessage(r, db.maxReplicationMessageSize())
+	if err != nil {
+		return db.replicationError(ctx, err)
+	}
+	if typ != replMsgHello {
+		return ErrInvalidReplication
+	}
+	hello, err := decodeReplicaHello(payload)
+	if err != nil {
+		return err
+	}
+
+	// 副本在握手之后不再发送消息，读取返回说明副本断开了连接
+	disconnected := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.Discard, r)
+		close(disconnected)
+	}()
+
+	db.mu.RLock()
+	fileOff, resume := db.resumeReplicaOffset(hello)
+	gen := db.mergeGen
+	db.mu.RUnlock()
+
+	sender := &replicaSender{db: db, w: w, pos: hello.pos, fileOff: fileOff, sentBuckets: make(map[uint32]bool)}
+	if !resume {
+		db.logger.Info("replica out of sync, resync from the beginning", "fid", hello.pos.fid, "offset", hello.pos.offset)
+		if err := writeReplMessage(w, replMsgReset, appendMergeGeneration(nil, &gen)); err != nil {
+			return db.replicationError(ctx, err)
+		}
+		sender.pos = replicaPosition{}
+		sender.fileOff = 0
+	}
+
+	for {
+		// 先获取通知再发送，保证发送过程中追加的记录不会被遗漏
+		signal := db.appendSignal.wait()
+		if err := sender.sendPending(); err != nil {
+			return db.replicationError(ctx, err)
+		}
+		if err := w.Flush(); err != nil {
+			return db.replicationError(ctx, err)
+		}
+
+		select {
+		case <-signal:
+		case <-disconnected:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-db.closeCh:
+			return ErrDatabaseClosed
+		}
+	}
+}
+
+// 副本是否可以从其本地日志的末尾继续同步，可以时返回继续发送的记录在主库文件中的偏移量，调用方需要持有读锁
+func (db *DB) resumeReplicaOffset(hello *replicaHello) (int64, bool) {
+	if !hello.synced || hello.gen != db.mergeGen {
+		return 0, false
+	}
+	if db.activeFile == nil {
+		return 0, hello.pos == replicaPosition{}
+	}
+	if hello.pos.fid > db.activeFile.FileId {
+		return 0, false
+	}
+	if hello.pos.fid == db.activeFile.FileId {
+		// 活跃文件只能读取到写入位置，之后可能是预先分配的空间
+		return fileOffset(db.activeFile, hello.pos.offset, db.activeFile.WriteOff)
+	}
+	if dataFile, ok := db.olderFiles[hello.pos.fid]; ok {
+		size, err := dataFile.Size()
+		if err != nil {
+			return 0, false
+		}
+		return fileOffset(dataFile, hello.pos.offset, size)
+	}
+	return 0, hello.pos.offset == 0
+}
+
+// 将日志中的位置转换为记录在文件中的偏移量，位置不是一条记录的开头时返回 false
+// 加密的文件中需要从头扫描文件，累加每条记录去掉额外长度之后的长度，size 为文件中可以读取的长度
+func fileOffset(dataFile *data.DataFile, offset int64, size int64) (int64, bool) {
+	overhead := dataFile.RecordOverhead()
+	if overhead == 0 {
+		return offset, offset <= size
+	}
+	var fileOff, logOff int64
+	for logOff < offset {
+		_, recordSize, err := dataFile.ReadLogRecord(fileOff)
+		if err != nil {
+			return 0, false
+		}
+		fileOff += recordSize
+		logOff += recordSize - overhead
+	}
+	return fileOff, logOff == offset && fileOff <= size
+}
+
+// 向一个副本发送日志
+type replicaSender struct {
+	db          *DB
+	w           *bufio.Writer
+	pos         replicaPosition // 下一条需要发送的记录的位置
+	fileOff     int64           // 下一条需要发送的记录在主库文件中的偏移量
+	sentBuckets map[uint32]bool // 已经发送过的 bucket
+}
+
+// 发送副本还没有收到的 bucket 以及记录，只发送到当前活跃文件的写入位置
+// 运行期间数据文件不会被删除，读取时无需持有锁
+func (s *replicaSender) sendPending() error {
+	db := s.db
+	db.mu.RLock()
+	var buckets []*Bucket
+	for id, b := range db.buckets {
+		if b.name != "" && !s.sentBuckets[id] {
+			buckets = append(buckets, b)
+		}
+	}
+	var dataFiles []*data.DataFile
+	for fid, dataFile := range db.olderFiles {
+		if fid >= s.pos.fid {
+			dataFiles = append(dataFiles, dataFile)
+		}
+	}
+	sort.Slice(dataFiles, func(i, j int) bool {
+		return dataFiles[i].FileId < dataFiles[j].FileId
+	})
+	activeFile := db.activeFile
+	var activeWriteOff int64
+	if activeFile != nil && activeFile.FileId >= s.pos.fid {
+		dataFiles = append(dataFiles, activeFile)
+		activeWriteOff = activeFile.WriteOff
+	}
+	db.mu.RUnlock()
+
+	// bucket 在写入数据之前创建，先于记录发送
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].id < buckets[j].id
+	})
+	for _, b := range buckets {
+		payload := binary.AppendUvarint(nil, uint64(b.id))
+		payload = append(payload, b.name...)
+		if err := writeReplMessage(s.w, replMsgBucket, payload); err != nil {
+			return err
+		}
+		s.sentBuckets[b.id] = true
+	}
+
+	for _, dataFile := range dataFiles {
+		if dataFile.FileId != s.pos.fid {
+			s.pos = replicaPosition{fid: dataFile.FileId}
+			s.fileOff = 0
+		}
+		for dataFile != activeFile || s.fileOff < activeWriteOff {
+			encRecord, size, err := dataFile.ReadRawLogRecord(s.fileOff)
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+			payload := binary.AppendUvarint(nil, uint64(s.pos.fid))
+			payload = binary.AppendVarint(payload, s.pos.offset)
+			payload = append(payload, encRecord...)
+			if err := writeReplMessage(s.w, replMsgRecord, payload); err != nil {
+				return err
+			}
+			s.pos.offset += int64(len(encRecord))
+			s.fileOff += size
+		}
+	}
+	return nil
+}
+
+// ReplicateFrom 作为只读副本从连接另一端的主库同步日志，阻塞直到连接断开、ctx 被取消或者数据库关闭，返回时关闭连接
+// 收到的记录追加到本地相同 id 的数据文件的相同位置，并按照重放日志的方式更新内存索引以及通知 Watch 的订阅方
+// 调用之后数据库成为只读副本，不能再写入数据；第一次同步或者主库安装了 merge 文件之后，副本会清空本地数据并从头同步
+// 副本的 DataFileSize 不能小于主库的，超过一个数据文件大小的消息视为损坏的数据
+func (db *DB) ReplicateFrom(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+	if !atomic.CompareAndSwapInt32(&db.replicating, 0, 1) {
+		return ErrReplicationInProgress
+	}
+	defer atomic.StoreInt32(&db.replicating, 0)
+	atomic.StoreInt32(&db.replica, 1)
+	stop := closeConnOnDone(ctx, db, conn)
+	defer close(stop)
+
+	hello, err := db.replicaHello()
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(conn)
+	if err := writeReplMessage(w, replMsgHello, encodeReplicaHello(hello)); err != nil {
+		return db.replicationError(ctx, err)
+	}
+	if err := w.Flush(); err != nil {
+		return db.replicationError(ctx, err)
+	}
+
+	r := bufio.NewReader(conn)
+	for {
+		typ, payload, err := readReplMessage(r, db.maxReplicationMessageSize())
+		if err != nil {
+			return db.replicationError(ctx, err)
+		}
+		switch typ {
+		case replMsgReset:
+			var gen *mergeGeneration
+			gen, err = decodeMergeGeneration(&replDecoder{buf: payload})
+			if err == nil {
+				err = db.resetReplica(gen)
+			}
+		case replMsgBucket:
+			err = db.applyReplicatedBucket(payload)
+		case replMsgRecord:
+			err = db.applyReplicatedRecord(payload)
+		default:
+			err = ErrInvalidReplication
+		}
+		if err != nil {
+			return db.replicationError(ctx, err)
+		}
+	}
+}
+
+// 数据库是否为只读副本
+func (db *DB) isReplica() bool {
+	return atomic.LoadInt32(&db.replica) == 1
+}
+
+// 构造副本的握手消息
+func (db *DB) replicaHello() (*replicaHello, error) {
+	hello := &replicaHello{}
+	fileName := filepath.Join(db.options.DirPath, data.ReplicaMetaFileName)
+	if _, err := db.fs.Stat(fileName); err == nil {
+		hello.synced = true
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	hello.gen = db.mergeGen
+	if db.activeFile != nil {
+		end, err := db.replicaEndPosition()
+		if err != nil {
+			return nil, err
+		}
+		hello.pos = end
+	}
+	return hello, nil
+}
+
+// 副本活跃文件末尾在主库日志中的位置，调用方需要持有锁
+// 加密的活跃文件第一次调用时需要扫描文件，之后随写入更新
+func (db *DB) replicaEndPosition() (replicaPosition, error) {
+	if db.replicaEnd != nil && db.replicaEnd.fid == db.activeFile.FileId {
+		return *db.replicaEnd, nil
+	}
+	offset, err := logOffset(db.activeFile, db.activeFile.WriteOff)
+	if err != nil {
+		return replicaPosition{}, err
+	}
+	return replicaPosition{fid: db.activeFile.FileId, offset: offset}, nil
+}
+
+// 将文件中的偏移量 fileOff 转换为日志中的位置，fileOff 需要是一条记录的开头
+func logOffset(dataFile *data.DataFile, fileOff int64) (int64, error) {
+	overhead := dataFile.RecordOverhead()
+	if overhead == 0 {
+		return fileOff, nil
+	}
+	var off, offset int64
+	for off < fileOff {
+		_, size, err := dataFile.ReadLogRecord(off)
+		if err != nil {
+			return 0, err
+		}
+		off += size
+		offset += size - overhead
+	}
+	return offset, nil
+}
+
+// 加载副本记录的主库 merge 信息，存在该文件的数据库为只读副本
+func (db *DB) loadReplicaMeta() error {
+	fileName := filepath.Join(db.options.DirPath, data.ReplicaMetaFileName)
+	if _, err := db.fs.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+
+	metaFile, err := data.OpenReplicaMetaFile(db.options.DirPath, db.cipher, db.fs)
+	if err != nil {
+		return err
+	}
+	defer metaFile.Close()
+	gen, err := readMergeGeneration(metaFile)
+	if err != nil {
+		return err
+	}
+	db.mergeGen = *gen
+	db.changesFloor = gen.changesFloor
+	db.seqNo = gen.seqNo
+	atomic.StoreInt32(&db.replica, 1)
+	return nil
+}
+
+// 清空副本本地的数据以及 bucket，记录主库的 merge 信息，之后从头同步主库的日志
+func (db *DB) resetReplica(gen *mergeGeneration) error {
+	// 等待正在进行的变更重放完成，重放会读取将要删除的数据文件
+	db.replayMu.Lock()
+	defer db.replayMu.Unlock()
+	db.checkpointMu.Lock()
+	defer db.checkpointMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.logger.Warn("reset replica data files", "nonMergeFileId", gen.nonMergeFileId)
+
+	// 等待后台生成 hint 文件的任务完成，这些任务会读取将要删除的数据文件
+	db.hintWg.Wait()
+
+	dataFiles := make([]*data.DataFile, 0, len(db.olderFiles)+1)
+	for _, dataFile := range db.olderFiles {
+		dataFiles = append(dataFiles, dataFile)
+	}
+	if db.activeFile != nil {
+		dataFiles = append(dataFiles, db.activeFile)
+	}
+	for _, dataFile := range dataFiles {
+		if err := dataFile.Close(); err != nil {
+			return err
+		}
+		for _, name := range []string{
+			data.GetDataFileName(db.options.DirPath, dataFile.FileId),
+			data.GetDataHintFileName(db.options.DirPath, dataFile.FileId),
+			data.GetBloomFileName(db.options.DirPath, dataFile.FileId),
+		} {
+			if err := db.fs.Remove(name); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if db.readCache != nil {
+			db.readCache.RemoveFile(dataFile.FileId)
+		}
+	}
+	for _, name := range []string{
+		data.HintFileName,
+		data.CheckpointFileName,
+		data.MergeFinishedFileName,
+		data.BucketMetaFileName,
+		data.ReplicaMetaFileName,
+	} {
+		if err := db.fs.Remove(filepath.Join(db.options.DirPath, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	db.activeFile = nil
+	db.replicaEnd = nil
+	db.olderFiles = make(map[uint32]*data.DataFile)
+	db.bloomFilters = make(map[uint32]*data.BloomFilter)
+	db.activeKeyHashes = make(map[uint64]struct{})
+	db.bloomRebuilds = make(map[uint32][]uint64)
+	db.checkpoint = nil
+	db.pendingTxns = make(map[uint64][]*data.TransactionRecord)
+	// 保留 bucket 对象，用户持有的 bucket 在主库重新发送名称之后仍然可用
+	for _, b := range db.buckets {
+		clearIndex(b.index)
+		b.name = ""
+	}
+	db.bucketIds = make(map[string]uint32)
+	db.mergeGen = *gen
+	db.changesFloor = gen.changesFloor
+	atomic.StoreUint64(&db.seqNo, gen.seqNo)
+
+	metaFile, err := data.OpenReplicaMetaFile(db.options.DirPath, db.cipher, db.fs)
+	if err != nil {
+		return err
+	}
+	defer metaFile.Close()
+	return writeMergeGeneration(metaFile, gen)
+}
+
+// 删除索引中所有的数据
+func clearIndex(idx index.Indexer) {
+	iterator := idx.Iterator(false)
+	var keys [][]byte
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		keys = append(keys, iterator.Key())
+	}
+	iterator.Close()
+	for _, key := range keys {
+		idx.Delete(key)
+	}
+}
+
+// 记录主库发送的 bucket
+func (db *DB) applyReplicatedBucket(payload []byte) error {
+	d := &replDecoder{buf: payload}
+	id := uint32(d.uvarint())
+	name := string(d.rest())
+	if d.err != nil || name == "" {
+		return ErrInvalidReplication
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if existing, ok := db.bucketIds[name]; ok {
+		if existing != id {
+			return ErrReplicaOutOfSync
+		}
+		return nil
+	}
+	if err := appendBucketMeta(db.options.DirPath, db.cipher, db.fs, name, id); err != nil {
+		return err
+	}
+	b := db.bucketById(id)
+	b.name = name
+	db.bucketIds[name] = id
+	return nil
+}
+
+// 将主库发送的记录追加到本地日志的相同位置，并更新内存索引
+func (db *DB) applyReplicatedRecord(payload []byte) error {
+	d := &replDecoder{buf: payload}
+	fid := uint32(d.uvarint())
+	offset := d.varint()
+	encRecord := d.rest()
+	if d.err != nil {
+		return ErrInvalidReplication
+	}
+	logRecord, size, err := data.DecodeLogRecord(encRecord)
+	if err != nil {
+		return err
+	}
+	if size != int64(len(encRecord)) {
+		return ErrInvalidReplication
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 主库切换了活跃文件，新文件的第一条记录位于文件开头
+	if db.activeFile == nil || db.activeFile.FileId != fid {
+		if offset != 0 || (db.activeFile != nil && fid < db.activeFile.FileId) {
+			return ErrReplicaOutOfSync
+		}
+		if db.activeFile == nil {
+			dataFile, err := db.openActiveDataFile(fid)
+			if err != nil {
+				return err
+			}
+			db.activeFile = dataFile
+		} else if err := db.rotateActiveFileTo(fid); err != nil {
+			return err
+		}
+	}
+	end, err := db.replicaEndPosition()
+	if err != nil {
+		return err
+	}
+	if offset != end.offset {
+		return ErrReplicaOutOfSync
+	}
+
+	// 记录在本地文件中的位置，加密配置不同时和主库中的位置不同
+	writeOff := db.activeFile.WriteOff
+	if err := db.activeFile.Write(encRecord); err != nil {
+		return err
+	}
+	end.offset += size
+	db.replicaEnd = &end
+	atomic.AddUint64(&db.metrics.bytesWritten, uint64(size))
+	if db.options.SyncWrites {
+		if err := db.syncActiveFile(); err != nil {
+			return err
+		}
+	}
+
+	realKey, seqNo := parseLogRecordKey(logRecord.Key)
+	if seqNo > db.seqNo {
+		atomic.StoreUint64(&db.seqNo, seqNo)
+	}
+
+	// 事务完成，对应的 seq no 的数据可以更新到内存索引中
+	if logRecord.Type == data.LogRecordTxnFinished {
+		for _, txnRecord := range db.pendingTxns[seqNo] {
+			if err := db.applyReplicatedChange(txnRecord.Record, txnRecord.Pos, seqNo); err != nil {
+				return err
+			}
+		}
+		delete(db.pendingTxns, seqNo)
+		return nil
+	}
+
+	db.collectBloomKey(fid, realKey)
+	record := &data.LogRecord{Key: realKey, Value: logRecord.Value, Type: logRecord.Type, BucketId: logRecord.BucketId}
+	pos := &data.LogRecordPos{Fid: fid, Offset: writeOff}
+	if seqNo == nonTransactionSeqNo || logRecord.AutoCommit {
+		return db.applyReplicatedChange(record, pos, seqNo)
+	}
+	db.pendingTxns[seqNo] = append(db.pendingTxns[seqNo], &data.TransactionRecord{Record: record, Pos: pos})
+	return nil
+}
+
+// 更新一条已经提交的记录的内存索引并通知订阅方，record 的 key 不包含序列号
+func (db *DB) applyReplicatedChange(record *data.LogRecord, pos *data.LogRecordPos, seqNo uint64) error {
+	if err := db.replayIndexUpdate(record.BucketId, record.Key, record.Type, pos); err != nil {
+		return err
+	}
+	switch record.Type {
+	case data.LogRecordNormal:
+		db.notifyWatchers(record.BucketId, ChangePut, record.Key, record.Value, seqNo)
+	case data.LogRecordDeleted:
+		db.notifyWatchers(record.BucketId, ChangeDelete, record.Key, nil, seqNo)
+	case data.LogRecordMergeOperand:
+		db.notifyWatchers(record.BucketId, ChangeMerge, record.Key, record.Value, seqNo)
+	}
+	return nil
+}
+
+// ctx 被取消或者数据库关闭时关闭连接，使阻塞的读写立即返回
+func closeConnOnDone(ctx context.Context, db *DB, conn net.Conn) chan struct{} {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-db.closeCh:
+			_ = conn.Close()
+		case <-stop:
+		}
+	}()
+	return stop
+}
+
+// 连接被关闭导致的错误替换为关闭的原因，对端正常断开连接时返回 nil
+func (db *DB) replicationError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if db.isClosed() {
+		return ErrDatabaseClosed
+	}
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// 单条消息的最大长度，避免读取到损坏的长度时分配过大的内存
+// 记录的长度一般不超过一个数据文件的大小，记录消息中还有其在主库日志中的位置
+func (db *DB) maxReplicationMessageSize() uint64 {
+	return uint64(db.options.DataFileSize) + data.MaxRecordOverhead + 2*binary.MaxVarintLen64
+}
+
+func writeReplMessage(w *bufio.Writer, typ byte, payload []byte) error {
+	header := make([]byte, 1, 1+binary.MaxVarintLen64)
+	header[0] = typ
+	header = binary.AppendUvarint(header, uint64(len(payload)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+func readReplMessage(r *bufio.Reader, maxSize uint64) (byte, []byte, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	if size > maxSize {
+		return 0, nil, ErrReplicationMessageTooLarge
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return typ, payload, nil
+}
+
+func encodeReplicaHello(hello *replicaHello) []byte {
+	buf := []byte{replicationVersion, 0}
+	if hello.synced {
+		buf[1] = 1
+	}
+	buf = appendMergeGeneration(buf, &hello.gen)
+	buf = binary.AppendUvarint(buf, uint64(hello.pos.fid))
+	return binary.AppendVarint(buf, hello.pos.offset)
+}
+
+func decodeReplicaHello(buf []byte) (*replicaHello, error) {
+	d := &replDecoder{buf: buf}
+	if d.byte() != replicationVersion {
+		return nil, ErrInvalidReplication
+	}
+	hello := &replicaHello{synced: d.byte() == 1}
+	gen, err := decodeMergeGeneration(d)
+	if err != nil {
+		return nil, err
+	}
+	hello.gen = *gen
+	hello.pos.fid = uint32(d.uvarint())
+	hello.pos.offset = d.varint()
+	if d.err != nil {
+		return nil, d.err
+	}
+	return hello, nil
+}
+
+func appendMergeGeneration(buf []byte, gen *mergeGeneration) []byte {
+	buf = binary.AppendUvarint(buf, uint64(gen.nonMergeFileId))
+	buf = binary.AppendUvarint(buf, gen.seqNo)
+	return binary.AppendUvarint(buf, gen.changesFloor)
+}
+
+func decodeMergeGeneration(d *replDecoder) (*mergeGeneration, error) {
+	gen := &mergeGeneration{
+		nonMergeFileId: uint32(d.uvarint()),
+		seqNo:          d.uvarint(),
+		changesFloor:   d.uvarint(),
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return gen, nil
+}
+
+// 解码消息内容，遇到错误之后的读取都返回零值
+type replDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *replDecoder) byte() byte {
+	if d.err != nil || len(d.buf) == 0 {
+		d.err = ErrInvalidReplication
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *replDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = ErrInvalidReplication
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *replDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = ErrInvalidReplication
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *replDecoder) rest() []byte {
+	buf := d.buf
+	d.buf = nil
+	return buf
+}
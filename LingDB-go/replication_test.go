@@ -0,0 +1,335 @@
+package LingDB_go
+
+import (
+	"LingDB/LingDB-go/data"
+	"LingDB/LingDB-go/utils"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 通过内存管道连接的主库和副本
+type replicationPair struct {
+	cancel     context.CancelFunc
+	primaryErr chan error
+	replicaErr chan error
+}
+
+func startReplication(primary, replica *DB) *replicationPair {
+	ctx, cancel := context.WithCancel(context.Background())
+	primaryConn, replicaConn := net.Pipe()
+	p := &replicationPair{cancel: cancel, primaryErr: make(chan error, 1), replicaErr: make(chan error, 1)}
+	go func() {
+		p.primaryErr <- primary.ServeReplica(ctx, primaryConn)
+	}()
+	go func() {
+		p.replicaErr <- replica.ReplicateFrom(ctx, replicaConn)
+	}()
+	return p
+}
+
+func (p *replicationPair) stop(t *testing.T) {
+	p.cancel()
+	assert.Equal(t, context.Canceled, <-p.primaryErr)
+	assert.Equal(t, context.Canceled, <-p.replicaErr)
+}
+
+func logEndPosition(db *DB) replicaPosition {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.activeFile == nil {
+		return replicaPosition{}
+	}
+	offset, err := logOffset(db.activeFile, db.activeFile.WriteOff)
+	if err != nil {
+		panic(err)
+	}
+	return replicaPosition{fid: db.activeFile.FileId, offset: offset}
+}
+
+// 等待副本追上主库
+func waitForReplica(t *testing.T, primary, replica *DB) {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if logEndPosition(primary) == logEndPosition(replica) && primary.SeqNo() == replica.SeqNo() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("replica did not catch up, primary %+v, replica %+v", logEndPosition(primary), logEndPosition(replica))
+}
+
+func foldAll(t *testing.T, db *DB, bucketName string) map[string]string {
+	b, err := db.Bucket(bucketName)
+	assert.Nil(t, err)
+	values := make(map[string]string)
+	err = b.Fold(func(key []byte, value []byte) bool {
+		values[string(key)] = string(value)
+		return true
+	})
+	assert.Nil(t, err)
+	return values
+}
+
+func assertSameData(t *testing.T, primary, replica *DB) {
+	assert.Equal(t, primary.BucketNames(), replica.BucketNames())
+	for _, name := range append(primary.BucketNames(), "") {
+		assert.Equal(t, foldAll(t, primary, name), foldAll(t, replica, name))
+	}
+}
+
+func dataFileNames(t *testing.T, dirPath string) []string {
+	entries, err := os.ReadDir(dirPath)
+	assert.Nil(t, err)
+	var names []string
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+func replicationOptions(name string) Options {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", name)
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.MergeOperator = ByteAppendOperator{Separator: []byte(",")}
+	return opts
+}
+
+func writeReplicationData(t *testing.T, db *DB, start, end int) {
+	users, err := db.Bucket("users")
+	assert.Nil(t, err)
+	for i := start; i < end; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i%200), utils.RandomValue(64)))
+		switch i % 10 {
+		case 0:
+			assert.Nil(t, db.Delete(utils.GetTestKey((i+7)%200)))
+		case 1:
+			assert.Nil(t, db.MergeValue([]byte("merged"), utils.GetTestKey(i)))
+		case 2:
+			wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+			assert.Nil(t, wb.Put(utils.GetTestKey(i), []byte("batch")))
+			assert.Nil(t, wb.BucketPut(users, utils.GetTestKey(i), []byte("batch")))
+			assert.Nil(t, wb.Commit())
+		case 3:
+			assert.Nil(t, users.Put(utils.GetTestKey(i%50), utils.RandomValue(16)))
+		}
+	}
+}
+
+func TestDB_Replication(t *testing.T) {
+	primaryOpts := replicationOptions("bitcask-go-primary")
+	primary, err := Open(primaryOpts)
+	defer destroyDB(primary)
+	assert.Nil(t, err)
+	replicaOpts := replicationOptions("bitcask-go-replica")
+	replica, err := Open(replicaOpts)
+	defer destroyDB(replica)
+	assert.Nil(t, err)
+
+	// 副本从封存的文件开始追赶
+	writeReplicationData(t, primary, 0, 1000)
+	assert.True(t, len(dataFileNames(t, primaryOpts.DirPath)) > 1)
+	pair := startReplication(primary, replica)
+	waitForReplica(t, primary, replica)
+	assertSameData(t, primary, replica)
+	assert.Equal(t, dataFileNames(t, primaryOpts.DirPath), dataFileNames(t, replicaOpts.DirPath))
+
+	// 持续同步主库新追加的记录
+	watcher := replica.Watch([]byte("merged"))
+	writeReplicationData(t, primary, 1000, 1500)
+	waitForReplica(t, primary, replica)
+	assertSameData(t, primary, replica)
+	event := <-watcher.Events()
+	assert.Equal(t, ChangeMerge, event.Op)
+	watcher.Close()
+
+	// 副本是只读的
+	assert.Equal(t, ErrReadOnlyReplica, replica.Put([]byte("key"), []byte("value")))
+	_, err = replica.Bucket("new-bucket")
+	assert.Equal(t, ErrReadOnlyReplica, err)
+	assert.Equal(t, ErrReadOnlyReplica, replica.Merge())
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	assert.Equal(t, ErrReplicationInProgress, replica.ReplicateFrom(context.Background(), c1))
+	pair.stop(t)
+
+	// 副本重启之后从本地日志的末尾继续同步，不会重新同步已有的数据
+	err = replica.Close()
+	assert.Nil(t, err)
+	written := primary.Metrics().BytesWritten
+	writeReplicationData(t, primary, 1500, 2000)
+	replica, err = Open(replicaOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrReadOnlyReplica, replica.Put([]byte("key"), []byte("value")))
+
+	pair = startReplication(primary, replica)
+	waitForReplica(t, primary, replica)
+	assertSameData(t, primary, replica)
+	assert.Equal(t, primary.Metrics().BytesWritten-written, replica.Metrics().BytesWritten)
+	pair.stop(t)
+}
+
+// 加密的文件中每条记录占用的长度不同，主库和副本使用不同的加密配置时仍然可以同步以及继续同步
+func TestDB_Replication_Encryption(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 16)
+	for name, keys := range map[string][2][]byte{
+		"encrypted-replica": {nil, key},
+		"encrypted-primary": {key, nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			primaryOpts := replicationOptions("bitcask-go-primary-encryption")
+			primaryOpts.EncryptionKey = keys[0]
+			primary, err := Open(primaryOpts)
+			defer destroyDB(primary)
+			assert.Nil(t, err)
+			replicaOpts := replicationOptions("bitcask-go-replica-encryption")
+			replicaOpts.EncryptionKey = keys[1]
+			replica, err := Open(replicaOpts)
+			defer destroyDB(replica)
+			assert.Nil(t, err)
+
+			writeReplicationData(t, primary, 0, 1000)
+			pair := startReplication(primary, replica)
+			waitForReplica(t, primary, replica)
+			assertSameData(t, primary, replica)
+			pair.stop(t)
+
+			// 副本重启之后从本地日志的末尾继续同步
+			assert.Nil(t, replica.Close())
+			written := primary.Metrics().BytesWritten
+			writeReplicationData(t, primary, 1000, 1500)
+			replica, err = Open(replicaOpts)
+			assert.Nil(t, err)
+			pair = startReplication(primary, replica)
+			waitForReplica(t, primary, replica)
+			assertSameData(t, primary, replica)
+			assert.Equal(t, primary.Metrics().BytesWritten-written, replica.Metrics().BytesWritten)
+			pair.stop(t)
+		})
+	}
+}
+
+func TestDB_Replication_MergeInstalled(t *testing.T) {
+	primaryOpts := replicationOptions("bitcask-go-primary-merge")
+	primary, err := Open(primaryOpts)
+	defer destroyDB(primary)
+	assert.Nil(t, err)
+	replicaOpts := replicationOptions("bitcask-go-replica-merge")
+	replica, err := Open(replicaOpts)
+	defer destroyDB(replica)
+	assert.Nil(t, err)
+
+	writeReplicationData(t, primary, 0, 1000)
+	pair := startReplication(primary, replica)
+	waitForReplica(t, primary, replica)
+	pair.stop(t)
+
+	// 主库重启之后安装 merge 文件，文件 id 被复用，副本需要清空数据后从头同步
+	assert.Nil(t, primary.Merge())
+	assert.Nil(t, primary.Close())
+	primary, err = Open(primaryOpts)
+	assert.Nil(t, err)
+	writeReplicationData(t, primary, 1000, 1200)
+
+	pair = startReplication(primary, replica)
+	waitForReplica(t, primary, replica)
+	assertSameData(t, primary, replica)
+	assert.Equal(t, dataFileNames(t, primaryOpts.DirPath), dataFileNames(t, replicaOpts.DirPath))
+	pair.stop(t)
+
+	// 副本重启之后通过重放数据文件恢复索引，并继续从主库同步
+	assert.Nil(t, replica.Close())
+	replica, err = Open(replicaOpts)
+	assert.Nil(t, err)
+	assertSameData(t, primary, replica)
+	assert.Equal(t, primary.SeqNo(), replica.SeqNo())
+
+	written := primary.Metrics().BytesWritten
+	writeReplicationData(t, primary, 1200, 1300)
+	pair = startReplication(primary, replica)
+	waitForReplica(t, primary, replica)
+	assertSameData(t, primary, replica)
+	assert.Equal(t, primary.Metrics().BytesWritten-written, replica.Metrics().BytesWritten)
+	pair.stop(t)
+}
+
+func TestDB_Replication_MessageTooLarge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-replica-large")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	replica, err := Open(opts)
+	defer destroyDB(replica)
+	assert.Nil(t, err)
+
+	primaryConn, replicaConn := net.Pipe()
+	replicaErr := make(chan error, 1)
+	go func() {
+		replicaErr <- replica.ReplicateFrom(context.Background(), replicaConn)
+	}()
+
+	// 读取副本的握手消息之后，发送一个长度远超数据文件大小的记录消息
+	r := bufio.NewReader(primaryConn)
+	typ, _, err := readReplMessage(r, replica.maxReplicationMessageSize())
+	assert.Nil(t, err)
+	assert.Equal(t, replMsgHello, typ)
+	header := binary.AppendUvarint([]byte{replMsgRecord}, 1<<40)
+	_, err = primaryConn.Write(header)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrReplicationMessageTooLarge, <-replicaErr)
+	_ = primaryConn.Close()
+
+	// 不超过上限的消息可以正常读取
+	payload := make([]byte, opts.DataFileSize)
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	assert.Nil(t, writeReplMessage(w, replMsgRecord, payload))
+	typ, read, err := readReplMessage(bufio.NewReader(&buf), replica.maxReplicationMessageSize())
+	assert.Nil(t, err)
+	assert.Equal(t, replMsgRecord, typ)
+	assert.Equal(t, payload, read)
+}
+
+func TestDB_Replication_ResetWithOpenIterator(t *testing.T) {
+	primaryOpts := replicationOptions("bitcask-go-primary-reset-iter")
+	primary, err := Open(primaryOpts)
+	defer destroyDB(primary)
+	assert.Nil(t, err)
+	replicaOpts := replicationOptions("bitcask-go-replica-reset-iter")
+	replica, err := Open(replicaOpts)
+	defer destroyDB(replica)
+	assert.Nil(t, err)
+
+	writeReplicationData(t, primary, 0, 300)
+	pair := startReplication(primary, replica)
+	waitForReplica(t, primary, replica)
+	pair.stop(t)
+
+	// 副本重置时清空了数据文件，之前打开的迭代器读取数据返回错误，不会访问已经关闭的文件
+	iterator := replica.NewIterator(DefaultIteratorOptions)
+	iterator.Rewind()
+	assert.True(t, iterator.Valid())
+	assert.Nil(t, replica.resetReplica(&mergeGeneration{}))
+	_, err = iterator.Value()
+	assert.Equal(t, ErrDataFileNotFound, err)
+	iterator.Close()
+	_, err = replica.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 重新同步之后数据和主库一致
+	pair = startReplication(primary, replica)
+	waitForReplica(t, primary, replica)
+	pair.stop(t)
+	assertSameData(t, primary, replica)
+}
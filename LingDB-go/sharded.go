@@ -0,0 +1,252 @@
+package LingDB_go
+
+import (
+	"LingDB/LingDB-go/data"
+	"LingDB/LingDB-go/fio"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const shardCountKey = "shard.count"
+
+// ShardedDB 按照 key 的哈希值将数据分散到多个 DB 实例中，每个实例有独立的活跃文件和锁，写入可以并行进行
+type ShardedDB struct {
+	options   ShardedOptions
+	shards    []*DB
+	isMerging int32
+}
+
+// OpenSharded 打开分片数据库，每个分片位于 DirPath 下的一个子目录中
+func OpenSharded(options ShardedOptions) (*ShardedDB, error) {
+	if options.ShardCount <= 0 {
+		return nil, ErrInvalidShardCount
+	}
+	// 所有分片使用同一个文件系统
+	fileSystem := openFileSystem(&options.Options)
+	if err := checkOptions(options.Options); err != nil {
+		return nil, err
+	}
+	if err := fileSystem.MkdirAll(options.Options.DirPath); err != nil {
+		return nil, err
+	}
+	// 分片数量决定了 key 所在的分片，之后不能再改变
+	if err := checkShardCount(options.Options.DirPath, newCipher(options.Options), fileSystem, options.ShardCount); err != nil {
+		return nil, err
+	}
+
+	sdb := &ShardedDB{options: options, shards: make([]*DB, options.ShardCount)}
+	for i := range sdb.shards {
+		shardOptions := options.Options
+		shardOptions.DirPath = filepath.Join(options.Options.DirPath, fmt.Sprintf("shard-%03d", i))
+		db, err := Open(shardOptions)
+		if err != nil {
+			_ = sdb.Close()
+			return nil, err
+		}
+		sdb.shards[i] = db
+	}
+	return sdb, nil
+}
+
+// 校验分片数量和创建时是否一致，第一次打开时记录分片数量
+func checkShardCount(dirPath string, c *data.Cipher, fs fio.FileSystem, shardCount int) error {
+	if err := data.UpgradeFile(filepath.Join(dirPath, data.ShardMetaFileName), c, fs); err != nil {
+		return err
+	}
+	metaFile, err := data.OpenShardMetaFile(dirPath, c, fs)
+	if err != nil {
+		return err
+	}
+	defer metaFile.Close()
+
+	record, _, err := metaFile.ReadLogRecord(0)
+	if err == io.EOF {
+		encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+			Key:   []byte(shardCountKey),
+			Value: []byte(strconv.Itoa(shardCount)),
+		})
+		if err := metaFile.Write(encRecord); err != nil {
+			return err
+		}
+		return metaFile.Sync()
+	}
+	if err != nil {
+		return err
+	}
+	count, err := strconv.Atoi(string(record.Value))
+	if err != nil {
+		return ErrDataDirectoryCorrupted
+	}
+	if count != shardCount {
+		return ErrShardCountMismatch
+	}
+	return nil
+}
+
+// 获取 key 所在分片的下标
+func (sdb *ShardedDB) shardIndex(key []byte) int {
+	h := fnv.New64a()
+	_, _ = h.Write(key)
+	return int(h.Sum64() % uint64(len(sdb.shards)))
+}
+
+// 获取 key 所在的分片
+func (sdb *ShardedDB) shard(key []byte) *DB {
+	return sdb.shards[sdb.shardIndex(key)]
+}
+
+// ShardCount 分片的数量
+func (sdb *ShardedDB) ShardCount() int {
+	return len(sdb.shards)
+}
+
+// Put 写入数据到 key 所在的分片
+func (sdb *ShardedDB) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return sdb.shard(key).Put(key, value)
+}
+
+// Get 从 key 所在的分片中读取数据
+func (sdb *ShardedDB) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	return sdb.shard(key).Get(key)
+}
+
+// Delete 从 key 所在的分片中删除数据
+func (sdb *ShardedDB) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return sdb.shard(key).Delete(key)
+}
+
+// Sync 持久化所有分片的活跃文件
+func (sdb *ShardedDB) Sync() error {
+	for _, db := range sdb.shards {
+		if err := db.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close 关闭所有的分片
+func (sdb *ShardedDB) Close() error {
+	var firstErr error
+	for _, db := range sdb.shards {
+		if db == nil {
+			continue
+		}
+		if err := db.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Merge 依次 merge 所有的分片
+func (sdb *ShardedDB) Merge() error {
+	return sdb.MergeContext(context.Background())
+}
+
+// MergeContext 同 Merge，每次只 merge 一个分片，避免同时占用成倍的磁盘空间
+// 任意一个分片 merge 失败或者 ctx 被取消时停止，已经完成的分片在重启之后生效
+func (sdb *ShardedDB) MergeContext(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&sdb.isMerging, 0, 1) {
+		return ErrMergeIsProgress
+	}
+	defer atomic.StoreInt32(&sdb.isMerging, 0)
+
+	for _, db := range sdb.shards {
+		if err := db.MergeContext(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ShardedWriteBatch 分片数据库的批量写，每个分片中的写入原子提交
+type ShardedWriteBatch struct {
+	sdb     *ShardedDB
+	options WriteBatchOptions
+	mu      *sync.Mutex
+	batches map[int]*WriteBatch
+}
+
+// NewWriteBatch 初始化分片数据库的批量写，MaxBatchNum 限制的是每个分片中的数据量
+func (sdb *ShardedDB) NewWriteBatch(opts WriteBatchOptions) *ShardedWriteBatch {
+	return &ShardedWriteBatch{
+		sdb:     sdb,
+		options: opts,
+		mu:      new(sync.Mutex),
+		batches: make(map[int]*WriteBatch),
+	}
+}
+
+// 获取 key 所在分片的批次
+func (swb *ShardedWriteBatch) batch(key []byte) *WriteBatch {
+	i := swb.sdb.shardIndex(key)
+	swb.mu.Lock()
+	defer swb.mu.Unlock()
+	wb, ok := swb.batches[i]
+	if !ok {
+		wb = swb.sdb.shards[i].NewWriteBatch(swb.options)
+		swb.batches[i] = wb
+	}
+	return wb
+}
+
+// Put 批量写数据
+func (swb *ShardedWriteBatch) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return swb.batch(key).Put(key, value)
+}
+
+// Delete 删除数据
+func (swb *ShardedWriteBatch) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return swb.batch(key).Delete(key)
+}
+
+// Commit 并行提交每个分片中的批次
+// 每个分片中的写入要么全部生效要么全部不生效，但是不同分片之间不保证原子性
+// 部分分片提交失败时返回第一个错误，失败分片中的数据仍然暂存在批次中，可以再次提交
+func (swb *ShardedWriteBatch) Commit() error {
+	swb.mu.Lock()
+	defer swb.mu.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(swb.sdb.shards))
+	for i, wb := range swb.batches {
+		wg.Add(1)
+		go func(i int, wb *WriteBatch) {
+			defer wg.Done()
+			errs[i] = wb.Commit()
+		}(i, wb)
+	}
+	wg.Wait()
+
+	var firstErr error
+	for i, err := range errs {
+		if err == nil {
+			delete(swb.batches, i)
+		} else if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
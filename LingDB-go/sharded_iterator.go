@@ -0,0 +1,139 @@
+package LingDB_go
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+)
+
+// ShardedIterator 按照 key 的顺序合并遍历所有分片的迭代器
+// 同一个 key 只会位于一个分片中，合并时无需去重
+type ShardedIterator struct {
+	iters []*Iterator
+	heap  *iteratorHeap
+}
+
+// NewIterator 初始化遍历所有分片的迭代器
+func (sdb *ShardedDB) NewIterator(opts IteratorOptions) *ShardedIterator {
+	return sdb.NewIteratorContext(context.Background(), opts)
+}
+
+// NewIteratorContext 同 NewIterator，ctx 被取消后迭代器失效，可以通过 Err 获取取消的原因
+func (sdb *ShardedDB) NewIteratorContext(ctx context.Context, opts IteratorOptions) *ShardedIterator {
+	it := &ShardedIterator{
+		iters: make([]*Iterator, len(sdb.shards)),
+		heap:  &iteratorHeap{reverse: opts.Reverse},
+	}
+	for i, db := range sdb.shards {
+		it.iters[i] = db.NewIteratorContext(ctx, opts)
+	}
+	it.rebuild()
+	return it
+}
+
+// Rewind 重新回到迭代器的起点
+func (it *ShardedIterator) Rewind() {
+	for _, iter := range it.iters {
+		iter.Rewind()
+	}
+	it.rebuild()
+}
+
+// Seek 在所有分片中查找第一个大于（或小于）等于 key 的位置，从这个 key 开始遍历
+func (it *ShardedIterator) Seek(key []byte) {
+	for _, iter := range it.iters {
+		iter.Seek(key)
+	}
+	it.rebuild()
+}
+
+// Next 跳转到下一个 key
+func (it *ShardedIterator) Next() {
+	if it.heap.Len() == 0 {
+		return
+	}
+	top := it.heap.iters[0]
+	top.Next()
+	if top.Valid() {
+		heap.Fix(it.heap, 0)
+	} else {
+		heap.Pop(it.heap)
+	}
+}
+
+// Valid 是否还有没有遍历的 key
+func (it *ShardedIterator) Valid() bool {
+	return it.heap.Len() > 0 && it.heap.iters[0].Valid()
+}
+
+// Key 当前遍历位置的 Key 数据
+func (it *ShardedIterator) Key() []byte {
+	return it.heap.iters[0].Key()
+}
+
+// Value 当前遍历位置的 Value 数据
+func (it *ShardedIterator) Value() ([]byte, error) {
+	return it.heap.iters[0].Value()
+}
+
+// Err 返回遍历被取消的原因，没有被取消时返回 nil
+func (it *ShardedIterator) Err() error {
+	for _, iter := range it.iters {
+		if err := iter.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close 关闭所有分片的迭代器
+func (it *ShardedIterator) Close() {
+	for _, iter := range it.iters {
+		iter.Close()
+	}
+	it.heap.iters = nil
+}
+
+// 使用所有有效的分片迭代器重新构造堆
+func (it *ShardedIterator) rebuild() {
+	it.heap.iters = it.heap.iters[:0]
+	for _, iter := range it.iters {
+		if iter.Valid() {
+			it.heap.iters = append(it.heap.iters, iter)
+		}
+	}
+	heap.Init(it.heap)
+}
+
+// 按照当前 key 排序的迭代器堆，反向遍历时堆顶为最大的 key
+type iteratorHeap struct {
+	iters   []*Iterator
+	reverse bool
+}
+
+func (h *iteratorHeap) Len() int {
+	return len(h.iters)
+}
+
+func (h *iteratorHeap) Less(i, j int) bool {
+	cmp := bytes.Compare(h.iters[i].Key(), h.iters[j].Key())
+	if h.reverse {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+func (h *iteratorHeap) Swap(i, j int) {
+	h.iters[i], h.iters[j] = h.iters[j], h.iters[i]
+}
+
+func (h *iteratorHeap) Push(x interface{}) {
+	h.iters = append(h.iters, x.(*Iterator))
+}
+
+func (h *iteratorHeap) Pop() interface{} {
+	n := len(h.iters)
+	iter := h.iters[n-1]
+	h.iters = h.iters[:n-1]
+	return iter
+}
@@ -0,0 +1,204 @@
+package LingDB_go
+
+import (
+	"LingDB/LingDB-go/utils"
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sort"
+	"testing"
+)
+
+func TestOpenSharded(t *testing.T) {
+	opts := DefaultShardedOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-sharded-open")
+	defer os.RemoveAll(dir)
+	opts.Options.DirPath = dir
+	opts.ShardCount = 4
+	sdb, err := OpenSharded(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, sdb.ShardCount())
+	assert.Nil(t, sdb.Close())
+
+	// 分片数量不能改变
+	opts.ShardCount = 8
+	_, err = OpenSharded(opts)
+	assert.Equal(t, ErrShardCountMismatch, err)
+	opts.ShardCount = 0
+	_, err = OpenSharded(opts)
+	assert.Equal(t, ErrInvalidShardCount, err)
+}
+
+func TestShardedDB_PutGetDelete(t *testing.T) {
+	opts := DefaultShardedOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-sharded")
+	defer os.RemoveAll(dir)
+	opts.Options.DirPath = dir
+	opts.ShardCount = 4
+	sdb, err := OpenSharded(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, sdb.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, sdb.Delete(utils.GetTestKey(i)))
+	}
+	assert.Equal(t, ErrKeyIsEmpty, sdb.Put(nil, []byte("value")))
+
+	// 数据分散在所有的分片中
+	for _, db := range sdb.shards {
+		assert.True(t, len(db.ListKeys()) > 0)
+	}
+	_, err = sdb.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	value, err := sdb.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(500), value)
+
+	// 重启之后 key 仍然路由到同一个分片
+	assert.Nil(t, sdb.Close())
+	sdb, err = OpenSharded(opts)
+	assert.Nil(t, err)
+	defer sdb.Close()
+	for i := 100; i < 1000; i++ {
+		value, err := sdb.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), value)
+	}
+}
+
+func TestShardedDB_WriteBatch(t *testing.T) {
+	opts := DefaultShardedOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-sharded-batch")
+	defer os.RemoveAll(dir)
+	opts.Options.DirPath = dir
+	opts.ShardCount = 4
+	sdb, err := OpenSharded(opts)
+	assert.Nil(t, err)
+	defer sdb.Close()
+
+	assert.Nil(t, sdb.Put(utils.GetTestKey(0), []byte("value")))
+	wb := sdb.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 1; i < 100; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), []byte("batch")))
+	}
+	assert.Nil(t, wb.Delete(utils.GetTestKey(0)))
+
+	// 提交之前不可见
+	_, err = sdb.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, wb.Commit())
+	_, err = sdb.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	for i := 1; i < 100; i++ {
+		value, err := sdb.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("batch"), value)
+	}
+
+	// 每个分片中的数据量分别受到 MaxBatchNum 的限制
+	wb = sdb.NewWriteBatch(WriteBatchOptions{MaxBatchNum: 10})
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), []byte("too many")))
+	}
+	assert.Equal(t, ErrExceedMaxBatchNum, wb.Commit())
+}
+
+func TestShardedDB_Iterator(t *testing.T) {
+	opts := DefaultShardedOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-sharded-iter")
+	defer os.RemoveAll(dir)
+	opts.Options.DirPath = dir
+	opts.ShardCount = 5
+	sdb, err := OpenSharded(opts)
+	assert.Nil(t, err)
+	defer sdb.Close()
+
+	var expected [][]byte
+	for i := 0; i < 500; i++ {
+		key := utils.GetTestKey(i)
+		assert.Nil(t, sdb.Put(key, key))
+		expected = append(expected, key)
+	}
+	sort.Slice(expected, func(i, j int) bool {
+		return bytes.Compare(expected[i], expected[j]) < 0
+	})
+
+	iter := sdb.NewIterator(DefaultIteratorOptions)
+	var keys [][]byte
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		value, err := iter.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, iter.Key(), value)
+		keys = append(keys, iter.Key())
+	}
+	iter.Close()
+	assert.Equal(t, expected, keys)
+
+	// 反向遍历以及 Seek
+	iter = sdb.NewIterator(IteratorOptions{Reverse: true})
+	iter.Seek(expected[100])
+	keys = keys[:0]
+	for ; iter.Valid(); iter.Next() {
+		keys = append(keys, iter.Key())
+	}
+	iter.Close()
+	assert.Equal(t, 101, len(keys))
+	assert.Equal(t, expected[100], keys[0])
+	assert.Equal(t, expected[0], keys[100])
+
+	// 范围遍历
+	iter = sdb.NewIterator(IteratorOptions{LowerBound: expected[10], UpperBound: expected[20], UpperExclusive: true})
+	keys = keys[:0]
+	for ; iter.Valid(); iter.Next() {
+		keys = append(keys, iter.Key())
+	}
+	iter.Close()
+	assert.Equal(t, expected[10:20], keys)
+
+	// ctx 被取消之后迭代器失效
+	ctx, cancel := context.WithCancel(context.Background())
+	iter = sdb.NewIteratorContext(ctx, DefaultIteratorOptions)
+	assert.True(t, iter.Valid())
+	cancel()
+	assert.False(t, iter.Valid())
+	assert.Equal(t, context.Canceled, iter.Err())
+	iter.Close()
+}
+
+func TestShardedDB_Merge(t *testing.T) {
+	opts := DefaultShardedOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-sharded-merge")
+	defer os.RemoveAll(dir)
+	opts.Options.DirPath = dir
+	opts.Options.DataFileSize = 32 * 1024
+	opts.ShardCount = 3
+	sdb, err := OpenSharded(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 3000; i++ {
+		assert.Nil(t, sdb.Put(utils.GetTestKey(i%1000), utils.RandomValue(64)))
+	}
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, sdb.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, sdb.Merge())
+	assert.Nil(t, sdb.Close())
+
+	// 重启之后所有分片的 merge 都生效
+	sdb, err = OpenSharded(opts)
+	assert.Nil(t, err)
+	defer sdb.Close()
+	total := 0
+	for _, db := range sdb.shards {
+		assert.True(t, db.mergeGen.nonMergeFileId > 0)
+		total += len(db.ListKeys())
+	}
+	assert.Equal(t, 800, total)
+	_, err = sdb.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = sdb.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+}
@@ -0,0 +1,62 @@
+package LingDB_go
+
+import (
+	"LingDB/LingDB-go/data"
+	"context"
+)
+
+// Snapshot 将当前已经提交的所有数据 merge 到 dirPath 目录中，生成一个数据库的快照
+// 快照目录本身就是一个完整的数据目录，复制到其他位置后可以直接通过 Open 打开
+// 快照复用 merge 的流程，同样会触发 merge 事件，并且不能和 merge 同时进行；dirPath 不能已经存在
+func (db *DB) Snapshot(ctx context.Context, dirPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := db.fs.Stat(dirPath); err == nil {
+		return ErrSnapshotDirExists
+	}
+
+	// 数据库为空时只需要保留 bucket 的名称
+	if db.activeFile == nil {
+		if err := db.fs.MkdirAll(dirPath); err != nil {
+			return err
+		}
+		return db.writeSnapshotBuckets(dirPath)
+	}
+
+	nonMergeFileId, err := db.mergeTo(ctx, dirPath, true)
+	if err != nil {
+		return err
+	}
+	// 打开时 merge 完成文件之前的数据文件只从 hint 文件中加载索引，新的写入需要追加到一个空的活跃文件中
+	activeFile, err := data.OpenDataFile(dirPath, nonMergeFileId, db.cipher, db.fs)
+	if err == nil {
+		err = activeFile.Sync()
+		_ = activeFile.Close()
+	}
+	if err == nil {
+		err = db.writeSnapshotBuckets(dirPath)
+	}
+	if err != nil {
+		_ = db.fs.RemoveAll(dirPath)
+		return err
+	}
+	return nil
+}
+
+// 将 bucket 名称和 id 的对应关系写入到快照目录中
+func (db *DB) writeSnapshotBuckets(dirPath string) error {
+	db.mu.RLock()
+	bucketIds := make(map[string]uint32, len(db.bucketIds))
+	for name, id := range db.bucketIds {
+		bucketIds[name] = id
+	}
+	db.mu.RUnlock()
+
+	for name, id := range bucketIds {
+		if err := appendBucketMeta(dirPath, db.cipher, db.fs, name, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package LingDB_go
+
+import (
+	"LingDB/LingDB-go/utils"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_Snapshot(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-snapshot")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	users, err := db.Bucket("users")
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		assert.Nil(t, users.Put(utils.GetTestKey(i), []byte("user")))
+	}
+
+	snapDir := filepath.Join(os.TempDir(), "bitcask-go-snapshot-copy")
+	_ = os.RemoveAll(snapDir)
+	defer os.RemoveAll(snapDir)
+	err = db.Snapshot(context.Background(), snapDir)
+	assert.Nil(t, err)
+	err = db.Snapshot(context.Background(), snapDir)
+	assert.Equal(t, ErrSnapshotDirExists, err)
+
+	// 快照之后的写入不会出现在快照中
+	assert.Nil(t, db.Put(utils.GetTestKey(0), []byte("after snapshot")))
+
+	// 快照目录可以直接作为数据目录打开，并且可以继续写入
+	snapOpts := opts
+	snapOpts.DirPath = snapDir
+	snapDB, err := Open(snapOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, 900, len(snapDB.ListKeys()))
+	_, err = snapDB.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	for i := 100; i < 1000; i++ {
+		expected, _ := db.Get(utils.GetTestKey(i))
+		value, err := snapDB.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, value)
+	}
+	snapUsers, err := snapDB.Bucket("users")
+	assert.Nil(t, err)
+	assert.Equal(t, users.id, snapUsers.id)
+	assert.Equal(t, 100, len(snapUsers.ListKeys()))
+
+	assert.Nil(t, snapDB.Put([]byte("new-key"), []byte("new-value")))
+	assert.Nil(t, snapDB.Close())
+	snapDB, err = Open(snapOpts)
+	assert.Nil(t, err)
+	value, err := snapDB.Get([]byte("new-key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new-value"), value)
+	assert.Equal(t, 901, len(snapDB.ListKeys()))
+	assert.Nil(t, snapDB.Close())
+}
+
+func TestDB_Snapshot_ConcurrentWrites(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-snapshot-writes")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	var db *DB
+	// 快照开始写入文件时覆盖以及删除一部分 key
+	opts.EventListener.OnMergeBegin = func(info MergeInfo) {
+		for i := 0; i < 100; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("overwritten")))
+		}
+		for i := 100; i < 200; i++ {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		}
+	}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	values := make([][]byte, 1000)
+	for i := 0; i < 1000; i++ {
+		values[i] = utils.RandomValue(64)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+	}
+
+	snapDir, _ := os.MkdirTemp("", "bitcask-go-snapshot-writes-copy")
+	assert.Nil(t, os.RemoveAll(snapDir))
+	defer os.RemoveAll(snapDir)
+	assert.Nil(t, db.Snapshot(context.Background(), snapDir))
+
+	// 快照中保留的是开始时的数据
+	snapOpts := DefaultOptions
+	snapOpts.DirPath = snapDir
+	snapDB, err := Open(snapOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(snapDB.ListKeys()))
+	for i := 0; i < 1000; i++ {
+		value, err := snapDB.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], value)
+	}
+	assert.Nil(t, snapDB.Close())
+
+	value, err := db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("overwritten"), value)
+	assert.Equal(t, 900, len(db.ListKeys()))
+}
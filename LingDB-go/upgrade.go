@@ -0,0 +1,62 @@
+package LingDB_go
+
+import (
+	"LingDB/LingDB-go/data"
+	"path/filepath"
+	"sort"
+)
+
+// OutdatedFiles 获取需要升级的数据文件的 id，这些文件为旧的格式版本，或者使用轮换之前的 key 加密
+// 可以在线调用 Merge 重写这些文件，重启之后生效，也可以停止服务之后调用 Upgrade
+func (db *DB) OutdatedFiles() []uint32 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var fileIds []uint32
+	for fid, dataFile := range db.olderFiles {
+		if !dataFile.UpToDate(db.cipher) {
+			fileIds = append(fileIds, fid)
+		}
+	}
+	if db.activeFile != nil && !db.activeFile.UpToDate(db.cipher) {
+		fileIds = append(fileIds, db.activeFile.FileId)
+	}
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+	return fileIds
+}
+
+// Upgrade 离线升级数据目录，使用当前的格式版本以及加密 key 重写所有的文件
+// 存在需要升级的数据文件时执行一次 merge，然后重新打开数据库安装 merge 的结果，调用期间不能有其他进程打开该目录
+func Upgrade(options Options) error {
+	db, err := Open(options)
+	if err != nil {
+		return err
+	}
+	if len(db.OutdatedFiles()) == 0 {
+		return db.Close()
+	}
+	if err := db.Merge(); err != nil {
+		_ = db.Close()
+		return err
+	}
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	db, err = Open(options)
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// 升级 bucket 以及副本信息文件，数据文件、hint 文件等都会在 merge 时使用当前的格式版本以及加密 key 重写
+func (db *DB) upgradeMetaFiles() error {
+	for _, name := range []string{data.BucketMetaFileName, data.ReplicaMetaFileName} {
+		if err := data.UpgradeFile(filepath.Join(db.options.DirPath, name), db.cipher, db.fs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
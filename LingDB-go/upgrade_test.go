@@ -0,0 +1,107 @@
+package LingDB_go
+
+import (
+	"LingDB/LingDB-go/utils"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 去掉目录中所有文件的头部，模拟旧版本写入的数据目录
+func stripFileHeaders(t *testing.T, dir string) {
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		fileName := filepath.Join(dir, entry.Name())
+		content, err := os.ReadFile(fileName)
+		assert.Nil(t, err)
+		if bytes.HasPrefix(content, []byte("LINGDB")) {
+			assert.Nil(t, os.WriteFile(fileName, content[48:], 0644))
+		}
+	}
+}
+
+// 创建一个旧版本的数据目录
+func createLegacyDir(t *testing.T, opts Options) {
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	bucket, err := db.Bucket("legacy-bucket")
+	assert.Nil(t, err)
+	assert.Nil(t, bucket.Put([]byte("key"), []byte("value")))
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Close())
+	stripFileHeaders(t, opts.DirPath)
+}
+
+// 数据目录中的数据都完整，并且所有的文件都是最新的
+func checkUpgradedDir(t *testing.T, opts Options) {
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer db.Close()
+	assert.Equal(t, 0, len(db.OutdatedFiles()))
+	assert.Equal(t, 900, len(db.ListKeys()))
+	value, err := db.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(999), value)
+	bucket, err := db.Bucket("legacy-bucket")
+	assert.Nil(t, err)
+	value, err = bucket.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+
+	entries, err := os.ReadDir(opts.DirPath)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(opts.DirPath, entry.Name()))
+		assert.Nil(t, err)
+		assert.True(t, bytes.HasPrefix(content, []byte("LINGDB")), entry.Name())
+	}
+}
+
+func TestDB_OnlineUpgrade(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-online-upgrade")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DataFileSize = 8 * 1024
+	createLegacyDir(t, opts)
+
+	// 旧版本的文件可以正常读写
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.True(t, len(db.OutdatedFiles()) > 1)
+	assert.Equal(t, len(db.olderFiles)+1, len(db.OutdatedFiles()))
+	assert.Equal(t, 900, len(db.ListKeys()))
+	assert.Nil(t, db.Put(utils.GetTestKey(0), utils.GetTestKey(0)))
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+
+	// merge 重写所有旧版本的文件，重启之后生效
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	checkUpgradedDir(t, opts)
+}
+
+func TestUpgrade(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-offline-upgrade")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DataFileSize = 8 * 1024
+	createLegacyDir(t, opts)
+
+	assert.Nil(t, Upgrade(opts))
+	checkUpgradedDir(t, opts)
+
+	// 已经是最新的数据目录不会再 merge
+	assert.Nil(t, Upgrade(opts))
+	_, err := os.Stat(filepath.Join(filepath.Dir(dir), filepath.Base(dir)+mergeDirName))
+	assert.True(t, os.IsNotExist(err))
+	checkUpgradedDir(t, opts)
+}
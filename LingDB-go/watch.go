@@ -0,0 +1,191 @@
+package LingDB_go
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// ChangeOp 数据变更的类型
+type ChangeOp int8
+
+const (
+	// ChangePut 通过 Put 或者 WriteBatch 写入数据
+	ChangePut ChangeOp = iota + 1
+	// ChangeDelete 删除数据
+	ChangeDelete
+	// ChangeMerge 通过 MergeValue 追加合并操作数
+	ChangeMerge
+)
+
+// ChangeEvent 一次已经提交的数据变更
+type ChangeEvent struct {
+	Op    ChangeOp
+	Key   []byte
+	Value []byte // Put 时为写入的值，MergeValue 时为操作数，Delete 时为空
+	SeqNo uint64 // 变更的序列号，同一个 WriteBatch 中的变更序列号相同
+}
+
+// Watcher 订阅某个前缀下已经提交的数据变更
+// 变更在内存索引更新之后按照提交的顺序发送，写入方不会等待订阅方，缓冲区满时按照 OverflowPolicy 处理
+type Watcher struct {
+	db       *DB
+	bucketId uint32
+	prefix   []byte
+	policy   WatchOverflowPolicy
+	ch       chan *ChangeEvent
+	mu       *sync.Mutex
+	closed   bool
+	err      error
+	dropped  uint64
+}
+
+// Watch 订阅默认 bucket 中以 prefix 开头的 key 的变更，prefix 为空时订阅所有的 key
+func (db *DB) Watch(prefix []byte) *Watcher {
+	return db.WatchWithOptions(prefix, DefaultWatchOptions)
+}
+
+// WatchWithOptions 同 Watch，可以指定缓冲区大小以及缓冲区满时的处理方式
+func (db *DB) WatchWithOptions(prefix []byte, opts WatchOptions) *Watcher {
+	return db.watch(db.defaultBucket, prefix, opts)
+}
+
+// Watch 订阅 bucket 中以 prefix 开头的 key 的变更
+func (b *Bucket) Watch(prefix []byte, opts WatchOptions) *Watcher {
+	return b.db.watch(b, prefix, opts)
+}
+
+func (db *DB) watch(b *Bucket, prefix []byte, opts WatchOptions) *Watcher {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultWatchOptions.BufferSize
+	}
+	w := &Watcher{
+		db:       db,
+		bucketId: b.id,
+		prefix:   append([]byte(nil), prefix...),
+		policy:   opts.OverflowPolicy,
+		ch:       make(chan *ChangeEvent, opts.BufferSize),
+		mu:       new(sync.Mutex),
+	}
+
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	if db.isClosed() {
+		w.closeWithError(ErrDatabaseClosed)
+		return w
+	}
+	db.watchers[w] = struct{}{}
+	return w
+}
+
+// Events 接收变更的通道，订阅被关闭后通道也会被关闭
+func (w *Watcher) Events() <-chan *ChangeEvent {
+	return w.ch
+}
+
+// Err 订阅被动关闭的原因，例如缓冲区溢出或者数据库被关闭，主动调用 Close 时为 nil
+func (w *Watcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Dropped 缓冲区满时被丢弃的变更数量
+func (w *Watcher) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// Close 取消订阅并关闭通道
+func (w *Watcher) Close() {
+	w.db.watchMu.Lock()
+	delete(w.db.watchers, w)
+	w.db.watchMu.Unlock()
+	w.closeWithError(nil)
+}
+
+func (w *Watcher) closeWithError(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	w.closed = true
+	w.err = err
+	close(w.ch)
+}
+
+// 发送一个变更，不会阻塞
+func (w *Watcher) send(event *ChangeEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+
+	select {
+	case w.ch <- event:
+		return
+	default:
+	}
+
+	switch w.policy {
+	case WatchDropNewest:
+		atomic.AddUint64(&w.dropped, 1)
+	case WatchDropOldest:
+		// 写入方持有数据库的锁，同一时间只有一个发送方，取出一个旧的变更之后一定有空间
+		select {
+		case <-w.ch:
+			atomic.AddUint64(&w.dropped, 1)
+		default:
+		}
+		select {
+		case w.ch <- event:
+		default:
+			atomic.AddUint64(&w.dropped, 1)
+		}
+	default:
+		// 丢失变更之后订阅方无法再保持一致，关闭订阅让其重新同步
+		atomic.AddUint64(&w.dropped, 1)
+		w.closed = true
+		w.err = ErrWatchOverflow
+		close(w.ch)
+	}
+}
+
+// 将已经提交的变更发送给订阅了该 key 的 Watcher，调用方需要持有数据库的互斥锁，保证变更按照提交的顺序发送
+func (db *DB) notifyWatchers(bucketId uint32, op ChangeOp, key, value []byte, seqNo uint64) {
+	db.watchMu.RLock()
+	defer db.watchMu.RUnlock()
+	if len(db.watchers) == 0 {
+		return
+	}
+
+	var event *ChangeEvent
+	for w := range db.watchers {
+		if w.bucketId != bucketId || !bytes.HasPrefix(key, w.prefix) {
+			continue
+		}
+		// 用户可能复用传入的 key 和 value，需要拷贝一份
+		if event == nil {
+			event = &ChangeEvent{
+				Op:    op,
+				Key:   append([]byte(nil), key...),
+				SeqNo: seqNo,
+			}
+			if value != nil {
+				event.Value = append([]byte(nil), value...)
+			}
+		}
+		w.send(event)
+	}
+}
+
+// 数据库关闭时关闭所有的订阅
+func (db *DB) closeWatchers() {
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	for w := range db.watchers {
+		w.closeWithError(ErrDatabaseClosed)
+		delete(db.watchers, w)
+	}
+}
@@ -0,0 +1,123 @@
+package LingDB_go
+
+import (
+	"LingDB/LingDB-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_Watch(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch")
+	opts.DirPath = dir
+	opts.MergeOperator = ByteAppendOperator{}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	all := db.Watch(nil)
+	users := db.Watch([]byte("user:"))
+	defer users.Close()
+
+	value := []byte("v1")
+	assert.Nil(t, db.Put([]byte("user:1"), value))
+	// 修改传入的 value 不影响已经发送的变更
+	value[1] = '9'
+	assert.Nil(t, db.Put([]byte("order:1"), []byte("o1")))
+	assert.Nil(t, db.Delete([]byte("user:1")))
+	assert.Nil(t, db.Delete([]byte("user:not-exist")))
+	assert.Nil(t, db.MergeValue([]byte("user:2"), []byte("m")))
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("user:3"), []byte("b1")))
+	assert.Nil(t, wb.Put([]byte("user:4"), []byte("b2")))
+	assert.Nil(t, wb.Commit())
+
+	// 其他 bucket 中的变更不会发送给默认 bucket 的订阅
+	b, err := db.Bucket("other")
+	assert.Nil(t, err)
+	assert.Nil(t, b.Put([]byte("user:5"), []byte("x")))
+
+	recv := func(w *Watcher, n int) []*ChangeEvent {
+		var events []*ChangeEvent
+		for i := 0; i < n; i++ {
+			events = append(events, <-w.Events())
+		}
+		assert.Equal(t, 0, len(w.Events()))
+		return events
+	}
+
+	events := recv(users, 5)
+	assert.Equal(t, ChangePut, events[0].Op)
+	assert.Equal(t, []byte("user:1"), events[0].Key)
+	assert.Equal(t, []byte("v1"), events[0].Value)
+	assert.Equal(t, ChangeDelete, events[1].Op)
+	assert.Nil(t, events[1].Value)
+	assert.Equal(t, ChangeMerge, events[2].Op)
+	assert.Equal(t, []byte("m"), events[2].Value)
+	assert.True(t, events[0].SeqNo < events[1].SeqNo)
+	assert.True(t, events[1].SeqNo < events[2].SeqNo)
+	// 同一个批次中的变更序列号相同
+	assert.Equal(t, events[3].SeqNo, events[4].SeqNo)
+	assert.True(t, events[2].SeqNo < events[3].SeqNo)
+
+	assert.Equal(t, 6, len(recv(all, 6)))
+
+	// 主动关闭订阅
+	all.Close()
+	_, ok := <-all.Events()
+	assert.False(t, ok)
+	assert.Nil(t, all.Err())
+	assert.Nil(t, db.Put([]byte("user:6"), []byte("x")))
+
+	// 其他 bucket 的订阅
+	bw := b.Watch(nil, DefaultWatchOptions)
+	assert.Nil(t, b.Put([]byte("key"), []byte("x")))
+	event := <-bw.Events()
+	assert.Equal(t, []byte("key"), event.Key)
+
+	// 数据库关闭时关闭所有订阅
+	assert.Nil(t, db.Close())
+	<-users.Events()
+	_, ok = <-users.Events()
+	assert.False(t, ok)
+	assert.Equal(t, ErrDatabaseClosed, users.Err())
+	_, ok = <-bw.Events()
+	assert.False(t, ok)
+}
+
+func TestDB_Watch_Overflow(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-overflow")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	closeWatcher := db.WatchWithOptions(nil, WatchOptions{BufferSize: 5, OverflowPolicy: WatchCloseOnOverflow})
+	dropNewest := db.WatchWithOptions(nil, WatchOptions{BufferSize: 5, OverflowPolicy: WatchDropNewest})
+	dropOldest := db.WatchWithOptions(nil, WatchOptions{BufferSize: 5, OverflowPolicy: WatchDropOldest})
+
+	// 订阅方不消费时写入不会被阻塞
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("v")))
+	}
+
+	var keys [][]byte
+	for event := range closeWatcher.Events() {
+		keys = append(keys, event.Key)
+	}
+	assert.Equal(t, 5, len(keys))
+	assert.Equal(t, ErrWatchOverflow, closeWatcher.Err())
+
+	assert.Equal(t, uint64(15), dropNewest.Dropped())
+	assert.Equal(t, 5, len(dropNewest.Events()))
+	assert.Equal(t, utils.GetTestKey(0), (<-dropNewest.Events()).Key)
+
+	assert.Equal(t, uint64(15), dropOldest.Dropped())
+	assert.Equal(t, 5, len(dropOldest.Events()))
+	assert.Equal(t, utils.GetTestKey(15), (<-dropOldest.Events()).Key)
+	assert.Nil(t, dropOldest.Err())
+}